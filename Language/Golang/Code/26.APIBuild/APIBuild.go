@@ -1,154 +1,661 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"mime"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
 )
 
-type Course struct{
-	CourseId string `json:"courseId"`
-	CoursePrice int `json:"coursePrice"`
-	CourseName string `json:"courseName"`
-	CourseSite string `json:"courseSite"`
-    Author *Author `json:"author"`
-} 
+type Course struct {
+	CourseId    string `json:"courseId" xml:"courseId"`
+	CoursePrice int    `json:"coursePrice" xml:"coursePrice" validate:"min=0,max=1000000"`
+	CourseName  string `json:"courseName" xml:"courseName" validate:"required,max=120"`
+	CourseSite  string `json:"courseSite" xml:"courseSite" validate:"max=200"`
+	AuthorId    string `json:"authorId,omitempty" xml:"authorId,omitempty"`
+	// Author is only filled in for ?expand=author. Courses stored before
+	// authors had ids carry it instead of AuthorId until they are migrated.
+	Author  *Author `json:"author,omitempty" xml:"author,omitempty"`
+	Version int64   `json:"-" xml:"-"` // owned by the store, sent as the ETag
+}
+
+type Author struct {
+	AuthorId string `json:"authorId" xml:"authorId"`
+	Fullname string `json:"fullName" xml:"fullName" validate:"required,max=100"`
+	Website  string `json:"website" xml:"website" validate:"url,max=200"`
+	Version  int64  `json:"-" xml:"-"`
+}
 
-type Author struct{
-	Fullname string `json:"fullName"`
-	Website string `json:"website"`
+// server holds what the handlers depend on. Handlers never touch storage
+// directly, only through the CourseStore interface.
+type server struct {
+	store   CourseStore
+	ids     IdGenerator
+	auth    *authService
+	logger  *slog.Logger
+	metrics *apiMetrics
+	tracer  *Tracer
+	health  *health
+	// idempotency replays responses of POST /course retries
+	idempotency *idempotency
+	// cache answers course reads, nil when it is turned off
+	cache    *responseCache
+	search   *searchIndex
+	webhooks *dispatcher
+	// versionPolicies say which API versions are deprecated
+	versionPolicies map[apiVersion]versionPolicy
 }
 
+// errEmbeddedAuthor answers writes that still send the author inline.
+var errEmbeddedAuthor = ValidationErrors{{Field: "author", Message: "is read only, refer to an author by authorId"}}
 
-var courses []Course
+// writerRoles may create, change and delete courses. Reading is public.
+var writerRoles = []string{"instructor", "admin"}
 
-// middleware 
-func (c *Course) isEmpty() bool {
-	return c.CourseId == ""
+// clone returns a copy that does not share the Author pointer, so a course
+// handed out by a store can not be changed behind the store's back.
+func (c Course) clone() Course {
+	if c.Author != nil {
+		author := *c.Author
+		c.Author = &author
+	}
+	return c
 }
 
-func main(){
-	fmt.Println("API Buildding here") ;
-	r := mux.NewRouter()
+func main() {
+	// registered first so it runs last, after the other deferred cleanups
+	exitCode := 0
+	defer func() { os.Exit(exitCode) }()
+
+	loadServerConfig := serverConfigFlags(flag.CommandLine)
+	storeKind := flag.String("store", "memory", "storage backend: memory or file")
+	dataDir := flag.String("data", "data", "directory for the file store")
+	snapshotEvery := flag.Duration("snapshot-every", time.Minute, "how often the file store writes a snapshot")
+	idKind := flag.String("id", "uuidv7", "course id format: uuidv7 or ulid")
+	jwksPath := flag.String("jwt-keys", "", "JWKS file with the keys that may sign access tokens")
+	jwtIssuer := flag.String("jwt-issuer", "", "required iss claim, empty accepts any")
+	jwtAudience := flag.String("jwt-audience", "", "required aud claim, empty accepts any")
+	signingKid := flag.String("jwt-signing-kid", "", "kid of the key /login signs tokens with")
+	signingKeyPath := flag.String("jwt-signing-key", "", "PEM RSA private key to sign RS256 tokens with")
+	usersPath := flag.String("users", "", "JSON file with the accounts that may log in")
+	accessTTL := flag.Duration("access-ttl", 15*time.Minute, "lifetime of access tokens")
+	refreshTTL := flag.Duration("refresh-ttl", 30*24*time.Hour, "lifetime of refresh tokens")
+	sessionTTL := flag.Duration("session-ttl", 8*time.Hour, "lifetime of cookie sessions")
+	secureCookies := flag.Bool("secure-cookies", true, "only send the session cookie over HTTPS")
+	idempotencyTTL := flag.Duration("idempotency-ttl", 24*time.Hour, "how long a response is kept for Idempotency-Key retries")
+	traceExporter := flag.String("trace-exporter", "none", "where spans go: none, stdout or otlp")
+	otlpEndpoint := flag.String("otlp-endpoint", envOr("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318"), "OTLP/HTTP collector for -trace-exporter otlp")
+	traceSample := flag.Float64("trace-sample", 1, "share of new traces that are exported, 0 to 1")
+	outboxPoll := flag.Duration("outbox-poll", time.Second, "how often the outbox is checked for events to send to webhooks")
+	webhookAttempts := flag.Int("webhook-max-attempts", 8, "delivery attempts per webhook event before it is dead-lettered")
+	webhookBackoff := flag.Duration("webhook-backoff", time.Second, "wait before the first webhook retry, doubled after each")
+	webhookMaxBackoff := flag.Duration("webhook-max-backoff", 10*time.Minute, "longest wait between webhook retries")
+	cacheKind := flag.String("cache", "memory", "response cache for course reads: memory, redis or none")
+	cacheTTL := flag.Duration("cache-ttl", 30*time.Second, "how long a cached course response is fresh")
+	cacheStale := flag.Duration("cache-stale", time.Minute, "how long after cache-ttl a response is still served while it is refreshed")
+	redisAddr := flag.String("redis-addr", envOr("REDIS_ADDR", "localhost:6379"), "Redis protocol server for -cache redis")
+	v1Deprecated := flag.String("v1-deprecated", "", "date from which API v1 is deprecated, YYYY-MM-DD; empty keeps it current")
+	v1Sunset := flag.String("v1-sunset", "", "date API v1 is switched off, YYYY-MM-DD, announced in the Sunset header")
+	hashPass := flag.String("hash-password", "", "print the hash of a password for the users file and exit")
+	flag.Parse()
+
+	if *hashPass != "" {
+		hash, err := hashPassword(*hashPass)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(hash)
+		return
+	}
+
+	cfg, err := loadServerConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	v1Policy, err := parseVersionPolicy(*v1Deprecated, *v1Sunset)
+	if err != nil {
+		log.Fatalf("v1: %v", err)
+	}
+
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	// the log package and slog's top level functions end up here as well
+	slog.SetDefault(logger)
+
+	logger.Info("API Buildding here")
+
+	store, closeStore, err := openStore(*storeKind, *dataDir, *snapshotEvery)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer closeStore()
+
+	ids, err := newIdGenerator(*idKind)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := seedCourses(store); err != nil {
+		log.Fatal(err)
+	}
+	if err := migrateEmbeddedAuthors(store, ids); err != nil {
+		log.Fatal(err)
+	}
+	indexed, err := newIndexedStore(context.Background(), store)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	keys := newKeySet(*jwtIssuer, *jwtAudience)
+	if *jwksPath != "" {
+		if err := keys.LoadJWKS(*jwksPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		keys.AddHMAC("", []byte(secret))
+	}
+	if *signingKeyPath != "" {
+		key, err := loadRSAPrivateKey(*signingKeyPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		keys.AddRSAPrivate(*signingKid, key)
+	} else if err := keys.UseForSigning(*signingKid); err != nil {
+		logger.Warn("token login disabled", "error", err)
+	}
+	if keys.Empty() {
+		logger.Warn("no JWT keys configured, only session logins can write")
+	}
+
+	users, err := loadUsers(*usersPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	auth := &authService{
+		keys:          keys,
+		store:         newMemoryAuthStore(),
+		users:         users,
+		accessTTL:     *accessTTL,
+		refreshTTL:    *refreshTTL,
+		sessionTTL:    *sessionTTL,
+		secureCookies: *secureCookies,
+		now:           time.Now,
+	}
+
+	exporter, err := newSpanExporter(*traceExporter, *otlpEndpoint)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tracer := newTracer(exporter, *traceSample)
+	defer tracer.Shutdown(context.Background())
+
+	metrics := newAPIMetrics(store)
+	// the dispatcher reads the outbox of the plain store, a poll every
+	// second is not worth a trace
+	webhooks := newDispatcher(store, newMemoryWebhookStore(), webhookConfig{
+		PollEvery:   *outboxPoll,
+		MaxAttempts: *webhookAttempts,
+		Backoff:     *webhookBackoff,
+		MaxBackoff:  *webhookMaxBackoff,
+	}, tracer, logger, metrics.webhookDeliveries)
+	dispatchCtx, stopDispatch := context.WithCancel(context.Background())
+	dispatchDone := make(chan struct{})
+	go func() {
+		webhooks.run(dispatchCtx)
+		close(dispatchDone)
+	}()
+	defer func() {
+		stopDispatch()
+		<-dispatchDone
+	}()
+
+	// writes through invalidatingStore move cached responses out of the way
+	var backing CourseStore = indexed
+	var cache *responseCache
+	if *cacheKind != "none" {
+		cacheStore, err := openCacheStore(*cacheKind, *redisAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		backing = newInvalidatingStore(indexed, cacheStore, courseGenTTL(*cacheTTL, *cacheStale), logger)
+		cache = &responseCache{
+			store:    cacheStore,
+			ttl:      *cacheTTL,
+			staleFor: *cacheStale,
+			logger:   logger,
+			lookups:  metrics.cacheLookups,
+		}
+	}
 
-	courses = append(courses, Course{CourseId: "2", CourseName: "ReactJS", CoursePrice: 299, Author: &Author{Fullname: "Hitesh Choudhary", Website: "lco.dev"}})
-	courses = append(courses, Course{CourseId: "4", CourseName: "MERN Stack", CoursePrice: 199, Author: &Author{Fullname: "Hitesh Choudhary", Website: "go.dev"}})
+	s := &server{
+		// the metrics count courses on every scrape, which is not worth a trace
+		store:    newTracedStore(backing, tracer, *storeKind),
+		ids:      ids,
+		auth:     auth,
+		logger:   logger,
+		metrics:  metrics,
+		tracer:   tracer,
+		search:   indexed.index,
+		webhooks: webhooks,
+		idempotency: &idempotency{
+			store: newMemoryIdempotencyStore(),
+			ttl:   *idempotencyTTL,
+		},
+		cache:           cache,
+		versionPolicies: map[apiVersion]versionPolicy{apiV1: v1Policy},
+		health: &health{checks: map[string]func(context.Context) error{
+			"store": func(ctx context.Context) error {
+				_, err := store.Get(ctx, "")
+				if errors.Is(err, ErrCourseNotFound) {
+					return nil
+				}
+				return err
+			},
+		}},
+	}
 
-	// routing 
-	r.HandleFunc("/", serveHome).Methods("GET");
-	r.HandleFunc("/courses", getAllCourse).Methods("GET");
-	r.HandleFunc("/course/{id}", getOneCourses).Methods("GET")
-	r.HandleFunc("/course", createOneCourse).Methods("POST")
-	r.HandleFunc("/course/{id}", updateOneCourse).Methods("PUT")
-	r.HandleFunc("/course/{id}", deleteOneCourse).Methods("DELETE")
+	router, err := newRouter(s)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	// listen in port
+	srv := &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.Port),
+		Handler:           s.withMiddleware(router),
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+	}
 
-	log.Fatal(http.ListenAndServe(":4000",r))
+	var grpcSrv *grpc.Server
+	if cfg.GRPCPort != 0 {
+		if grpcSrv, err = newGRPCServer(s, cfg); err != nil {
+			log.Fatal(err)
+		}
+	}
 
+	logger.Info("listening", "addr", srv.Addr, "grpc_port", cfg.GRPCPort, "tls", cfg.TLSCertFile != "")
+	if err := serve(srv, grpcSrv, cfg, s.health); err != nil {
+		logger.Error("server stopped", "error", err)
+		exitCode = 1
+		return
+	}
+	logger.Info("server stopped")
 }
 
-func serveHome(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte("<h1>Welcome to API by LearnCodeOnline</h1>"))
+// envOr returns the environment variable, or def when it is not set.
+func envOr(name, def string) string {
+	if v, ok := os.LookupEnv(name); ok {
+		return v
+	}
+	return def
+}
+
+// openStore picks the backend selected at startup. The returned func
+// releases whatever the backend holds open.
+func openStore(kind, dir string, snapshotEvery time.Duration) (CourseStore, func() error, error) {
+	switch kind {
+	case "memory":
+		return newMemoryStore(), func() error { return nil }, nil
+	case "file":
+		store, err := newFileStore(dir, snapshotEvery)
+		if err != nil {
+			return nil, nil, err
+		}
+		return store, store.Close, nil
+	}
+	return nil, nil, fmt.Errorf("unknown store %q", kind)
+}
+
+// openCacheStore picks the response cache backend.
+func openCacheStore(kind, redisAddr string) (CacheStore, error) {
+	switch kind {
+	case "memory":
+		return newMemoryCacheStore(), nil
+	case "redis":
+		store := newRedisCacheStore(redisAddr, 16)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := store.Ping(ctx); err != nil {
+			return nil, fmt.Errorf("redis at %s: %w", redisAddr, err)
+		}
+		return store, nil
+	}
+	return nil, fmt.Errorf("unknown cache %q", kind)
 }
 
+// seedCourses adds the demo author and courses, but only into an empty
+// store so a file-backed store does not get them again on every restart.
+func seedCourses(store CourseStore) error {
+	ctx := context.Background()
+
+	existing, err := store.List(ctx)
+	if err != nil || len(existing) > 0 {
+		return err
+	}
 
-func getAllCourse(w http.ResponseWriter, r *http.Request){
-	fmt.Println("Get all courses")
-	w.Header().Set("Content-Type","application/json")
-	json.NewEncoder(w).Encode(courses)
+	author := Author{AuthorId: "1", Fullname: "Hitesh Choudhary", Website: "lco.dev"}
+	if _, err := store.CreateAuthor(ctx, author); err != nil && !errors.Is(err, ErrAuthorExists) {
+		return err
+	}
+	seed := []Course{
+		{CourseId: "2", CourseName: "ReactJS", CoursePrice: 299, AuthorId: author.AuthorId},
+		{CourseId: "4", CourseName: "MERN Stack", CoursePrice: 199, AuthorId: author.AuthorId},
+	}
+	for _, course := range seed {
+		if _, err := store.Create(ctx, course); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func getOneCourses(w http.ResponseWriter, r *http.Request){
-	fmt.Println("Get  single courses");
-	w.Header().Set("Content-Type", "application/json");
+func newRouter(s *server) (*mux.Router, error) {
+	r := mux.NewRouter()
+	r.NotFoundHandler = http.HandlerFunc(notFoundHandler)
+	r.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowedHandler)
+	r.Use(authenticate(s.auth))
 
-	// get the params 
-	params := mux.Vars(r);
+	gql, err := newGraphQLHandler(s)
+	if err != nil {
+		return nil, err
+	}
 
+	// routing, every route needs a name with docs in apiOperations
+	r.HandleFunc("/", serveHome).Methods("GET").Name("home")
+	r.HandleFunc("/healthz", s.health.liveness).Methods("GET").Name("liveness")
+	r.HandleFunc("/readyz", s.health.readiness).Methods("GET").Name("readiness")
+	r.Handle("/metrics", s.metrics.registry).Methods("GET").Name("metrics")
+	spec := &openAPIHandler{}
+	r.Handle("/openapi.json", spec).Methods("GET").Name("openapi")
+	r.HandleFunc("/docs", serveDocs).Methods("GET").Name("docs")
+	r.HandleFunc("/docs/{file}", serveDocsAsset).Methods("GET").Name("docsAsset")
+	r.HandleFunc("/login", s.auth.login).Methods("POST").Name("login")
+	r.HandleFunc("/token/refresh", s.auth.refresh).Methods("POST").Name("refreshToken")
+	r.HandleFunc("/logout", requireRole(s.auth.logout)).Methods("POST").Name("logout")
+	// the course routes once without a version prefix and once per version
+	for _, v := range routeVersions {
+		v.policies = s.versionPolicies
+		r.HandleFunc(v.prefix+"/courses/search", negotiate(v.use(s.searchCourses))).Methods("GET").Name("searchCourses" + v.suffix)
+		r.HandleFunc(v.prefix+"/courses", negotiate(v.use(s.cache.wrap(courseListDeps, s.getAllCourse)))).Methods("GET").Name("listCourses" + v.suffix)
+		r.HandleFunc(v.prefix+"/course/{id}", negotiate(v.use(s.cache.wrap(courseDeps, s.getOneCourses)))).Methods("GET").Name("getCourse" + v.suffix)
+		r.HandleFunc(v.prefix+"/course", negotiate(requireRole(v.use(s.idempotency.wrap(s.createOneCourse)), writerRoles...))).Methods("POST").Name("createCourse" + v.suffix)
+		r.HandleFunc(v.prefix+"/course/{id}", negotiate(requireRole(v.use(s.updateOneCourse), writerRoles...))).Methods("PUT").Name("replaceCourse" + v.suffix)
+		r.HandleFunc(v.prefix+"/course/{id}", negotiate(requireRole(v.use(s.patchOneCourse), writerRoles...))).Methods("PATCH").Name("patchCourse" + v.suffix)
+		r.HandleFunc(v.prefix+"/course/{id}", negotiate(requireRole(v.use(s.deleteOneCourse), writerRoles...))).Methods("DELETE").Name("deleteCourse" + v.suffix)
+		r.HandleFunc(v.prefix+"/authors/{id}/courses", negotiate(v.use(s.listAuthorCourses))).Methods("GET").Name("listAuthorCourses" + v.suffix)
+	}
+	r.HandleFunc("/courses:export", s.exportCourses).Methods("GET").Name("exportCourses")
+	r.HandleFunc("/courses:import", requireRole(s.importCourses, writerRoles...)).Methods("POST").Name("importCourses")
+	r.HandleFunc("/authors", negotiate(s.listAuthors)).Methods("GET").Name("listAuthors")
+	r.HandleFunc("/authors", negotiate(requireRole(s.createAuthor, writerRoles...))).Methods("POST").Name("createAuthor")
+	r.HandleFunc("/authors/{id}", negotiate(s.getAuthor)).Methods("GET").Name("getAuthor")
+	r.HandleFunc("/authors/{id}", negotiate(requireRole(s.updateAuthor, writerRoles...))).Methods("PUT").Name("replaceAuthor")
+	r.HandleFunc("/authors/{id}", negotiate(requireRole(s.deleteAuthor, writerRoles...))).Methods("DELETE").Name("deleteAuthor")
+	r.Handle("/graphql", gql).Methods("GET").Name("graphqlQuery")
+	r.Handle("/graphql", gql).Methods("POST").Name("graphql")
+	r.HandleFunc("/admin/webhooks", requireRole(s.listWebhooks, adminRoles...)).Methods("GET").Name("listWebhooks")
+	r.HandleFunc("/admin/webhooks", requireRole(s.createWebhook, adminRoles...)).Methods("POST").Name("createWebhook")
+	r.HandleFunc("/admin/webhooks/{id}", requireRole(s.getWebhook, adminRoles...)).Methods("GET").Name("getWebhook")
+	r.HandleFunc("/admin/webhooks/{id}", requireRole(s.deleteWebhook, adminRoles...)).Methods("DELETE").Name("deleteWebhook")
+	r.HandleFunc("/admin/webhooks/{id}/replay", requireRole(s.replayWebhook, adminRoles...)).Methods("POST").Name("replayWebhook")
+	r.HandleFunc("/admin/events", requireRole(s.listEvents, adminRoles...)).Methods("GET").Name("listEvents")
+	r.HandleFunc("/admin/dead-letters", requireRole(s.listDeadLetters, adminRoles...)).Methods("GET").Name("listDeadLetters")
+	r.HandleFunc("/admin/dead-letters/{id}/retry", requireRole(s.retryDeadLetter, adminRoles...)).Methods("POST").Name("retryDeadLetter")
+	r.HandleFunc("/admin/dead-letters/{id}", requireRole(s.deleteDeadLetter, adminRoles...)).Methods("DELETE").Name("deleteDeadLetter")
+
+	if spec.body, err = buildOpenAPI(r, withVersionedOperations(apiOperations, s.versionPolicies)); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
 
-	// for loop through the course and find the course 
+func serveHome(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("<h1>Welcome to API by LearnCodeOnline</h1>"))
+}
 
-	for _, course := range courses{
-		if course.CourseId == params["id"]{
-			w.WriteHeader(http.StatusOK)
-			json.NewEncoder(w).Encode(course)
+func (s *server) getAllCourse(w http.ResponseWriter, r *http.Request) {
+	query, err := parseCourseQuery(r.URL.Query())
+	if err != nil {
+		writeProblem(w, r, newProblem(http.StatusBadRequest, err.Error()))
+		return
+	}
+	s.writeCoursePage(w, r, query)
+}
+
+func (s *server) getOneCourses(w http.ResponseWriter, r *http.Request) {
+	// get the params
+	params := mux.Vars(r)
+
+	expand, err := parseExpand(r.URL.Query())
+	if err != nil {
+		writeProblem(w, r, newProblem(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	course, err := s.store.Get(r.Context(), params["id"])
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	etag := etagFor(course)
+	if expand && course.AuthorId != "" {
+		author, err := s.store.GetAuthor(r.Context(), course.AuthorId)
+		if err != nil {
+			writeProblem(w, r, err)
 			return
 		}
+		course.Author = &author
+		etag = expandedETag(course, author)
+	}
+	w.Header().Set("ETag", etag)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
 	}
-	// if no course found with id
 
-	w.WriteHeader(http.StatusBadRequest)
-	json.NewEncoder(w).Encode(map[string]string{"message":"Course not found"})
+	writeEntity(w, r, http.StatusOK, courseFor(r.Context(), course))
 }
 
+func (s *server) createOneCourse(w http.ResponseWriter, r *http.Request) {
+	course, err := decodeCourse(w, r)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
 
-func createOneCourse(w http.ResponseWriter, r *http.Request){
-	fmt.Printf("Create  course ");
+	// ids belong to the server, a client can not pick one
+	if course.CourseId != "" {
+		writeProblem(w, r, ValidationErrors{{Field: "courseId", Message: "is assigned by the server"}})
+		return
+	}
+	if course.Author != nil {
+		writeProblem(w, r, errEmbeddedAuthor)
+		return
+	}
 
-	w.Header().Set("Content-Type","application/json");
+	if err := validateCourse(r.Context(), course); err != nil {
+		writeProblem(w, r, err)
+		return
+	}
 
-	if r.Body == nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode("Empty data")
-		return 
+	course.CourseId = s.ids.NewId()
+	course, err = s.store.Create(r.Context(), course)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
 	}
 
-	var course Course;
-	_ = json.NewDecoder(r.Body).Decode(&course);
+	w.Header().Set("ETag", etagFor(course))
+	// under the version prefix the course was created with
+	w.Header().Set("Location", r.URL.Path+"/"+course.CourseId)
+	writeEntity(w, r, http.StatusCreated, courseFor(r.Context(), course))
+}
+
+func (s *server) updateOneCourse(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+
+	course, err := decodeCourse(w, r)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	if course.CourseId != "" && course.CourseId != params["id"] {
+		writeProblem(w, r, ValidationErrors{{Field: "courseId", Message: "must match the id in the URL"}})
+		return
+	}
+	course.CourseId = params["id"]
+	if course.Author != nil {
+		writeProblem(w, r, errEmbeddedAuthor)
+		return
+	}
 
-	if course.isEmpty() {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode("No data inside json")
+	if err := validateCourse(r.Context(), course); err != nil {
+		writeProblem(w, r, err)
 		return
 	}
 
-	// rand.Seed(time.Now().UnixNano())
-	// course.CourseId = strconv.Itoa(rand.Intn(100))
-	course.CourseId = "asd";
-	courses = append(courses, course)
-	w.WriteHeader(http.StatusOK);
-	json.NewEncoder(w).Encode(course);
-	return
-}
+	version, err := s.ifMatchVersion(r, course.CourseId)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	course.Version = version
 
+	course, err = s.store.Update(r.Context(), course)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
 
-func updateOneCourse(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("Update one course")
-	w.Header().Set("Content-Type", "applicatioan/json")
+	w.Header().Set("ETag", etagFor(course))
+	writeEntity(w, r, http.StatusOK, courseFor(r.Context(), course))
+}
 
+// patchOneCourse changes part of a course. The body is either a JSON Merge
+// Patch or a JSON Patch, picked by Content-Type. The patch is applied to
+// the course as JSON and the result goes through the same decoding and
+// validation as a PUT body.
+func (s *server) patchOneCourse(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 
-	for index, course := range courses {
-		if course.CourseId == params["id"] {
-			courses = append(courses[:index], courses[index+1:]...)
-			var course Course
-			_ = json.NewDecoder(r.Body).Decode(&course)
-			course.CourseId = params["id"]
-			courses = append(courses, course)
-			json.NewEncoder(w).Encode(course)
+	current, err := s.store.Get(r.Context(), params["id"])
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	if im := r.Header.Get("If-Match"); im != "" && !etagMatches(im, etagFor(current), false) {
+		writeProblem(w, r, ErrPreconditionFailed)
+		return
+	}
+	// the patch is written against the course as this version shows it
+	doc, err := deepCopy(courseFor(r.Context(), current))
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case mergePatchType:
+		var patch any
+		if err := decodeBody(w, r, mergePatchType, &patch); err != nil {
+			writeProblem(w, r, err)
+			return
+		}
+		doc = mergePatch(doc, patch)
+	case jsonPatchType:
+		var ops []patchOp
+		if err := decodeBody(w, r, jsonPatchType, &ops); err != nil {
+			writeProblem(w, r, err)
 			return
 		}
+		if doc, err = jsonPatch(doc, ops); err != nil {
+			writeProblem(w, r, err)
+			return
+		}
+	default:
+		w.Header().Set("Accept-Patch", mergePatchType+", "+jsonPatchType)
+		writeProblem(w, r, fmt.Errorf("%w: send the patch as %s or %s", ErrUnsupportedMediaType, mergePatchType, jsonPatchType))
+		return
 	}
-}
 
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	dto := newCourseDTO(r.Context())
+	if err := decodeStrict(bytes.NewReader(raw), dto); err != nil {
+		if errors.Is(err, ErrMalformedJSON) {
+			// the patch was fine as JSON, what it produced is not a course
+			err = fmt.Errorf("%w: result is not a course: %v", ErrInvalidPatch, err)
+		}
+		writeProblem(w, r, err)
+		return
+	}
+	course, err := dto.toCourse()
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	if course.CourseId != current.CourseId {
+		writeProblem(w, r, ValidationErrors{{Field: "courseId", Message: "can not be changed"}})
+		return
+	}
+	if course.Author != nil {
+		writeProblem(w, r, errEmbeddedAuthor)
+		return
+	}
+	if err := validateCourse(r.Context(), course); err != nil {
+		writeProblem(w, r, err)
+		return
+	}
 
+	// the patch was applied to current, so it may only replace current
+	course.Version = current.Version
+	course, err = s.store.Update(r.Context(), course)
+	if errors.Is(err, ErrVersionMismatch) && r.Header.Get("If-Match") == "" {
+		writeProblem(w, r, newProblem(http.StatusConflict, "the course changed while the patch was applied, retry"))
+		return
+	}
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
 
-func deleteOneCourse(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("Delete one course")
-	w.Header().Set("Content-Type", "applicatioan/json")
+	w.Header().Set("ETag", etagFor(course))
+	writeEntity(w, r, http.StatusOK, courseFor(r.Context(), course))
+}
 
+func (s *server) deleteOneCourse(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 
-	//loop, id, remove (index, index+1)
+	version, err := s.ifMatchVersion(r, params["id"])
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
 
-	for index, course := range courses {
-		if course.CourseId == params["id"] {
-			courses = append(courses[:index], courses[index+1:]...)
-			break
-		}
+	if err := s.store.Delete(r.Context(), params["id"], version); err != nil {
+		writeProblem(w, r, err)
+		return
 	}
+	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// clockSkew is how far exp and nbf may be off before we reject a token.
+const clockSkew = 30 * time.Second
+
+var ErrUnauthenticated = errors.New("authentication required")
+var ErrInvalidToken = errors.New("invalid token")
+var ErrForbidden = errors.New("your role is not allowed to do this")
+
+// Claims are the JWT claims the API looks at. Role follows the single
+// "role" claim of Authentication/Role_Base_Auth; Roles allows more than one.
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	Id        string   `json:"jti,omitempty"`
+	SessionId string   `json:"sid,omitempty"` // refresh token family of the login
+	Role      string   `json:"role,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+}
+
+// HasRole reports whether the token was issued for any of roles.
+func (c *Claims) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		if c.Role == role || slices.Contains(c.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// audience is the "aud" claim, which may be a string or a list of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// jwk is one key of a JSON Web Key Set. Only the fields for symmetric
+// ("oct") and RSA public keys are read.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type verifyKey struct {
+	alg    string
+	secret []byte
+	public *rsa.PublicKey
+}
+
+type signKey struct {
+	kid     string
+	alg     string
+	secret  []byte
+	private *rsa.PrivateKey
+}
+
+// KeySet verifies tokens against keys that live with the server, there is
+// no identity provider to call. Keys are picked by the "kid" header; a
+// token without kid is tried against every key of its algorithm.
+// One of the keys may also be used to sign the tokens /login hands out.
+type KeySet struct {
+	keys     map[string]verifyKey
+	signer   *signKey
+	issuer   string
+	audience string
+	now      func() time.Time
+}
+
+func newKeySet(issuer, aud string) *KeySet {
+	return &KeySet{keys: make(map[string]verifyKey), issuer: issuer, audience: aud, now: time.Now}
+}
+
+// AddHMAC adds an HS256 secret.
+func (ks *KeySet) AddHMAC(kid string, secret []byte) {
+	ks.keys[kid] = verifyKey{alg: "HS256", secret: secret}
+}
+
+// AddRSA adds an RS256 public key.
+func (ks *KeySet) AddRSA(kid string, key *rsa.PublicKey) {
+	ks.keys[kid] = verifyKey{alg: "RS256", public: key}
+}
+
+// AddRSAPrivate adds the public half of key for verifying and signs new
+// tokens with the private half.
+func (ks *KeySet) AddRSAPrivate(kid string, key *rsa.PrivateKey) {
+	ks.AddRSA(kid, &key.PublicKey)
+	ks.signer = &signKey{kid: kid, alg: "RS256", private: key}
+}
+
+// UseForSigning signs new tokens with the HS256 key kid.
+func (ks *KeySet) UseForSigning(kid string) error {
+	key, ok := ks.keys[kid]
+	if !ok || key.alg != "HS256" {
+		return fmt.Errorf("there is no HS256 key %q to sign with", kid)
+	}
+	ks.signer = &signKey{kid: kid, alg: "HS256", secret: key.secret}
+	return nil
+}
+
+func (ks *KeySet) Empty() bool {
+	return len(ks.keys) == 0
+}
+
+func (ks *KeySet) CanSign() bool {
+	return ks.signer != nil
+}
+
+// Sign returns claims as a compact JWT. Issuer and audience are filled in
+// from the key set so the token passes our own Verify.
+func (ks *KeySet) Sign(claims Claims) (string, error) {
+	if ks.signer == nil {
+		return "", errors.New("no signing key configured")
+	}
+	if ks.issuer != "" {
+		claims.Issuer = ks.issuer
+	}
+	if ks.audience != "" {
+		claims.Audience = audience{ks.audience}
+	}
+
+	header := map[string]string{"alg": ks.signer.alg, "typ": "JWT"}
+	if ks.signer.kid != "" {
+		header["kid"] = ks.signer.kid
+	}
+	rawHeader, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	rawClaims, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(rawHeader) + "." + base64.RawURLEncoding.EncodeToString(rawClaims)
+
+	var signature []byte
+	switch ks.signer.alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, ks.signer.secret)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case "RS256":
+		digest := sha256.Sum256([]byte(signed))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, ks.signer.private, crypto.SHA256, digest[:])
+		if err != nil {
+			return "", err
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// loadRSAPrivateKey reads a PEM encoded PKCS#1 or PKCS#8 RSA private key.
+func loadRSAPrivateKey(path string) (*rsa.PrivateKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an RSA key", path)
+	}
+	return key, nil
+}
+
+// LoadJWKS adds every key of a JWKS file, e.g.
+//
+//	{"keys": [{"kty": "oct", "kid": "dev", "k": "c2VjcmV0"},
+//	          {"kty": "RSA", "kid": "prod", "n": "...", "e": "AQAB"}]}
+func (ks *KeySet) LoadJWKS(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, key := range set.Keys {
+		switch key.Kty {
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(key.K, "="))
+			if err != nil {
+				return fmt.Errorf("%s: key %q: %w", path, key.Kid, err)
+			}
+			ks.AddHMAC(key.Kid, secret)
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(strings.TrimRight(key.N, "="))
+			e, err2 := base64.RawURLEncoding.DecodeString(strings.TrimRight(key.E, "="))
+			if err := errors.Join(err1, err2); err != nil {
+				return fmt.Errorf("%s: key %q: %w", path, key.Kid, err)
+			}
+			ks.AddRSA(key.Kid, &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			})
+		default:
+			return fmt.Errorf("%s: key %q: unsupported kty %q", path, key.Kid, key.Kty)
+		}
+	}
+	return nil
+}
+
+// Verify checks the signature and the time, issuer and audience claims of
+// a compact JWT and returns its claims.
+func (ks *KeySet) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a JWT", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg  string   `json:"alg"`
+		Kid  string   `json:"kid"`
+		Crit []string `json:"crit"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	// only the two algorithms we have keys for, never "none"
+	if header.Alg != "HS256" && header.Alg != "RS256" {
+		return nil, fmt.Errorf("%w: algorithm %q is not accepted", ErrInvalidToken, header.Alg)
+	}
+	if len(header.Crit) > 0 {
+		return nil, fmt.Errorf("%w: unsupported critical headers", ErrInvalidToken)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad signature encoding", ErrInvalidToken)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+
+	if !ks.verifySignature(header.Kid, header.Alg, signed, signature) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err := ks.checkClaims(&claims); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+// verifySignature only tries keys made for alg, so an RSA public key can
+// never be used as an HMAC secret.
+func (ks *KeySet) verifySignature(kid, alg string, signed, signature []byte) bool {
+	if kid != "" {
+		key, ok := ks.keys[kid]
+		return ok && key.alg == alg && key.verify(signed, signature)
+	}
+	for _, key := range ks.keys {
+		if key.alg == alg && key.verify(signed, signature) {
+			return true
+		}
+	}
+	return false
+}
+
+func (k verifyKey) verify(signed, signature []byte) bool {
+	switch k.alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case "RS256":
+		digest := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(k.public, crypto.SHA256, digest[:], signature) == nil
+	}
+	return false
+}
+
+func (ks *KeySet) checkClaims(c *Claims) error {
+	now := ks.now()
+	if c.ExpiresAt == 0 {
+		return fmt.Errorf("%w: exp is required", ErrInvalidToken)
+	}
+	if now.After(time.Unix(c.ExpiresAt, 0).Add(clockSkew)) {
+		return fmt.Errorf("%w: token expired", ErrInvalidToken)
+	}
+	if c.NotBefore != 0 && now.Add(clockSkew).Before(time.Unix(c.NotBefore, 0)) {
+		return fmt.Errorf("%w: token not valid yet", ErrInvalidToken)
+	}
+	if ks.issuer != "" && c.Issuer != ks.issuer {
+		return fmt.Errorf("%w: wrong issuer", ErrInvalidToken)
+	}
+	if ks.audience != "" && !slices.Contains(c.Audience, ks.audience) {
+		return fmt.Errorf("%w: wrong audience", ErrInvalidToken)
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: bad encoding", ErrInvalidToken)
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return nil
+}
+
+type claimsKey struct{}
+
+// claimsFromContext returns the claims of an authenticated request.
+func claimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*Claims)
+	return claims, ok
+}
+
+// authenticate is a mux middleware. A request with a Bearer token must
+// carry a valid, unrevoked one; a request with a session cookie must point
+// at a live session and send the CSRF token on writes. Either way the
+// claims are put in the request context. A request without credentials
+// goes on anonymously and requireRole decides about it.
+//
+// The cookie is ignored on the login and refresh routes: they bring their
+// own credentials, and a stale session must not stop a browser from
+// logging in again.
+func authenticate(a *authService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var claims *Claims
+			var err error
+
+			if header := r.Header.Get("Authorization"); header != "" {
+				claims, err = a.claimsFromBearer(r.Context(), header)
+			} else if cookie, cerr := r.Cookie(sessionCookie); cerr == nil && !skipsSession(r) {
+				claims, err = a.claimsFromSession(r, cookie.Value)
+			}
+
+			if errors.Is(err, ErrCSRF) {
+				writeProblem(w, r, err)
+				return
+			}
+			if err != nil {
+				unauthorized(w, r, err)
+				return
+			}
+			if claims == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), claimsKey{}, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// skipsSession reports whether r goes to a route that ignores the session
+// cookie.
+func skipsSession(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	switch route.GetName() {
+	case "login", "refreshToken":
+		return true
+	}
+	return false
+}
+
+// requireRole lets a request through only if its token has one of roles.
+// Without roles any authenticated caller is let through, like auth() in
+// Authentication/Role_Base_Auth.
+func requireRole(next http.HandlerFunc, roles ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := claimsFromContext(r.Context())
+		if !ok {
+			unauthorized(w, r, ErrUnauthenticated)
+			return
+		}
+		if len(roles) > 0 && !claims.HasRole(roles...) {
+			writeProblem(w, r, ErrForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func unauthorized(w http.ResponseWriter, r *http.Request, err error) {
+	challenge := `Bearer realm="courses"`
+	if errors.Is(err, ErrInvalidToken) {
+		challenge += `, error="invalid_token"`
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	writeProblem(w, r, err)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrRefreshTokenNotFound = errors.New("refresh token not found")
+var ErrRefreshTokenReused = errors.New("refresh token was already used")
+var ErrRefreshTokenRevoked = errors.New("refresh token was revoked")
+var ErrSessionNotFound = errors.New("session not found")
+
+// RefreshToken is what the server remembers about a refresh token. Only the
+// SHA-256 of the token is kept, never the token itself. Every token of one
+// login shares a Family; rotating hands out a new token of the same family
+// and marks the old one Used.
+type RefreshToken struct {
+	Hash            string
+	Family          string
+	Subject         string
+	Roles           []string
+	ExpiresAt       time.Time
+	Used            bool
+	AccessId        string // jti of the access token issued together with it
+	AccessExpiresAt time.Time
+}
+
+// Session is a server side login for the cookie mode. The browser only
+// holds the id; CSRFToken has to come back in a header on writes.
+type Session struct {
+	Id        string
+	Subject   string
+	Roles     []string
+	CSRFToken string
+	ExpiresAt time.Time
+}
+
+// AuthStore keeps refresh tokens, the access token revocation list and
+// sessions. Like CourseStore it is an interface so the in-memory version
+// can be replaced by a shared store when the API runs more than once.
+type AuthStore interface {
+	SaveRefreshToken(ctx context.Context, token RefreshToken) error
+	// UseRefreshToken marks the token used and returns it. A token that was
+	// used before comes back together with ErrRefreshTokenReused.
+	UseRefreshToken(ctx context.Context, hash string) (RefreshToken, error)
+	// RevokeFamily makes every token of the family unusable and returns them.
+	RevokeFamily(ctx context.Context, family string) ([]RefreshToken, error)
+
+	RevokeAccessToken(ctx context.Context, id string, until time.Time) error
+	IsAccessTokenRevoked(ctx context.Context, id string) (bool, error)
+
+	CreateSession(ctx context.Context, session Session) error
+	GetSession(ctx context.Context, id string) (Session, error)
+	DeleteSession(ctx context.Context, id string) error
+}
+
+// authPurgeInterval is how often memoryAuthStore drops expired entries.
+const authPurgeInterval = time.Minute
+
+// memoryAuthStore is the AuthStore used by a single process.
+// Expired entries are dropped on a write, at most once per
+// authPurgeInterval, so a login does not walk every map.
+type memoryAuthStore struct {
+	mu              sync.Mutex
+	now             func() time.Time
+	lastPurge       time.Time
+	refresh         map[string]RefreshToken
+	revokedFamilies map[string]time.Time
+	revokedAccess   map[string]time.Time
+	sessions        map[string]Session
+}
+
+func newMemoryAuthStore() *memoryAuthStore {
+	return &memoryAuthStore{
+		now:             time.Now,
+		refresh:         make(map[string]RefreshToken),
+		revokedFamilies: make(map[string]time.Time),
+		revokedAccess:   make(map[string]time.Time),
+		sessions:        make(map[string]Session),
+	}
+}
+
+func (s *memoryAuthStore) SaveRefreshToken(ctx context.Context, token RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.purgeLocked()
+	if _, revoked := s.revokedFamilies[token.Family]; revoked {
+		return ErrRefreshTokenRevoked
+	}
+	s.refresh[token.Hash] = token
+	return nil
+}
+
+func (s *memoryAuthStore) UseRefreshToken(ctx context.Context, hash string) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.refresh[hash]
+	if !ok {
+		return RefreshToken{}, ErrRefreshTokenNotFound
+	}
+	if _, revoked := s.revokedFamilies[token.Family]; revoked {
+		return token, ErrRefreshTokenRevoked
+	}
+	if token.Used {
+		return token, ErrRefreshTokenReused
+	}
+	token.Used = true
+	s.refresh[hash] = token
+	return token, nil
+}
+
+func (s *memoryAuthStore) RevokeFamily(ctx context.Context, family string) ([]RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var tokens []RefreshToken
+	until := s.now()
+	for _, token := range s.refresh {
+		if token.Family == family {
+			tokens = append(tokens, token)
+			if token.ExpiresAt.After(until) {
+				until = token.ExpiresAt
+			}
+		}
+	}
+	// remember the family until its last token would have expired anyway
+	s.revokedFamilies[family] = until
+	return tokens, nil
+}
+
+func (s *memoryAuthStore) RevokeAccessToken(ctx context.Context, id string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.purgeLocked()
+	s.revokedAccess[id] = until
+	return nil
+}
+
+func (s *memoryAuthStore) IsAccessTokenRevoked(ctx context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, revoked := s.revokedAccess[id]
+	return revoked, nil
+}
+
+func (s *memoryAuthStore) CreateSession(ctx context.Context, session Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.purgeLocked()
+	s.sessions[session.Id] = session
+	return nil
+}
+
+func (s *memoryAuthStore) GetSession(ctx context.Context, id string) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || !s.now().Before(session.ExpiresAt) {
+		return Session{}, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+func (s *memoryAuthStore) DeleteSession(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, id)
+	return nil
+}
+
+// purgeLocked must be called with s.mu held. Lookups check expiry
+// themselves, so entries may safely outlive their time until the next purge.
+func (s *memoryAuthStore) purgeLocked() {
+	now := s.now()
+	if now.Sub(s.lastPurge) < authPurgeInterval {
+		return
+	}
+	s.lastPurge = now
+	for hash, token := range s.refresh {
+		if now.After(token.ExpiresAt) {
+			delete(s.refresh, hash)
+		}
+	}
+	for family, until := range s.revokedFamilies {
+		if now.After(until) {
+			delete(s.revokedFamilies, family)
+		}
+	}
+	for id, until := range s.revokedAccess {
+		if now.After(until) {
+			delete(s.revokedAccess, id)
+		}
+	}
+	for id, session := range s.sessions {
+		if now.After(session.ExpiresAt) {
+			delete(s.sessions, id)
+		}
+	}
+}
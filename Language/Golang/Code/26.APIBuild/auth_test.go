@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// makeToken builds a compact JWT the way an attacker could, with any
+// header and any signature.
+func makeToken(t *testing.T, header map[string]any, claims any, sign func(signed []byte) []byte) string {
+	t.Helper()
+	rawHeader, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawClaims, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(rawHeader) + "." + base64.RawURLEncoding.EncodeToString(rawClaims)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+
+func hs256(secret []byte) func([]byte) []byte {
+	return func(signed []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		return mac.Sum(nil)
+	}
+}
+
+func rs256(t *testing.T, key *rsa.PrivateKey) func([]byte) []byte {
+	return func(signed []byte) []byte {
+		digest := sha256.Sum256(signed)
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	secret := []byte("hmac secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)})
+
+	ks := newKeySet("courses", "api")
+	ks.AddHMAC("hs", secret)
+	ks.AddRSA("rs", &rsaKey.PublicKey)
+	ks.now = func() time.Time { return now }
+
+	claims := func(edit func(c map[string]any)) map[string]any {
+		c := map[string]any{"sub": "u1", "iss": "courses", "aud": "api", "exp": now.Add(time.Hour).Unix(), "role": "admin"}
+		if edit != nil {
+			edit(c)
+		}
+		return c
+	}
+	hsHeader := map[string]any{"alg": "HS256", "kid": "hs"}
+	rsHeader := map[string]any{"alg": "RS256", "kid": "rs"}
+	none := func([]byte) []byte { return nil }
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"HS256", makeToken(t, hsHeader, claims(nil), hs256(secret)), true},
+		{"HS256 without kid", makeToken(t, map[string]any{"alg": "HS256"}, claims(nil), hs256(secret)), true},
+		{"RS256", makeToken(t, rsHeader, claims(nil), rs256(t, rsaKey)), true},
+		{"audience list", makeToken(t, hsHeader, claims(func(c map[string]any) { c["aud"] = []string{"web", "api"} }), hs256(secret)), true},
+
+		{"alg none", makeToken(t, map[string]any{"alg": "none"}, claims(nil), none), false},
+		{"alg None with kid", makeToken(t, map[string]any{"alg": "None", "kid": "hs"}, claims(nil), none), false},
+		{"alg HS512", makeToken(t, map[string]any{"alg": "HS512", "kid": "hs"}, claims(nil), hs256(secret)), false},
+		{"RSA public key as HMAC secret", makeToken(t, map[string]any{"alg": "HS256", "kid": "rs"}, claims(nil), hs256(publicPEM)), false},
+		{"RSA public key as HMAC secret, no kid", makeToken(t, map[string]any{"alg": "HS256"}, claims(nil), hs256(publicPEM)), false},
+		{"RS256 with the HMAC kid", makeToken(t, map[string]any{"alg": "RS256", "kid": "hs"}, claims(nil), rs256(t, rsaKey)), false},
+		{"unknown kid", makeToken(t, map[string]any{"alg": "HS256", "kid": "old"}, claims(nil), hs256(secret)), false},
+		{"wrong secret", makeToken(t, hsHeader, claims(nil), hs256([]byte("guess"))), false},
+		{"crit header", makeToken(t, map[string]any{"alg": "HS256", "kid": "hs", "crit": []string{"exp"}}, claims(nil), hs256(secret)), false},
+
+		{"no exp", makeToken(t, hsHeader, claims(func(c map[string]any) { delete(c, "exp") }), hs256(secret)), false},
+		{"expired", makeToken(t, hsHeader, claims(func(c map[string]any) { c["exp"] = now.Add(-time.Minute).Unix() }), hs256(secret)), false},
+		{"expired within the skew", makeToken(t, hsHeader, claims(func(c map[string]any) { c["exp"] = now.Add(-clockSkew / 2).Unix() }), hs256(secret)), true},
+		{"not valid yet", makeToken(t, hsHeader, claims(func(c map[string]any) { c["nbf"] = now.Add(time.Minute).Unix() }), hs256(secret)), false},
+		{"nbf within the skew", makeToken(t, hsHeader, claims(func(c map[string]any) { c["nbf"] = now.Add(clockSkew / 2).Unix() }), hs256(secret)), true},
+		{"wrong issuer", makeToken(t, hsHeader, claims(func(c map[string]any) { c["iss"] = "evil" }), hs256(secret)), false},
+		{"wrong audience", makeToken(t, hsHeader, claims(func(c map[string]any) { c["aud"] = "other" }), hs256(secret)), false},
+
+		{"two parts", "a.b", false},
+		{"bad base64", "!!.!!.!!", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ks.Verify(tt.token)
+			if tt.ok {
+				if err != nil || got.Subject != "u1" {
+					t.Errorf("Verify = %+v, %v, want the claims", got, err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Verify = %+v, %v, want ErrInvalidToken", got, err)
+			}
+		})
+	}
+
+	// a valid signature over other claims
+	token := makeToken(t, hsHeader, claims(nil), hs256(secret))
+	parts := strings.Split(token, ".")
+	forged := makeToken(t, hsHeader, claims(func(c map[string]any) { c["sub"] = "root" }), none)
+	forged = forged[:strings.LastIndex(forged, ".")+1] + parts[2]
+	if _, err := ks.Verify(forged); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("swapped claims: err = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := newKeySet("courses", "api")
+	hs.AddHMAC("hs", []byte("secret"))
+	if err := hs.UseForSigning("hs"); err != nil {
+		t.Fatal(err)
+	}
+	rs := newKeySet("courses", "api")
+	rs.AddRSAPrivate("rs", rsaKey)
+
+	for name, ks := range map[string]*KeySet{"HS256": hs, "RS256": rs} {
+		token, err := ks.Sign(Claims{Subject: "u1", ExpiresAt: time.Now().Add(time.Minute).Unix(), Roles: []string{"instructor"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims, err := ks.Verify(token)
+		if err != nil || !claims.HasRole(writerRoles...) || claims.Issuer != "courses" {
+			t.Errorf("%s: Verify = %+v, %v", name, claims, err)
+		}
+	}
+
+	if err := hs.UseForSigning("rs"); err == nil {
+		t.Error("UseForSigning an unknown key: err = nil")
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+	keys := newKeySet("", "")
+	keys.AddHMAC("test", []byte("test secret"))
+	keys.UseForSigning("test")
+	student, err := keys.Sign(Claims{Subject: "s", Role: "student", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+		challenge     string
+	}{
+		{"writer", "Bearer " + ts.token, http.StatusCreated, ""},
+		{"no token", "", http.StatusUnauthorized, `Bearer realm="courses"`},
+		{"bad token", "Bearer x.y.z", http.StatusUnauthorized, `Bearer realm="courses", error="invalid_token"`},
+		{"student", "Bearer " + student, http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", ts.URL+"/course", strings.NewReader(`{"courseName":"Go","coursePrice":5}`))
+		req.Header.Set("Content-Type", "application/json")
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.status || res.Header.Get("WWW-Authenticate") != tt.challenge {
+			t.Errorf("%s: %d %q, want %d %q", tt.name, res.StatusCode, res.Header.Get("WWW-Authenticate"), tt.status, tt.challenge)
+		}
+	}
+}
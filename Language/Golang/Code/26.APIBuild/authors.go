@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// authorList is the envelope GET /authors answers with.
+type authorList struct {
+	Authors []Author `json:"authors" xml:"authors>author"`
+	Total   int      `json:"total" xml:"total"`
+}
+
+func (s *server) listAuthors(w http.ResponseWriter, r *http.Request) {
+	authors, err := s.store.ListAuthors(r.Context())
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	writeEntity(w, r, http.StatusOK, authorList{Authors: authors, Total: len(authors)})
+}
+
+func (s *server) getAuthor(w http.ResponseWriter, r *http.Request) {
+	author, err := s.store.GetAuthor(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	etag := authorETag(author)
+	w.Header().Set("ETag", etag)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	writeEntity(w, r, http.StatusOK, author)
+}
+
+func (s *server) createAuthor(w http.ResponseWriter, r *http.Request) {
+	var author Author
+	if err := decodeEntity(w, r, &author); err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	if author.AuthorId != "" {
+		writeProblem(w, r, ValidationErrors{{Field: "authorId", Message: "is assigned by the server"}})
+		return
+	}
+	if err := validateStruct(author); err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	author.AuthorId = s.ids.NewId()
+	author, err := s.store.CreateAuthor(r.Context(), author)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", authorETag(author))
+	w.Header().Set("Location", "/authors/"+author.AuthorId)
+	writeEntity(w, r, http.StatusCreated, author)
+}
+
+// updateAuthor replaces an author. Every course of the author shows the
+// change, nothing is copied into them.
+func (s *server) updateAuthor(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var author Author
+	if err := decodeEntity(w, r, &author); err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	if author.AuthorId != "" && author.AuthorId != id {
+		writeProblem(w, r, ValidationErrors{{Field: "authorId", Message: "must match the id in the URL"}})
+		return
+	}
+	author.AuthorId = id
+
+	if err := validateStruct(author); err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	version, err := s.ifMatchAuthorVersion(r, id)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	author.Version = version
+
+	author, err = s.store.UpdateAuthor(r.Context(), author)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", authorETag(author))
+	writeEntity(w, r, http.StatusOK, author)
+}
+
+// deleteAuthor refuses to delete an author that still has courses with
+// 409, unless ?cascade=true asks to delete the courses as well.
+func (s *server) deleteAuthor(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	cascade := false
+	if v := r.URL.Query().Get("cascade"); v != "" {
+		var err error
+		if cascade, err = strconv.ParseBool(v); err != nil {
+			writeProblem(w, r, newProblem(http.StatusBadRequest, "cascade must be true or false"))
+			return
+		}
+	}
+
+	version, err := s.ifMatchAuthorVersion(r, id)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	deleted, err := s.store.DeleteAuthor(r.Context(), id, version, cascade)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	if len(deleted) > 0 {
+		s.logger.InfoContext(r.Context(), "cascade deleted courses",
+			"author_id", id, "course_ids", deleted, "request_id", requestIdFromContext(r.Context()))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listAuthorCourses is GET /courses limited to one author, with the same
+// paging, sorting and filters.
+func (s *server) listAuthorCourses(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	query, err := parseCourseQuery(r.URL.Query())
+	if err != nil {
+		writeProblem(w, r, newProblem(http.StatusBadRequest, err.Error()))
+		return
+	}
+	query.authorId = id
+
+	if _, err := s.store.GetAuthor(r.Context(), id); err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	s.writeCoursePage(w, r, query)
+}
+
+// writeCoursePage lists the courses, joins in their authors so the query
+// can filter and sort on author names, and writes one page.
+func (s *server) writeCoursePage(w http.ResponseWriter, r *http.Request, query courseQuery) {
+	courses, err := s.store.List(r.Context())
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	if err := s.joinAuthors(r.Context(), courses); err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	writeEntity(w, r, http.StatusOK, coursePageFor(r.Context(), query.apply(courses)))
+}
+
+// joinAuthors fills in Author for every course that has an AuthorId.
+func (s *server) joinAuthors(ctx context.Context, courses []Course) error {
+	authors, err := s.store.ListAuthors(ctx)
+	if err != nil {
+		return err
+	}
+	byId := make(map[string]Author, len(authors))
+	for _, author := range authors {
+		byId[author.AuthorId] = author
+	}
+	for i := range courses {
+		if author, ok := byId[courses[i].AuthorId]; ok {
+			courses[i].Author = &author
+		}
+	}
+	return nil
+}
+
+// migrateEmbeddedAuthors moves courses stored with a copy of their author
+// over to an AuthorId. Copies with the same name become one author; the
+// first website seen wins.
+func migrateEmbeddedAuthors(store CourseStore, ids IdGenerator) error {
+	ctx := context.Background()
+
+	courses, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+	authors, err := store.ListAuthors(ctx)
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]string, len(authors))
+	for _, author := range authors {
+		byName[strings.ToLower(author.Fullname)] = author.AuthorId
+	}
+
+	for _, course := range courses {
+		if course.Author == nil || course.AuthorId != "" {
+			continue
+		}
+		embedded := *course.Author
+		if embedded.Fullname == "" {
+			// nothing to make an author of
+			course.Author = nil
+		} else {
+			id, ok := byName[strings.ToLower(embedded.Fullname)]
+			if !ok {
+				created, err := store.CreateAuthor(ctx, Author{AuthorId: ids.NewId(), Fullname: embedded.Fullname, Website: embedded.Website})
+				if err != nil {
+					return err
+				}
+				id = created.AuthorId
+				byName[strings.ToLower(embedded.Fullname)] = id
+			}
+			course.AuthorId, course.Author = id, nil
+		}
+
+		if _, err := store.Update(ctx, course); err != nil {
+			return err
+		}
+		slog.Info("migrated embedded author", "course_id", course.CourseId, "author_id", course.AuthorId)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthorRoutes(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+
+	tests := []struct {
+		method, path, body string
+		status             int
+		contains           string
+	}{
+		{"GET", "/authors", "", http.StatusOK, `"total":1`},
+		{"GET", "/authors/1", "", http.StatusOK, `"fullName":"Hitesh Choudhary"`},
+		{"GET", "/authors/nope", "", http.StatusNotFound, ""},
+		{"POST", "/authors", `{"authorId":"9","fullName":"Anurag"}`, http.StatusUnprocessableEntity, "is assigned by the server"},
+		{"POST", "/authors", `{"website":"anurag.dev"}`, http.StatusUnprocessableEntity, "fullName"},
+		{"POST", "/authors", `{"fullName":"Anurag","website":"not a url"}`, http.StatusUnprocessableEntity, "website"},
+		{"PUT", "/authors/1", `{"authorId":"2","fullName":"Hitesh"}`, http.StatusUnprocessableEntity, "must match the id in the URL"},
+		{"PUT", "/authors/nope", `{"fullName":"Nobody"}`, http.StatusNotFound, ""},
+		{"GET", "/authors/1/courses", "", http.StatusOK, `"total":2`},
+		{"GET", "/authors/1/courses?sort=coursePrice", "", http.StatusOK, `"courseName":"MERN Stack"`},
+		{"GET", "/authors/nope/courses", "", http.StatusNotFound, ""},
+		{"POST", "/course", `{"courseName":"Go","coursePrice":5,"authorId":"nope"}`, http.StatusUnprocessableEntity, "authorId"},
+	}
+	for _, tt := range tests {
+		res, body := ts.do(t, tt.method, tt.path, tt.body)
+		if res.StatusCode != tt.status || !strings.Contains(body, tt.contains) {
+			t.Errorf("%s %s: %d %s, want %d with %s", tt.method, tt.path, res.StatusCode, body, tt.status, tt.contains)
+		}
+	}
+
+	// a new author gets an id, and their courses can reference it
+	res, body := ts.do(t, "POST", "/authors", `{"fullName":"Anurag","website":"https://anurag.dev"}`)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /authors: %d %s", res.StatusCode, body)
+	}
+	var created Author
+	if err := json.Unmarshal([]byte(body), &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.AuthorId == "" || res.Header.Get("Location") != "/authors/"+created.AuthorId || res.Header.Get("ETag") == "" {
+		t.Errorf("created %+v, Location %q, ETag %q", created, res.Header.Get("Location"), res.Header.Get("ETag"))
+	}
+	if res, body := ts.do(t, "POST", "/course", `{"courseName":"Go","coursePrice":5,"authorId":"`+created.AuthorId+`"}`); res.StatusCode != http.StatusCreated {
+		t.Errorf("course of the new author: %d %s", res.StatusCode, body)
+	}
+	if _, body := ts.do(t, "GET", "/authors/"+created.AuthorId+"/courses", ""); !strings.Contains(body, `"total":1`) {
+		t.Errorf("courses of the new author: %s", body)
+	}
+}
+
+// TestAuthorRename renames the seeded author once and checks every course
+// shows the new name, only when expanded.
+func TestAuthorRename(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+
+	res, body := ts.do(t, "PUT", "/authors/1", `{"fullName":"Hitesh C","website":"https://lco.dev"}`)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("PUT /authors/1: %d %s", res.StatusCode, body)
+	}
+	for _, id := range []string{"2", "4"} {
+		_, body := ts.do(t, "GET", "/course/"+id+"?expand=author", "")
+		if !strings.Contains(body, `"fullName":"Hitesh C"`) {
+			t.Errorf("course %s expanded: %s", id, body)
+		}
+		_, body = ts.do(t, "GET", "/course/"+id, "")
+		if strings.Contains(body, "fullName") || !strings.Contains(body, `"authorId":"1"`) {
+			t.Errorf("course %s: %s, want only the authorId", id, body)
+		}
+	}
+	_, body = ts.do(t, "GET", "/courses?expand=author&author.fullName=Hitesh%20C", "")
+	if !strings.Contains(body, `"total":2`) {
+		t.Errorf("filter on the new name: %s", body)
+	}
+	res, body = ts.do(t, "GET", "/course/2?expand=teacher", "")
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown expand: %d %s", res.StatusCode, body)
+	}
+}
+
+func TestDeleteAuthor(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+
+	steps := []struct {
+		method, path string
+		header       []string
+		status       int
+	}{
+		{"DELETE", "/authors/1", nil, http.StatusConflict},
+		{"DELETE", "/authors/1?cascade=maybe", nil, http.StatusBadRequest},
+		{"DELETE", "/authors/1?cascade=true", []string{"If-Match", `"999"`}, http.StatusPreconditionFailed},
+		{"GET", "/course/2", nil, http.StatusOK},
+		{"DELETE", "/authors/1?cascade=true", nil, http.StatusNoContent},
+		{"GET", "/authors/1", nil, http.StatusNotFound},
+		{"GET", "/course/2", nil, http.StatusNotFound},
+		{"GET", "/course/4", nil, http.StatusNotFound},
+		{"DELETE", "/authors/1", nil, http.StatusNotFound},
+	}
+	for _, step := range steps {
+		if res, body := ts.do(t, step.method, step.path, "", step.header...); res.StatusCode != step.status {
+			t.Errorf("%s %s: %d %s, want %d", step.method, step.path, res.StatusCode, body, step.status)
+		}
+	}
+
+	// an author without courses needs no cascade
+	res, body := ts.do(t, "POST", "/authors", `{"fullName":"Anurag"}`)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /authors: %d %s", res.StatusCode, body)
+	}
+	if res, body := ts.do(t, "DELETE", res.Header.Get("Location"), ""); res.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE an author without courses: %d %s", res.StatusCode, body)
+	}
+}
+
+func TestMigrateEmbeddedAuthors(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	if _, err := store.CreateAuthor(ctx, Author{AuthorId: "1", Fullname: "Hitesh Choudhary"}); err != nil {
+		t.Fatal(err)
+	}
+	old := []Course{
+		{CourseId: "a", CourseName: "A", Author: &Author{Fullname: "hitesh choudhary"}},
+		{CourseId: "b", CourseName: "B", Author: &Author{Fullname: "Anurag", Website: "https://anurag.dev"}},
+		{CourseId: "c", CourseName: "C", Author: &Author{Fullname: "ANURAG", Website: "https://other.dev"}},
+		{CourseId: "d", CourseName: "D", Author: &Author{}},
+		{CourseId: "e", CourseName: "E", AuthorId: "1"},
+	}
+	for _, course := range old {
+		if _, err := store.Create(ctx, course); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids := &uuidV7Generator{now: time.Now}
+	if err := migrateEmbeddedAuthors(store, ids); err != nil {
+		t.Fatal(err)
+	}
+	// running it again changes nothing
+	if err := migrateEmbeddedAuthors(store, ids); err != nil {
+		t.Fatal(err)
+	}
+
+	authors, err := store.ListAuthors(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(authors) != 2 {
+		t.Fatalf("authors = %+v, want Hitesh and one Anurag", authors)
+	}
+	var anurag Author
+	for _, a := range authors {
+		if a.AuthorId != "1" {
+			anurag = a
+		}
+	}
+	if anurag.Fullname != "Anurag" || anurag.Website != "https://anurag.dev" {
+		t.Errorf("new author = %+v, want the first copy", anurag)
+	}
+
+	want := map[string]string{"a": "1", "b": anurag.AuthorId, "c": anurag.AuthorId, "d": "", "e": "1"}
+	for id, authorId := range want {
+		course, err := store.Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if course.AuthorId != authorId || course.Author != nil {
+			t.Errorf("course %s: authorId %q, author %+v, want %q and no copy", id, course.AuthorId, course.Author, authorId)
+		}
+	}
+}
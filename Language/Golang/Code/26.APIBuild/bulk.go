@@ -0,0 +1,624 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxImportBytes caps an import. The body is read a row at a time, so
+	// this is about how long one request may run, not about memory.
+	maxImportBytes = 64 << 20
+
+	// exportFlushEvery is how many rows are written between flushes.
+	exportFlushEvery = 500
+
+	csvType    = "text/csv"
+	ndjsonType = "application/x-ndjson"
+)
+
+var ErrImportTooLarge = fmt.Errorf("import is larger than %d bytes", maxImportBytes)
+
+// csvColumns are the columns of an export, in order. An import needs a
+// header row naming some of them, in any order.
+var csvColumns = []string{"courseId", "courseName", "coursePrice", "courseSite", "authorId"}
+
+// exportFormats maps ?format= to the media type of an export.
+var exportFormats = map[string]string{
+	"json":   "application/json",
+	"ndjson": ndjsonType,
+	"csv":    csvType,
+}
+
+// exportCourses answers GET /courses:export. The format comes from
+// ?format=, else from Accept, else it is JSON. Rows are written as they
+// are encoded, not collected first.
+func (s *server) exportCourses(w http.ResponseWriter, r *http.Request) {
+	mediaType, err := exportMediaType(r)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	courses, err := s.store.List(r.Context())
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	ext := map[string]string{"application/json": "json", ndjsonType: "ndjson", csvType: "csv"}[mediaType]
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Content-Disposition", `attachment; filename="courses.`+ext+`"`)
+
+	flush := http.NewResponseController(w).Flush
+	var cw *csv.Writer
+	switch mediaType {
+	case csvType:
+		cw = csv.NewWriter(w)
+		cw.Write(csvColumns)
+	case "application/json":
+		io.WriteString(w, "[\n")
+	}
+	enc := json.NewEncoder(w)
+	for i, course := range courses {
+		course.Author = nil
+		switch mediaType {
+		case csvType:
+			cw.Write([]string{course.CourseId, course.CourseName, strconv.Itoa(course.CoursePrice), course.CourseSite, course.AuthorId})
+		case "application/json":
+			if i > 0 {
+				io.WriteString(w, ",\n")
+			}
+			b, _ := json.Marshal(course)
+			w.Write(b)
+		default:
+			enc.Encode(course)
+		}
+		if (i+1)%exportFlushEvery == 0 {
+			if cw != nil {
+				cw.Flush()
+			}
+			flush()
+		}
+	}
+	switch mediaType {
+	case csvType:
+		cw.Flush()
+	case "application/json":
+		io.WriteString(w, "\n]\n")
+	}
+}
+
+func exportMediaType(r *http.Request) (string, error) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		mediaType, ok := exportFormats[format]
+		if !ok {
+			return "", newProblem(http.StatusBadRequest, "format must be json, ndjson or csv")
+		}
+		return mediaType, nil
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "application/json", ndjsonType, csvType:
+			return mediaType, nil
+		case "application/ndjson":
+			return ndjsonType, nil
+		}
+	}
+	return "application/json", nil
+}
+
+// importMode says what happens to the good rows when some rows are bad.
+type importMode string
+
+const (
+	// importAtomic writes nothing unless every row is good, and undoes
+	// what it wrote when a write fails half way.
+	importAtomic importMode = "atomic"
+	// importBestEffort writes every good row and reports the bad ones.
+	importBestEffort importMode = "best-effort"
+)
+
+// importRow is the outcome of one row. Action is "create" or "update"
+// for a good row and empty for a bad one.
+type importRow struct {
+	Row      int              `json:"row"`
+	CourseId string           `json:"courseId,omitempty"`
+	Action   string           `json:"action,omitempty"`
+	Error    string           `json:"error,omitempty"`
+	Errors   ValidationErrors `json:"errors,omitempty"`
+}
+
+// importReport answers POST /courses:import. Applied says whether
+// anything was written; in a dry run it never is. RolledBack is only set
+// when every write of a failed atomic import was undone, the undos that
+// failed are in RollbackFailed and their writes are still in place.
+type importReport struct {
+	Mode           importMode  `json:"mode"`
+	DryRun         bool        `json:"dryRun"`
+	Applied        bool        `json:"applied"`
+	RolledBack     bool        `json:"rolledBack,omitempty"`
+	RollbackFailed []importRow `json:"rollbackFailed,omitempty"`
+	Created        int         `json:"created"`
+	Updated        int         `json:"updated"`
+	Failed         int         `json:"failed"`
+	Rows           []importRow `json:"rows"`
+	// Error is why reading stopped before the end of the body.
+	Error string `json:"error,omitempty"`
+}
+
+// importCourses answers POST /courses:import?mode=atomic|best-effort&dryRun=.
+// Rows with a courseId replace that course, rows without one create a
+// course with an id from the server, as POST /course does. The answer is
+// always the report, the status says how it went: 200, 422 when an atomic
+// import was refused, 500 when it could not be undone completely, or the
+// status of the error that stopped reading the body.
+func (s *server) importCourses(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	mode := importAtomic
+	if v := values.Get("mode"); v != "" {
+		mode = importMode(v)
+		if mode != importAtomic && mode != importBestEffort {
+			writeProblem(w, r, newProblem(http.StatusBadRequest, "mode must be atomic or best-effort"))
+			return
+		}
+	}
+	dryRun := false
+	if v := values.Get("dryRun"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			writeProblem(w, r, newProblem(http.StatusBadRequest, "dryRun must be true or false"))
+			return
+		}
+	}
+
+	rows, err := newRowReader(r.Header.Get("Content-Type"), http.MaxBytesReader(w, r.Body, maxImportBytes))
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	imp := &courseImport{s: s, report: importReport{Mode: mode, DryRun: dryRun, Rows: []importRow{}},
+		seen: make(map[string]int), authors: make(map[string]bool)}
+	var pending []importedCourse
+	status := http.StatusOK
+	for {
+		n, course, err := rows.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var bad ValidationErrors
+		if errors.As(err, &bad) {
+			imp.fail(importRow{Row: n, CourseId: course.CourseId, Errors: bad})
+			continue
+		}
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				err = ErrImportTooLarge
+			}
+			p := problemFor(err)
+			status = p.Status
+			imp.report.Error = fmt.Sprintf("row %d: %s", n, p.Detail)
+			break
+		}
+
+		row, ok := imp.check(ctx, n, course)
+		switch {
+		case !ok:
+		case dryRun:
+			imp.done(row.importRow)
+		case mode == importAtomic:
+			pending = append(pending, row)
+		default:
+			imp.apply(ctx, row)
+		}
+	}
+
+	if mode == importAtomic && !dryRun {
+		if imp.report.Failed > 0 || imp.report.Error != "" {
+			if status == http.StatusOK {
+				status = http.StatusUnprocessableEntity
+			}
+			for _, row := range pending {
+				imp.report.Rows = append(imp.report.Rows, row.importRow)
+			}
+		} else {
+			status = imp.applyAll(ctx, pending)
+		}
+	}
+	slices.SortStableFunc(imp.report.Rows, func(a, b importRow) int { return cmp.Compare(a.Row, b.Row) })
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(imp.report)
+}
+
+// courseImport is the state of one import request.
+type courseImport struct {
+	s       *server
+	report  importReport
+	seen    map[string]int  // course id to the row that had it
+	authors map[string]bool // author id to whether it exists
+}
+
+type importedCourse struct {
+	importRow
+	course Course
+}
+
+func (imp *courseImport) fail(row importRow) {
+	imp.report.Failed++
+	imp.report.Rows = append(imp.report.Rows, row)
+}
+
+func (imp *courseImport) done(row importRow) {
+	if row.Action == "create" {
+		imp.report.Created++
+	} else {
+		imp.report.Updated++
+	}
+	imp.report.Rows = append(imp.report.Rows, row)
+}
+
+// check validates a row the way the course endpoints would and works out
+// whether it creates or updates.
+func (imp *courseImport) check(ctx context.Context, n int, course Course) (importedCourse, bool) {
+	row := importedCourse{importRow: importRow{Row: n, CourseId: course.CourseId}, course: course}
+
+	var errs ValidationErrors
+	if course.Author != nil {
+		errs = append(errs, errEmbeddedAuthor...)
+	}
+	if id := course.CourseId; id != "" {
+		if first, ok := imp.seen[id]; ok {
+			errs = append(errs, FieldError{Field: "courseId", Message: fmt.Sprintf("is already used by row %d", first)})
+		} else {
+			imp.seen[id] = n
+		}
+	}
+	var verrs ValidationErrors
+	if err := validateStruct(course); errors.As(err, &verrs) {
+		errs = append(errs, verrs...)
+	}
+	if id := course.AuthorId; id != "" {
+		exists, ok := imp.authors[id]
+		if !ok {
+			_, err := imp.s.store.GetAuthor(ctx, id)
+			if err != nil && !errors.Is(err, ErrAuthorNotFound) {
+				imp.fail(importRow{Row: n, CourseId: course.CourseId, Error: problemFor(err).Detail})
+				return row, false
+			}
+			exists = err == nil
+			imp.authors[id] = exists
+		}
+		if !exists {
+			errs = append(errs, FieldError{Field: "authorId", Message: "does not name an author"})
+		}
+	}
+	if len(errs) > 0 {
+		row.Errors = errs
+		imp.fail(row.importRow)
+		return row, false
+	}
+
+	row.Action = "create"
+	if course.CourseId != "" {
+		_, err := imp.s.store.Get(ctx, course.CourseId)
+		switch {
+		case err == nil:
+			row.Action = "update"
+		case errors.Is(err, ErrCourseNotFound):
+			// ids belong to the server, a new course can not bring one
+			row.Errors = ValidationErrors{{Field: "courseId", Message: "names no course, leave it out to create one"}}
+			imp.fail(row.importRow)
+			return row, false
+		default:
+			row.Error = problemFor(err).Detail
+			imp.fail(row.importRow)
+			return row, false
+		}
+	}
+	return row, true
+}
+
+// write stores one checked row. For an update it also returns the course
+// as it was, so the write can be undone.
+func (imp *courseImport) write(ctx context.Context, row *importedCourse) (written Course, before *Course, err error) {
+	course := row.course
+	if course.CourseId == "" {
+		course.CourseId = imp.s.ids.NewId()
+		row.CourseId = course.CourseId
+	}
+	if row.Action == "update" {
+		current, err := imp.s.store.Get(ctx, course.CourseId)
+		if err != nil {
+			return Course{}, nil, err
+		}
+		course.Version = current.Version
+		written, err = imp.s.store.Update(ctx, course)
+		return written, &current, err
+	}
+	written, err = imp.s.store.Create(ctx, course)
+	return written, nil, err
+}
+
+func (imp *courseImport) apply(ctx context.Context, row importedCourse) {
+	if _, _, err := imp.write(ctx, &row); err != nil {
+		imp.failWrite(row.importRow, err)
+		return
+	}
+	imp.report.Applied = true
+	imp.done(row.importRow)
+}
+
+func (imp *courseImport) failWrite(row importRow, err error) {
+	p := problemFor(err)
+	row.Action = ""
+	if len(p.Errors) > 0 {
+		row.Errors = p.Errors
+	} else {
+		row.Error = p.Detail
+	}
+	imp.fail(row)
+}
+
+// applyAll writes the rows of an atomic import and returns the status to
+// answer with. The store has no transactions, so when a write fails the
+// ones before it are undone in reverse order: created courses are
+// deleted, updated ones put back. Webhooks are held meanwhile, and the
+// events of the writes that were undone, and of the undos, never go out.
+func (imp *courseImport) applyAll(ctx context.Context, rows []importedCourse) int {
+	type undo struct {
+		row     int
+		written Course
+		before  *Course
+	}
+	var undos []undo
+	var undone []eventKey
+	release := imp.s.webhooks.hold()
+	defer func() { release(undone...) }()
+	for i := range rows {
+		written, before, err := imp.write(ctx, &rows[i])
+		if err == nil {
+			undos = append(undos, undo{rows[i].Row, written, before})
+			continue
+		}
+
+		imp.failWrite(rows[i].importRow, err)
+		ctx := context.WithoutCancel(ctx)
+		for j := len(undos) - 1; j >= 0; j-- {
+			u := undos[j]
+			var err error
+			if u.before == nil {
+				err = imp.s.store.Delete(ctx, u.written.CourseId, u.written.Version)
+				undone = append(undone,
+					eventKey{EventCourseCreated, u.written.CourseId, u.written.Version},
+					eventKey{EventCourseDeleted, u.written.CourseId, u.written.Version})
+			} else {
+				restore := *u.before
+				restore.Version = u.written.Version
+				var restored Course
+				restored, err = imp.s.store.Update(ctx, restore)
+				undone = append(undone,
+					eventKey{EventCourseUpdated, u.written.CourseId, u.written.Version},
+					eventKey{EventCourseUpdated, u.written.CourseId, restored.Version})
+			}
+			if err != nil {
+				undone = undone[:len(undone)-2]
+				imp.s.logger.ErrorContext(ctx, "import rollback failed",
+					"course_id", u.written.CourseId, "error", err, "request_id", requestIdFromContext(ctx))
+				imp.report.RollbackFailed = append(imp.report.RollbackFailed,
+					importRow{Row: u.row, CourseId: u.written.CourseId, Error: problemFor(err).Detail})
+			}
+		}
+		for _, row := range rows {
+			if row.Row != rows[i].Row {
+				imp.report.Rows = append(imp.report.Rows, row.importRow)
+			}
+		}
+		if len(imp.report.RollbackFailed) > 0 {
+			// some of the import stayed written
+			imp.report.Applied = true
+			return http.StatusInternalServerError
+		}
+		imp.report.RolledBack = true
+		return http.StatusUnprocessableEntity
+	}
+
+	for _, row := range rows {
+		imp.done(row.importRow)
+	}
+	imp.report.Applied = len(rows) > 0
+	return http.StatusOK
+}
+
+// rowReader reads an import one course at a time. next returns io.EOF at
+// the end, ValidationErrors for a bad row that can be skipped and any
+// other error when the rest of the body can not be read.
+type rowReader interface {
+	next() (row int, course Course, err error)
+}
+
+func newRowReader(contentType string, body io.Reader) (rowReader, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = ""
+	}
+	switch mediaType {
+	case csvType:
+		return newCSVRows(body)
+	case ndjsonType, "application/ndjson":
+		return &ndjsonRows{r: bufio.NewReader(body)}, nil
+	case "application/json":
+		return newJSONArrayRows(body)
+	}
+	return nil, fmt.Errorf("%w: send the import as %s, %s or application/json", ErrUnsupportedMediaType, csvType, ndjsonType)
+}
+
+type csvRows struct {
+	r       *csv.Reader
+	columns []string
+	n       int
+}
+
+func newCSVRows(body io.Reader) (*csvRows, error) {
+	r := csv.NewReader(body)
+	r.ReuseRecord = true
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, ErrEmptyBody
+	}
+	if err != nil {
+		return nil, newProblem(http.StatusBadRequest, "the CSV header can not be read: "+err.Error())
+	}
+
+	columns := make([]string, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		if !slices.Contains(csvColumns, name) {
+			return nil, newProblem(http.StatusBadRequest, fmt.Sprintf("unknown CSV column %q, the columns are %s", name, strings.Join(csvColumns, ", ")))
+		}
+		if slices.Contains(columns[:i], name) {
+			return nil, newProblem(http.StatusBadRequest, fmt.Sprintf("CSV column %q appears twice", name))
+		}
+		columns[i] = name
+	}
+	return &csvRows{r: r, columns: columns}, nil
+}
+
+func (c *csvRows) next() (int, Course, error) {
+	record, err := c.r.Read()
+	if errors.Is(err, io.EOF) {
+		return c.n, Course{}, io.EOF
+	}
+	c.n++
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) && errors.Is(parseErr.Err, csv.ErrFieldCount) {
+		return c.n, Course{}, ValidationErrors{{Field: "row", Message: fmt.Sprintf("has %d fields, the header has %d", len(record), len(c.columns))}}
+	}
+	if err != nil {
+		return c.n, Course{}, newProblem(http.StatusBadRequest, err.Error())
+	}
+
+	var course Course
+	for i, value := range record {
+		switch c.columns[i] {
+		case "courseId":
+			course.CourseId = value
+		case "courseName":
+			course.CourseName = value
+		case "courseSite":
+			course.CourseSite = value
+		case "authorId":
+			course.AuthorId = value
+		case "coursePrice":
+			if value == "" {
+				continue
+			}
+			price, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return c.n, course, ValidationErrors{{Field: "coursePrice", Message: "must be a whole number"}}
+			}
+			course.CoursePrice = price
+		}
+	}
+	return c.n, course, nil
+}
+
+// ndjsonRows reads one JSON object per line. Blank lines are skipped and
+// a bad line only fails that row.
+type ndjsonRows struct {
+	r *bufio.Reader
+	n int
+}
+
+func (d *ndjsonRows) next() (int, Course, error) {
+	for {
+		line, err := d.r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) == 0 {
+			if err != nil {
+				return d.n, Course{}, err
+			}
+			continue
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return d.n + 1, Course{}, err
+		}
+		d.n++
+		var course Course
+		return d.n, course, rowError(decodeStrict(bytes.NewReader(line), &course))
+	}
+}
+
+// jsonArrayRows reads the elements of a JSON array one at a time. A
+// syntax error ends the import, an element of the wrong shape only fails
+// that row.
+type jsonArrayRows struct {
+	dec *json.Decoder
+	n   int
+}
+
+func newJSONArrayRows(body io.Reader) (*jsonArrayRows, error) {
+	dec := json.NewDecoder(body)
+	tok, err := dec.Token()
+	if errors.Is(err, io.EOF) {
+		return nil, ErrEmptyBody
+	}
+	if err != nil || tok != json.Delim('[') {
+		return nil, fmt.Errorf("%w: a JSON import must be an array of courses", ErrMalformedJSON)
+	}
+	return &jsonArrayRows{dec: dec}, nil
+}
+
+func (a *jsonArrayRows) next() (int, Course, error) {
+	if !a.dec.More() {
+		if _, err := a.dec.Token(); err != nil {
+			return a.n, Course{}, fmt.Errorf("%w: %v", ErrMalformedJSON, err)
+		}
+		if _, err := a.dec.Token(); !errors.Is(err, io.EOF) {
+			return a.n, Course{}, fmt.Errorf("%w: nothing may follow the array", ErrMalformedJSON)
+		}
+		return a.n, Course{}, io.EOF
+	}
+	a.n++
+	var raw json.RawMessage
+	if err := a.dec.Decode(&raw); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return a.n, Course{}, err
+		}
+		return a.n, Course{}, fmt.Errorf("%w: %v", ErrMalformedJSON, err)
+	}
+	var course Course
+	return a.n, course, rowError(decodeStrict(bytes.NewReader(raw), &course))
+}
+
+// rowError turns a decoding error of one row into ValidationErrors, so
+// the import goes on with the next row.
+func rowError(err error) error {
+	var verrs ValidationErrors
+	switch {
+	case err == nil, errors.As(err, &verrs):
+		return err
+	case errors.Is(err, ErrMalformedJSON), errors.Is(err, ErrEmptyBody):
+		return ValidationErrors{{Field: "row", Message: err.Error()}}
+	}
+	return err
+}
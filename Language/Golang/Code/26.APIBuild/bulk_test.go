@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestImportIds(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+
+	body := `[{"courseId":"2","courseName":"ReactJS 2","coursePrice":10,"authorId":"1"},{"courseName":"Go","coursePrice":5}]`
+	res, raw := ts.do(t, "POST", "/courses:import", body)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("import: %d %s", res.StatusCode, raw)
+	}
+	var report importReport
+	if err := json.Unmarshal([]byte(raw), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Created != 1 || report.Updated != 1 || report.Rows[1].CourseId == "" {
+		t.Errorf("report = %+v, want course 2 updated and one created with a new id", report)
+	}
+
+	// a course id nobody has is not taken from the client
+	res, raw = ts.do(t, "POST", "/courses:import?mode=best-effort", `[{"courseId":"mine","courseName":"Rust","coursePrice":5}]`)
+	report = importReport{}
+	if err := json.Unmarshal([]byte(raw), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Created != 0 || report.Failed != 1 || len(report.Rows[0].Errors) != 1 || report.Rows[0].Errors[0].Field != "courseId" {
+		t.Errorf("report = %+v, want the row refused on courseId", report)
+	}
+	if res, _ := ts.do(t, "GET", "/course/mine", ""); res.StatusCode != http.StatusNotFound {
+		t.Errorf("GET /course/mine: %d, want 404", res.StatusCode)
+	}
+}
+
+// failingStore fails to create courses named "fail" and, when
+// failDeletes is set, every delete.
+type failingStore struct {
+	CourseStore
+	failDeletes bool
+}
+
+func (s *failingStore) Create(ctx context.Context, course Course) (Course, error) {
+	if course.CourseName == "fail" {
+		return Course{}, errors.New("disk full")
+	}
+	return s.CourseStore.Create(ctx, course)
+}
+
+func (s *failingStore) Delete(ctx context.Context, id string, version int64) error {
+	if s.failDeletes {
+		return errors.New("disk full")
+	}
+	return s.CourseStore.Delete(ctx, id, version)
+}
+
+func TestImportRollback(t *testing.T) {
+	body := `[{"courseName":"Go","coursePrice":5},{"courseName":"fail","coursePrice":5}]`
+
+	tests := []struct {
+		name        string
+		failDeletes bool
+		status      int
+		rolledBack  bool
+	}{
+		{"undone", false, http.StatusUnprocessableEntity, true},
+		{"undo fails", true, http.StatusInternalServerError, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, &failingStore{CourseStore: newMemoryStore(), failDeletes: tt.failDeletes})
+			ctx := context.Background()
+			// skip the seed events, then listen
+			if err := ts.webhooks.poll(ctx); err != nil {
+				t.Fatal(err)
+			}
+			ts.webhooks.hooks.CreateSubscription(ctx, Subscription{Id: "sub", URL: "http://127.0.0.1:1"})
+
+			res, raw := ts.do(t, "POST", "/courses:import", body)
+			if res.StatusCode != tt.status {
+				t.Fatalf("import: %d, want %d: %s", res.StatusCode, tt.status, raw)
+			}
+			var report importReport
+			if err := json.Unmarshal([]byte(raw), &report); err != nil {
+				t.Fatal(err)
+			}
+			if report.RolledBack != tt.rolledBack || report.Applied == tt.rolledBack {
+				t.Errorf("rolledBack = %v, applied = %v, want %v and %v", report.RolledBack, report.Applied, tt.rolledBack, !tt.rolledBack)
+			}
+			if tt.failDeletes && (len(report.RollbackFailed) != 1 || report.RollbackFailed[0].Row != 1 || report.RollbackFailed[0].CourseId == "") {
+				t.Errorf("rollbackFailed = %+v, want row 1", report.RollbackFailed)
+			}
+			if !tt.failDeletes && len(report.RollbackFailed) != 0 {
+				t.Errorf("rollbackFailed = %+v, want none", report.RollbackFailed)
+			}
+
+			// only a write that stayed is announced
+			if err := ts.webhooks.poll(ctx); err != nil {
+				t.Fatal(err)
+			}
+			want := 0
+			if tt.failDeletes {
+				want = 1
+			}
+			if n := len(ts.webhooks.queue); n != want {
+				t.Errorf("%d webhook deliveries queued, want %d", n, want)
+			}
+		})
+	}
+}
+
+func TestImportTrailingData(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+	for _, body := range []string{`[{"courseName":"Go","coursePrice":5}]]`, `[{"courseName":"Go","coursePrice":5}] {}`} {
+		if res, raw := ts.do(t, "POST", "/courses:import", body); res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: %d %s, want 400", body, res.StatusCode, raw)
+		}
+	}
+}
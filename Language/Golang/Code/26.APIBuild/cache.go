@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// CacheStore is where cached responses live. Keys follow
+// <app>:<feature>:<id> from Cacheing/02.Backend_Caching, so one Redis can
+// be shared with other services. The memory store serves one instance;
+// redisCacheStore shares the cache, and its invalidations, between all of
+// them.
+type CacheStore interface {
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Incr bumps a counter that does not expire and returns the new value.
+	Incr(ctx context.Context, key string) (int64, error)
+	// SetCounter sets a counter that is dropped ttl later and then reads
+	// as one that was never bumped.
+	SetCounter(ctx context.Context, key string, n int64, ttl time.Duration) error
+	// Counters reads counters. One that was never bumped reads 0, or in
+	// memoryCacheStore the value its counters were reset to.
+	Counters(ctx context.Context, keys ...string) ([]int64, error)
+}
+
+// Responses are not deleted on a write. Each cache key holds the
+// generations of what the response was built from, and a write bumps
+// those; the old entries are never read again and run out. A request that
+// read the old data can only store it under the old generation, so it can
+// not bring stale data back.
+//
+// There is a generation per course, so they expire: a write sets the one
+// of its course to the new value of genCourses, which no earlier write
+// of that course had.
+const (
+	cacheKeyPrefix = "courses:resp:"
+	genCourses     = "courses:gen:courses"
+	genAuthors     = "courses:gen:authors"
+)
+
+func genCourse(id string) string {
+	return "courses:gen:course:" + id
+}
+
+// courseGenTTL is how long the generation of a course is kept after its
+// last write. Once dropped it reads 0 again, so it has to outlive every
+// response stored under 0 before that write.
+func courseGenTTL(ttl, staleFor time.Duration) time.Duration {
+	return max(24*time.Hour, 2*(ttl+staleFor))
+}
+
+// cachedResponse is a 200 response as the cache keeps it.
+type cachedResponse struct {
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	StoredAt   time.Time   `json:"storedAt"`
+	FreshUntil time.Time   `json:"freshUntil"`
+}
+
+// responseCache is cache-aside for GET routes: a hit is answered from the
+// store, a miss runs the handler and keeps a 200. Entries are fresh for
+// ttl and served stale for another staleFor while one request refreshes
+// them in the background. Concurrent misses of one key run the handler
+// once.
+type responseCache struct {
+	store    CacheStore
+	ttl      time.Duration
+	staleFor time.Duration
+	logger   *slog.Logger
+	lookups  *metricVec
+	flight   flightGroup
+}
+
+// cacheDeps names the generations a route's responses depend on.
+type cacheDeps func(r *http.Request) []string
+
+// courseListDeps: a page changes with any course and, through the joined
+// authors, with any author.
+func courseListDeps(r *http.Request) []string {
+	return []string{genCourses, genAuthors}
+}
+
+func courseDeps(r *http.Request) []string {
+	deps := []string{genCourse(mux.Vars(r)["id"])}
+	if r.URL.Query().Get("expand") != "" {
+		deps = append(deps, genAuthors)
+	}
+	return deps
+}
+
+// wrap caches next. It sits inside negotiate and the version middleware,
+// so the response format and API version, which Vary names, are part of
+// the key. A nil cache passes requests through.
+func (c *responseCache) wrap(deps cacheDeps, next http.HandlerFunc) http.HandlerFunc {
+	if c == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		directives := cacheDirectives(r.Header.Get("Cache-Control"))
+		if directives["no-store"] {
+			c.lookups.add(1, "bypass")
+			next(w, r)
+			return
+		}
+
+		key, err := c.key(r, deps(r))
+		if err != nil {
+			c.logger.WarnContext(r.Context(), "cache unavailable", "error", err)
+			c.lookups.add(1, "bypass")
+			next(w, r)
+			return
+		}
+
+		// no-cache and max-age=0 ask for a response checked with the
+		// origin, which here means built again
+		if !directives["no-cache"] && !directives["max-age=0"] {
+			entry, ok, err := c.get(r.Context(), key)
+			if err != nil {
+				c.logger.WarnContext(r.Context(), "cache read failed", "error", err)
+			}
+			if ok {
+				now := time.Now()
+				if now.Before(entry.FreshUntil) {
+					c.lookups.add(1, "hit")
+					c.write(w, r, &entry, "HIT")
+					return
+				}
+				c.lookups.add(1, "stale")
+				go c.fill(r.Clone(context.WithoutCancel(r.Context())), key, next)
+				c.write(w, r, &entry, "STALE")
+				return
+			}
+		}
+
+		c.lookups.add(1, "miss")
+		res := c.fill(r, key, next)
+		if res.entry == nil {
+			// not stored, so no Age or Cache-Control, but a miss all the same
+			w.Header().Set("X-Cache", "MISS")
+			if res.led {
+				// the handler ran for this request, pass its answer on
+				res.response.writeTo(w)
+				return
+			}
+			// an error or 304 built for another request, ask again
+			next(w, r)
+			return
+		}
+		c.write(w, r, res.entry, "MISS")
+	}
+}
+
+// key is the cache key of r: its generations, version, format and URL.
+func (c *responseCache) key(r *http.Request, deps []string) (string, error) {
+	gens, err := c.store.Counters(r.Context(), deps...)
+	if err != nil {
+		return "", err
+	}
+	parts := make([]string, len(gens))
+	for i, gen := range gens {
+		parts[i] = strconv.FormatInt(gen, 10)
+	}
+	return cacheKeyPrefix + strings.Join(parts, ".") +
+		":v" + strconv.Itoa(int(apiVersionFromContext(r.Context()))) +
+		":" + formatFromContext(r.Context()).mediaType +
+		":" + r.URL.RequestURI(), nil
+}
+
+func (c *responseCache) get(ctx context.Context, key string) (cachedResponse, bool, error) {
+	raw, ok, err := c.store.Get(ctx, key)
+	if err != nil || !ok {
+		return cachedResponse{}, false, err
+	}
+	var entry cachedResponse
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		return cachedResponse{}, false, err
+	}
+	return entry, true, nil
+}
+
+// fillResult is what one run of the handler for a key produced. entry is
+// nil when the response can not be cached.
+type fillResult struct {
+	entry    *cachedResponse
+	response *bufferedResponse
+	led      bool
+}
+
+// fill runs next once for all concurrent callers with the same key and
+// stores a 200. The run is detached from the request that started it, so
+// the others do not fail when that client goes away.
+func (c *responseCache) fill(r *http.Request, key string, next http.HandlerFunc) fillResult {
+	led := false
+	v := c.flight.do(key, func() any {
+		led = true
+		ctx := context.WithoutCancel(r.Context())
+		req := r.Clone(ctx)
+		// the cache answers conditional requests itself, the handler
+		// has to produce the full response to store
+		req.Header.Del("If-None-Match")
+
+		res := &bufferedResponse{header: http.Header{}}
+		next(res, req)
+		if res.status == 0 {
+			res.status = http.StatusOK
+		}
+		if res.status != http.StatusOK {
+			return fillResult{response: res}
+		}
+
+		now := time.Now()
+		entry := &cachedResponse{
+			Header:   res.header,
+			Body:     res.body.Bytes(),
+			StoredAt: now,
+			// jitter keeps entries filled together from expiring together
+			FreshUntil: now.Add(c.ttl - time.Duration(rand.Int63n(int64(c.ttl)/10+1))),
+		}
+		raw, err := json.Marshal(entry)
+		if err == nil {
+			err = c.store.Set(ctx, key, raw, c.ttl+c.staleFor)
+		}
+		if err != nil {
+			c.logger.WarnContext(ctx, "cache write failed", "error", err)
+		}
+		return fillResult{entry: entry, response: res}
+	})
+	res := v.(fillResult)
+	res.led = led
+	return res
+}
+
+// write answers from a cache entry, with 304 when If-None-Match still
+// matches.
+func (c *responseCache) write(w http.ResponseWriter, r *http.Request, entry *cachedResponse, state string) {
+	for name, values := range entry.Header {
+		w.Header()[name] = values
+	}
+	age := int(time.Since(entry.StoredAt).Seconds())
+	w.Header().Set("Age", strconv.Itoa(age))
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(c.ttl.Seconds()))+
+		", stale-while-revalidate="+strconv.Itoa(int(c.staleFor.Seconds())))
+	w.Header().Set("X-Cache", state)
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, entry.Header.Get("ETag"), true) {
+		w.Header().Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(entry.Body)
+}
+
+// cacheDirectives reads a request Cache-Control into a set, e.g.
+// "no-cache" or "max-age=0".
+func cacheDirectives(header string) map[string]bool {
+	out := map[string]bool{}
+	for _, d := range strings.Split(header, ",") {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			out[d] = true
+		}
+	}
+	return out
+}
+
+// bufferedResponse is a ResponseWriter that keeps everything in memory.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (res *bufferedResponse) Header() http.Header {
+	return res.header
+}
+
+func (res *bufferedResponse) WriteHeader(status int) {
+	if res.status == 0 {
+		res.status = status
+	}
+}
+
+func (res *bufferedResponse) Write(b []byte) (int, error) {
+	if res.status == 0 {
+		res.status = http.StatusOK
+	}
+	return res.body.Write(b)
+}
+
+// writeTo sends the buffered response to w.
+func (res *bufferedResponse) writeTo(w http.ResponseWriter) {
+	for name, values := range res.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(res.status)
+	w.Write(res.body.Bytes())
+}
+
+// flightGroup runs one call per key at a time; callers that come while it
+// runs wait for it and get the same result.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done  chan struct{}
+	value any
+}
+
+func (g *flightGroup) do(key string, fn func() any) any {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-call.done
+		return call.value
+	}
+	call := &flightCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+	call.value = fn()
+	return call.value
+}
+
+// memoryCacheStore keeps entries in a map and sweeps out expired ones at
+// most once a minute, when something is stored. Counters are kept up to
+// maxCounters; past that they are all dropped and start again above the
+// highest value any of them had, so no old cache key comes back. That
+// costs one cold cache.
+type memoryCacheStore struct {
+	mu          sync.Mutex
+	entries     map[string]memoryCacheEntry
+	counters    map[string]int64
+	maxCounters int
+	// counterBase is what a counter that is not in counters reads
+	counterBase int64
+	lastSweep   time.Time
+}
+
+type memoryCacheEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+func newMemoryCacheStore() *memoryCacheStore {
+	return &memoryCacheStore{entries: make(map[string]memoryCacheEntry), counters: make(map[string]int64), maxCounters: 10000}
+}
+
+func (s *memoryCacheStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok || time.Now().After(e.expiresAt) {
+		return nil, false, nil
+	}
+	return e.value, true, nil
+}
+
+func (s *memoryCacheStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, e := range s.entries {
+			if now.After(e.expiresAt) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	s.entries[key] = memoryCacheEntry{value: value, expiresAt: now.Add(ttl)}
+	return nil
+}
+
+func (s *memoryCacheStore) Incr(ctx context.Context, key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.counters[key]
+	if !ok {
+		s.makeRoomLocked()
+		n = s.counterBase
+	}
+	n++
+	s.counters[key] = n
+	return n, nil
+}
+
+// SetCounter ignores ttl, maxCounters bounds the counters here.
+func (s *memoryCacheStore) SetCounter(ctx context.Context, key string, n int64, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.counters[key]; !ok {
+		s.makeRoomLocked()
+	}
+	s.counters[key] = n
+	return nil
+}
+
+// makeRoomLocked drops every counter when there is no room for another.
+func (s *memoryCacheStore) makeRoomLocked() {
+	if len(s.counters) < s.maxCounters {
+		return
+	}
+	for _, v := range s.counters {
+		s.counterBase = max(s.counterBase, v)
+	}
+	s.counterBase++
+	clear(s.counters)
+}
+
+func (s *memoryCacheStore) Counters(ctx context.Context, keys ...string) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]int64, len(keys))
+	for i, key := range keys {
+		n, ok := s.counters[key]
+		if !ok {
+			n = s.counterBase
+		}
+		out[i] = n
+	}
+	return out, nil
+}
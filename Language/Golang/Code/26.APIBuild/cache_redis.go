@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisCacheStore is a CacheStore on anything that speaks the Redis
+// protocol (RESP2): Redis, Valkey, KeyDB or Dragonfly. It only needs GET,
+// SET with PX, INCR and MGET, and keeps a few connections open.
+type redisCacheStore struct {
+	addr string
+	// idle holds open connections, up to its capacity
+	idle        chan *redisConn
+	dialTimeout time.Duration
+}
+
+type redisConn struct {
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+// redisError is an error reply of the server, e.g. WRONGTYPE.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+var errRedisNil = errors.New("redis: nil reply")
+
+func newRedisCacheStore(addr string, poolSize int) *redisCacheStore {
+	return &redisCacheStore{addr: addr, idle: make(chan *redisConn, poolSize), dialTimeout: 2 * time.Second}
+}
+
+// Ping checks the server can be reached. Only startup needs it: a cache
+// that goes away later is skipped, reads still work without it.
+func (s *redisCacheStore) Ping(ctx context.Context) error {
+	_, err := s.do(ctx, "PING")
+	return err
+}
+
+func (s *redisCacheStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	reply, err := s.do(ctx, "GET", key)
+	if errors.Is(err, errRedisNil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("redis: GET answered %T", reply)
+	}
+	return value, true, nil
+}
+
+func (s *redisCacheStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	ms := ttl.Milliseconds()
+	if ms < 1 {
+		// Redis refuses PX 0, and the entry would be gone by now anyway
+		return nil
+	}
+	_, err := s.do(ctx, "SET", key, string(value), "PX", strconv.FormatInt(ms, 10))
+	return err
+}
+
+func (s *redisCacheStore) Incr(ctx context.Context, key string) (int64, error) {
+	reply, err := s.do(ctx, "INCR", key)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis: INCR answered %T", reply)
+	}
+	return n, nil
+}
+
+func (s *redisCacheStore) SetCounter(ctx context.Context, key string, n int64, ttl time.Duration) error {
+	return s.Set(ctx, key, []byte(strconv.FormatInt(n, 10)), ttl)
+}
+
+func (s *redisCacheStore) Counters(ctx context.Context, keys ...string) ([]int64, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	reply, err := s.do(ctx, append([]string{"MGET"}, keys...)...)
+	if err != nil {
+		return nil, err
+	}
+	values, ok := reply.([]any)
+	if !ok || len(values) != len(keys) {
+		return nil, fmt.Errorf("redis: MGET answered %T", reply)
+	}
+	out := make([]int64, len(keys))
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+		raw, _ := v.([]byte)
+		if out[i], err = strconv.ParseInt(string(raw), 10, 64); err != nil {
+			return nil, fmt.Errorf("redis: counter %s is not a number", keys[i])
+		}
+	}
+	return out, nil
+}
+
+// do sends one command and reads its reply. A connection that failed is
+// closed instead of going back to the pool, since a reply may be left on
+// it.
+func (s *redisCacheStore) do(ctx context.Context, args ...string) (any, error) {
+	c, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetDeadline(deadline)
+	} else {
+		c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	}
+
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		b.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+	reply, err := readRedisReply(c.rd)
+	var replyErr redisError
+	if err != nil && !errors.Is(err, errRedisNil) && !errors.As(err, &replyErr) {
+		c.conn.Close()
+		return nil, err
+	}
+	s.release(c)
+	return reply, err
+}
+
+func (s *redisCacheStore) conn(ctx context.Context) (*redisConn, error) {
+	select {
+	case c := <-s.idle:
+		return c, nil
+	default:
+	}
+	d := net.Dialer{Timeout: s.dialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, err
+	}
+	return &redisConn{conn: conn, rd: bufio.NewReader(conn)}, nil
+}
+
+func (s *redisCacheStore) release(c *redisConn) {
+	select {
+	case s.idle <- c:
+	default:
+		c.conn.Close()
+	}
+}
+
+// readRedisReply reads one RESP2 reply: a simple string, error, integer,
+// bulk string or array. An array is read to its end even when an element
+// is an error, so the connection can be used again; the first error is
+// returned.
+func readRedisReply(rd *bufio.Reader) (any, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		out := make([]any, n)
+		var replyErr error
+		for i := range out {
+			v, err := readRedisReply(rd)
+			var elemErr redisError
+			switch {
+			case errors.Is(err, errRedisNil):
+			case errors.As(err, &elemErr):
+				if replyErr == nil {
+					replyErr = elemErr
+				}
+			case err != nil:
+				return nil, err
+			default:
+				out[i] = v
+			}
+		}
+		if replyErr != nil {
+			return nil, replyErr
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
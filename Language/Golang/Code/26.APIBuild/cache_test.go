@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadRedisReplyArrayError(t *testing.T) {
+	rd := bufio.NewReader(strings.NewReader("*3\r\n$1\r\na\r\n-WRONGTYPE not a string\r\n:5\r\n+OK\r\n"))
+
+	_, err := readRedisReply(rd)
+	var replyErr redisError
+	if !errors.As(err, &replyErr) || !strings.HasPrefix(string(replyErr), "WRONGTYPE") {
+		t.Fatalf("err = %v, want the WRONGTYPE element", err)
+	}
+	// the rest of the array was read, the next reply is the next one
+	if reply, err := readRedisReply(rd); reply != "OK" || err != nil {
+		t.Errorf("next reply = %v, %v, want OK", reply, err)
+	}
+}
+
+func TestRedisSetShortTTL(t *testing.T) {
+	// nothing listens there, so a SET that is sent fails
+	s := newRedisCacheStore("127.0.0.1:1", 1)
+	if err := s.Set(context.Background(), "k", []byte("v"), 500*time.Microsecond); err != nil {
+		t.Errorf("Set under 1ms: %v, want it skipped", err)
+	}
+}
+
+func TestMemoryCacheCountersBound(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryCacheStore()
+	s.maxCounters = 2
+
+	s.Incr(ctx, "a")
+	s.Incr(ctx, "a")
+	s.Incr(ctx, "b")
+	before, _ := s.Counters(ctx, "a", "b", "c")
+	s.Incr(ctx, "c")
+	after, _ := s.Counters(ctx, "a", "b", "c")
+
+	if len(s.counters) > s.maxCounters {
+		t.Errorf("%d counters kept, want at most %d", len(s.counters), s.maxCounters)
+	}
+	for i, n := range after {
+		if n <= before[i] {
+			t.Errorf("counter %d went from %d to %d, an old cache key could come back", i, before[i], n)
+		}
+	}
+}
+
+func TestCacheMissHeaderOnError(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+	res, _ := ts.do(t, "GET", "/course/nope", "")
+	if res.StatusCode != http.StatusNotFound || res.Header.Get("X-Cache") != "MISS" {
+		t.Errorf("GET /course/nope: %d, X-Cache %q, want 404 and MISS", res.StatusCode, res.Header.Get("X-Cache"))
+	}
+}
+
+func TestRedisSetCounter(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	got := make(chan any, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		cmd, _ := readRedisReply(bufio.NewReader(conn))
+		got <- cmd
+		io.WriteString(conn, "+OK\r\n")
+	}()
+
+	s := newRedisCacheStore(ln.Addr().String(), 1)
+	if err := s.SetCounter(context.Background(), genCourse("2"), 7, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	var args []string
+	for _, arg := range (<-got).([]any) {
+		args = append(args, string(arg.([]byte)))
+	}
+	if want := []string{"SET", "courses:gen:course:2", "7", "PX", "3600000"}; !slices.Equal(args, want) {
+		t.Errorf("sent %q, want %q", args, want)
+	}
+}
+
+// ttlCacheStore records the TTLs counters are set with.
+type ttlCacheStore struct {
+	*memoryCacheStore
+	ttls map[string]time.Duration
+}
+
+func (s *ttlCacheStore) SetCounter(ctx context.Context, key string, n int64, ttl time.Duration) error {
+	s.ttls[key] = ttl
+	return s.memoryCacheStore.SetCounter(ctx, key, n, ttl)
+}
+
+func TestCourseGenerationsExpire(t *testing.T) {
+	ctx := context.Background()
+	cache := &ttlCacheStore{memoryCacheStore: newMemoryCacheStore(), ttls: map[string]time.Duration{}}
+	genTTL := courseGenTTL(time.Minute, time.Minute)
+	store := newInvalidatingStore(newMemoryStore(), cache, genTTL, slog.New(slog.NewJSONHandler(io.Discard, nil)))
+	if genTTL <= 2*time.Minute {
+		t.Fatalf("courseGenTTL = %v, want more than the life of a response", genTTL)
+	}
+
+	var seen []int64
+	course, err := store.Create(ctx, Course{CourseId: "c1", CourseName: "Go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for step := range 3 {
+		gens, _ := cache.Counters(ctx, genCourses, genCourse("c1"))
+		if gens[1] != gens[0] || slices.Contains(seen, gens[1]) {
+			t.Fatalf("step %d: course generation %d, genCourses %d, earlier %v", step, gens[1], gens[0], seen)
+		}
+		seen = append(seen, gens[1])
+		if cache.ttls[genCourse("c1")] != genTTL {
+			t.Errorf("step %d: TTL %v, want %v", step, cache.ttls[genCourse("c1")], genTTL)
+		}
+
+		switch step {
+		case 0:
+			course.CoursePrice = 5
+			if course, err = store.Update(ctx, course); err != nil {
+				t.Fatal(err)
+			}
+		case 1:
+			if err := store.Delete(ctx, "c1", course.Version); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// serverConfig is how the HTTP and gRPC servers listen. Every field can come from
+// a JSON config file, the environment or a flag; later sources win.
+type serverConfig struct {
+	Port              int
+	GRPCPort          int // 0 turns the gRPC server off
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	TLSCertFile       string
+	TLSKeyFile        string
+	ShutdownTimeout   time.Duration
+}
+
+type serverSetting struct {
+	name  string // flag name and key in the config file
+	env   string
+	def   string
+	usage string
+}
+
+var serverSettings = []serverSetting{
+	{"port", "PORT", "4000", "port to listen on"},
+	{"grpc-port", "GRPC_PORT", "9090", "port for the gRPC CourseService, 0 turns it off"},
+	{"read-timeout", "READ_TIMEOUT", "15s", "longest time to read a request, body included"},
+	{"read-header-timeout", "READ_HEADER_TIMEOUT", "5s", "longest time to read the request headers"},
+	{"write-timeout", "WRITE_TIMEOUT", "30s", "longest time to write a response"},
+	{"idle-timeout", "IDLE_TIMEOUT", "2m", "how long a keep-alive connection may sit idle"},
+	{"max-header-bytes", "MAX_HEADER_BYTES", "1048576", "largest request header block accepted"},
+	{"tls-cert", "TLS_CERT_FILE", "", "PEM certificate, serves HTTPS together with -tls-key"},
+	{"tls-key", "TLS_KEY_FILE", "", "PEM private key of -tls-cert"},
+	{"shutdown-timeout", "SHUTDOWN_TIMEOUT", "25s", "how long to drain requests after SIGINT or SIGTERM"},
+}
+
+// set parses one setting given by its flag name.
+func (c *serverConfig) set(name, value string) error {
+	var err error
+	switch name {
+	case "port":
+		c.Port, err = strconv.Atoi(value)
+	case "grpc-port":
+		c.GRPCPort, err = strconv.Atoi(value)
+	case "read-timeout":
+		c.ReadTimeout, err = time.ParseDuration(value)
+	case "read-header-timeout":
+		c.ReadHeaderTimeout, err = time.ParseDuration(value)
+	case "write-timeout":
+		c.WriteTimeout, err = time.ParseDuration(value)
+	case "idle-timeout":
+		c.IdleTimeout, err = time.ParseDuration(value)
+	case "max-header-bytes":
+		c.MaxHeaderBytes, err = strconv.Atoi(value)
+	case "tls-cert":
+		c.TLSCertFile = value
+	case "tls-key":
+		c.TLSKeyFile = value
+	case "shutdown-timeout":
+		c.ShutdownTimeout, err = time.ParseDuration(value)
+	default:
+		return fmt.Errorf("unknown setting %q", name)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+func (c serverConfig) validate() error {
+	if c.Port < 1 || c.Port > 65535 {
+		return fmt.Errorf("port %d is out of range", c.Port)
+	}
+	if c.GRPCPort < 0 || c.GRPCPort > 65535 {
+		return fmt.Errorf("grpc-port %d is out of range", c.GRPCPort)
+	}
+	if c.GRPCPort == c.Port {
+		return errors.New("port and grpc-port must differ")
+	}
+	if c.MaxHeaderBytes < 1 {
+		return errors.New("max-header-bytes must be positive")
+	}
+	for _, d := range []time.Duration{c.ReadTimeout, c.ReadHeaderTimeout, c.WriteTimeout, c.IdleTimeout, c.ShutdownTimeout} {
+		if d < 0 {
+			return errors.New("timeouts can not be negative")
+		}
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return errors.New("tls-cert and tls-key go together")
+	}
+	return nil
+}
+
+// serverConfigFlags registers a flag for every server setting and -config.
+// The returned func, called after fs.Parse, resolves the config: defaults,
+// then the config file, then the environment, then the flags that were
+// given on the command line.
+func serverConfigFlags(fs *flag.FlagSet) func() (serverConfig, error) {
+	for _, st := range serverSettings {
+		fs.String(st.name, st.def, st.usage+" (env "+st.env+")")
+	}
+	configPath := fs.String("config", os.Getenv("CONFIG_FILE"), "JSON file with server settings, keyed by flag name (env CONFIG_FILE)")
+
+	return func() (serverConfig, error) {
+		var cfg serverConfig
+		for _, st := range serverSettings {
+			if err := cfg.set(st.name, st.def); err != nil {
+				return cfg, err
+			}
+		}
+
+		if *configPath != "" {
+			if err := cfg.loadFile(*configPath); err != nil {
+				return cfg, err
+			}
+		}
+
+		for _, st := range serverSettings {
+			if value, ok := os.LookupEnv(st.env); ok {
+				if err := cfg.set(st.name, value); err != nil {
+					return cfg, fmt.Errorf("env %s: %w", st.env, err)
+				}
+			}
+		}
+
+		var err error
+		fs.Visit(func(f *flag.Flag) {
+			if err == nil && f.Name != "config" && isServerSetting(f.Name) {
+				err = cfg.set(f.Name, f.Value.String())
+			}
+		})
+		if err != nil {
+			return cfg, err
+		}
+		return cfg, cfg.validate()
+	}
+}
+
+// loadFile reads a JSON object like {"port": 8080, "read-timeout": "10s"}.
+func (c *serverConfig) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var values map[string]any
+	if err := dec.Decode(&values); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for name, value := range values {
+		if !isServerSetting(name) {
+			return fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+		if err := c.set(name, fmt.Sprint(value)); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func isServerSetting(name string) bool {
+	for _, st := range serverSettings {
+		if st.name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loadConfig resolves the server config from args, with file as the
+// content of the -config file when it is not "".
+func loadConfig(t *testing.T, file string, args ...string) (serverConfig, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	load := serverConfigFlags(fs)
+	if file != "" {
+		path := filepath.Join(t.TempDir(), "config.json")
+		if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		args = append([]string{"-config", path}, args...)
+	}
+	if err := fs.Parse(args); err != nil {
+		return serverConfig{}, err
+	}
+	return load()
+}
+
+func TestServerConfigDefaults(t *testing.T) {
+	cfg, err := loadConfig(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := serverConfig{
+		Port:              4000,
+		GRPCPort:          9090,
+		ReadTimeout:       15 * time.Second,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		MaxHeaderBytes:    1 << 20,
+		ShutdownTimeout:   25 * time.Second,
+	}
+	if cfg != want {
+		t.Errorf("defaults = %+v, want %+v", cfg, want)
+	}
+}
+
+// TestServerConfigSources checks that the file beats the defaults, the
+// environment beats the file and a flag beats the environment.
+func TestServerConfigSources(t *testing.T) {
+	t.Setenv("READ_TIMEOUT", "7s")
+	t.Setenv("PORT", "5000")
+
+	cfg, err := loadConfig(t, `{"port": 8080, "read-timeout": "10s", "idle-timeout": "1m", "grpc-port": 0}`, "-port", "6000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 6000 {
+		t.Errorf("port = %d, want the flag's 6000", cfg.Port)
+	}
+	if cfg.ReadTimeout != 7*time.Second {
+		t.Errorf("read-timeout = %v, want the environment's 7s", cfg.ReadTimeout)
+	}
+	if cfg.IdleTimeout != time.Minute || cfg.GRPCPort != 0 {
+		t.Errorf("idle-timeout = %v, grpc-port = %d, want the file's 1m and 0", cfg.IdleTimeout, cfg.GRPCPort)
+	}
+	if cfg.WriteTimeout != 30*time.Second {
+		t.Errorf("write-timeout = %v, want the default 30s", cfg.WriteTimeout)
+	}
+}
+
+func TestServerConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  []string
+		args []string
+		want string
+	}{
+		{"port range", "", nil, []string{"-port", "70000"}, "port 70000 is out of range"},
+		{"grpc port range", "", nil, []string{"-grpc-port", "-1"}, "grpc-port -1 is out of range"},
+		{"same ports", "", nil, []string{"-port", "9090"}, "port and grpc-port must differ"},
+		{"header bytes", "", nil, []string{"-max-header-bytes", "0"}, "max-header-bytes must be positive"},
+		{"negative timeout", "", nil, []string{"-write-timeout", "-1s"}, "timeouts can not be negative"},
+		{"half tls", "", nil, []string{"-tls-cert", "cert.pem"}, "tls-cert and tls-key go together"},
+		{"bad flag", "", nil, []string{"-read-timeout", "soon"}, "read-timeout"},
+		{"bad env", "", []string{"IDLE_TIMEOUT", "forever"}, nil, "env IDLE_TIMEOUT: idle-timeout"},
+		{"unknown file key", `{"prot": 80}`, nil, nil, `unknown setting "prot"`},
+		{"bad file value", `{"port": "eighty"}`, nil, nil, "port"},
+		{"bad file", `{"port": 80`, nil, nil, "config.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i+1 < len(tt.env); i += 2 {
+				t.Setenv(tt.env[i], tt.env[i+1])
+			}
+			_, err := loadConfig(t, tt.file, tt.args...)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := loadConfig(t, "", "-config", filepath.Join(t.TempDir(), "missing.json")); !os.IsNotExist(err) {
+		t.Errorf("missing config file: err = %v", err)
+	}
+}
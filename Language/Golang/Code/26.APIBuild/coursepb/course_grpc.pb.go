@@ -0,0 +1,577 @@
+// CourseService is the gRPC face of the Course API. It is served by the
+// same process as the REST routes and reads and writes the same store, so
+// a course created over one shows up on the other.
+//
+// Errors carry the same information as the REST problem documents: the
+// status code maps the HTTP status, and the details hold a
+// google.rpc.ErrorInfo (reason and domain), a google.rpc.BadRequest with
+// one field violation per invalid field, and a google.rpc.RequestInfo with
+// the request id.
+//
+// Regenerate the Go code in coursepb after changing this file, from
+// 26.APIBuild with the googleapis protos (for google/rpc) on the path:
+//
+//	protoc -I proto -I $GOOGLEAPIS \
+//	  --go_out=. --go_opt=module=example.com/hello/Code/26.APIBuild \
+//	  --go-grpc_out=. --go-grpc_opt=module=example.com/hello/Code/26.APIBuild \
+//	  courses/v1/course.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             v5.27.1
+// source: courses/v1/course.proto
+
+package coursepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	CourseService_GetCourse_FullMethodName         = "/courses.v1.CourseService/GetCourse"
+	CourseService_ListCourses_FullMethodName       = "/courses.v1.CourseService/ListCourses"
+	CourseService_CreateCourse_FullMethodName      = "/courses.v1.CourseService/CreateCourse"
+	CourseService_UpdateCourse_FullMethodName      = "/courses.v1.CourseService/UpdateCourse"
+	CourseService_DeleteCourse_FullMethodName      = "/courses.v1.CourseService/DeleteCourse"
+	CourseService_BulkUpsertCourses_FullMethodName = "/courses.v1.CourseService/BulkUpsertCourses"
+	CourseService_GetAuthor_FullMethodName         = "/courses.v1.CourseService/GetAuthor"
+	CourseService_ListAuthors_FullMethodName       = "/courses.v1.CourseService/ListAuthors"
+	CourseService_CreateAuthor_FullMethodName      = "/courses.v1.CourseService/CreateAuthor"
+	CourseService_UpdateAuthor_FullMethodName      = "/courses.v1.CourseService/UpdateAuthor"
+	CourseService_DeleteAuthor_FullMethodName      = "/courses.v1.CourseService/DeleteAuthor"
+)
+
+// CourseServiceClient is the client API for CourseService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type CourseServiceClient interface {
+	GetCourse(ctx context.Context, in *GetCourseRequest, opts ...grpc.CallOption) (*Course, error)
+	// ListCourses sends every matching course as its own message, in the
+	// order they were created.
+	ListCourses(ctx context.Context, in *ListCoursesRequest, opts ...grpc.CallOption) (CourseService_ListCoursesClient, error)
+	CreateCourse(ctx context.Context, in *CreateCourseRequest, opts ...grpc.CallOption) (*Course, error)
+	UpdateCourse(ctx context.Context, in *UpdateCourseRequest, opts ...grpc.CallOption) (*Course, error)
+	DeleteCourse(ctx context.Context, in *DeleteCourseRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// BulkUpsertCourses answers every request with one result, in order. A
+	// bad course fails only its own result, the stream goes on.
+	BulkUpsertCourses(ctx context.Context, opts ...grpc.CallOption) (CourseService_BulkUpsertCoursesClient, error)
+	GetAuthor(ctx context.Context, in *GetAuthorRequest, opts ...grpc.CallOption) (*Author, error)
+	ListAuthors(ctx context.Context, in *ListAuthorsRequest, opts ...grpc.CallOption) (*ListAuthorsResponse, error)
+	CreateAuthor(ctx context.Context, in *CreateAuthorRequest, opts ...grpc.CallOption) (*Author, error)
+	UpdateAuthor(ctx context.Context, in *UpdateAuthorRequest, opts ...grpc.CallOption) (*Author, error)
+	DeleteAuthor(ctx context.Context, in *DeleteAuthorRequest, opts ...grpc.CallOption) (*DeleteAuthorResponse, error)
+}
+
+type courseServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCourseServiceClient(cc grpc.ClientConnInterface) CourseServiceClient {
+	return &courseServiceClient{cc}
+}
+
+func (c *courseServiceClient) GetCourse(ctx context.Context, in *GetCourseRequest, opts ...grpc.CallOption) (*Course, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Course)
+	err := c.cc.Invoke(ctx, CourseService_GetCourse_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *courseServiceClient) ListCourses(ctx context.Context, in *ListCoursesRequest, opts ...grpc.CallOption) (CourseService_ListCoursesClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &CourseService_ServiceDesc.Streams[0], CourseService_ListCourses_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &courseServiceListCoursesClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type CourseService_ListCoursesClient interface {
+	Recv() (*Course, error)
+	grpc.ClientStream
+}
+
+type courseServiceListCoursesClient struct {
+	grpc.ClientStream
+}
+
+func (x *courseServiceListCoursesClient) Recv() (*Course, error) {
+	m := new(Course)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *courseServiceClient) CreateCourse(ctx context.Context, in *CreateCourseRequest, opts ...grpc.CallOption) (*Course, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Course)
+	err := c.cc.Invoke(ctx, CourseService_CreateCourse_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *courseServiceClient) UpdateCourse(ctx context.Context, in *UpdateCourseRequest, opts ...grpc.CallOption) (*Course, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Course)
+	err := c.cc.Invoke(ctx, CourseService_UpdateCourse_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *courseServiceClient) DeleteCourse(ctx context.Context, in *DeleteCourseRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, CourseService_DeleteCourse_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *courseServiceClient) BulkUpsertCourses(ctx context.Context, opts ...grpc.CallOption) (CourseService_BulkUpsertCoursesClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &CourseService_ServiceDesc.Streams[1], CourseService_BulkUpsertCourses_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &courseServiceBulkUpsertCoursesClient{ClientStream: stream}
+	return x, nil
+}
+
+type CourseService_BulkUpsertCoursesClient interface {
+	Send(*UpsertCourseRequest) error
+	Recv() (*UpsertCourseResult, error)
+	grpc.ClientStream
+}
+
+type courseServiceBulkUpsertCoursesClient struct {
+	grpc.ClientStream
+}
+
+func (x *courseServiceBulkUpsertCoursesClient) Send(m *UpsertCourseRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *courseServiceBulkUpsertCoursesClient) Recv() (*UpsertCourseResult, error) {
+	m := new(UpsertCourseResult)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *courseServiceClient) GetAuthor(ctx context.Context, in *GetAuthorRequest, opts ...grpc.CallOption) (*Author, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Author)
+	err := c.cc.Invoke(ctx, CourseService_GetAuthor_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *courseServiceClient) ListAuthors(ctx context.Context, in *ListAuthorsRequest, opts ...grpc.CallOption) (*ListAuthorsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListAuthorsResponse)
+	err := c.cc.Invoke(ctx, CourseService_ListAuthors_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *courseServiceClient) CreateAuthor(ctx context.Context, in *CreateAuthorRequest, opts ...grpc.CallOption) (*Author, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Author)
+	err := c.cc.Invoke(ctx, CourseService_CreateAuthor_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *courseServiceClient) UpdateAuthor(ctx context.Context, in *UpdateAuthorRequest, opts ...grpc.CallOption) (*Author, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Author)
+	err := c.cc.Invoke(ctx, CourseService_UpdateAuthor_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *courseServiceClient) DeleteAuthor(ctx context.Context, in *DeleteAuthorRequest, opts ...grpc.CallOption) (*DeleteAuthorResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteAuthorResponse)
+	err := c.cc.Invoke(ctx, CourseService_DeleteAuthor_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CourseServiceServer is the server API for CourseService service.
+// All implementations must embed UnimplementedCourseServiceServer
+// for forward compatibility
+type CourseServiceServer interface {
+	GetCourse(context.Context, *GetCourseRequest) (*Course, error)
+	// ListCourses sends every matching course as its own message, in the
+	// order they were created.
+	ListCourses(*ListCoursesRequest, CourseService_ListCoursesServer) error
+	CreateCourse(context.Context, *CreateCourseRequest) (*Course, error)
+	UpdateCourse(context.Context, *UpdateCourseRequest) (*Course, error)
+	DeleteCourse(context.Context, *DeleteCourseRequest) (*emptypb.Empty, error)
+	// BulkUpsertCourses answers every request with one result, in order. A
+	// bad course fails only its own result, the stream goes on.
+	BulkUpsertCourses(CourseService_BulkUpsertCoursesServer) error
+	GetAuthor(context.Context, *GetAuthorRequest) (*Author, error)
+	ListAuthors(context.Context, *ListAuthorsRequest) (*ListAuthorsResponse, error)
+	CreateAuthor(context.Context, *CreateAuthorRequest) (*Author, error)
+	UpdateAuthor(context.Context, *UpdateAuthorRequest) (*Author, error)
+	DeleteAuthor(context.Context, *DeleteAuthorRequest) (*DeleteAuthorResponse, error)
+	mustEmbedUnimplementedCourseServiceServer()
+}
+
+// UnimplementedCourseServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedCourseServiceServer struct {
+}
+
+func (UnimplementedCourseServiceServer) GetCourse(context.Context, *GetCourseRequest) (*Course, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCourse not implemented")
+}
+func (UnimplementedCourseServiceServer) ListCourses(*ListCoursesRequest, CourseService_ListCoursesServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListCourses not implemented")
+}
+func (UnimplementedCourseServiceServer) CreateCourse(context.Context, *CreateCourseRequest) (*Course, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateCourse not implemented")
+}
+func (UnimplementedCourseServiceServer) UpdateCourse(context.Context, *UpdateCourseRequest) (*Course, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateCourse not implemented")
+}
+func (UnimplementedCourseServiceServer) DeleteCourse(context.Context, *DeleteCourseRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteCourse not implemented")
+}
+func (UnimplementedCourseServiceServer) BulkUpsertCourses(CourseService_BulkUpsertCoursesServer) error {
+	return status.Errorf(codes.Unimplemented, "method BulkUpsertCourses not implemented")
+}
+func (UnimplementedCourseServiceServer) GetAuthor(context.Context, *GetAuthorRequest) (*Author, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAuthor not implemented")
+}
+func (UnimplementedCourseServiceServer) ListAuthors(context.Context, *ListAuthorsRequest) (*ListAuthorsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAuthors not implemented")
+}
+func (UnimplementedCourseServiceServer) CreateAuthor(context.Context, *CreateAuthorRequest) (*Author, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateAuthor not implemented")
+}
+func (UnimplementedCourseServiceServer) UpdateAuthor(context.Context, *UpdateAuthorRequest) (*Author, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateAuthor not implemented")
+}
+func (UnimplementedCourseServiceServer) DeleteAuthor(context.Context, *DeleteAuthorRequest) (*DeleteAuthorResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteAuthor not implemented")
+}
+func (UnimplementedCourseServiceServer) mustEmbedUnimplementedCourseServiceServer() {}
+
+// UnsafeCourseServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CourseServiceServer will
+// result in compilation errors.
+type UnsafeCourseServiceServer interface {
+	mustEmbedUnimplementedCourseServiceServer()
+}
+
+func RegisterCourseServiceServer(s grpc.ServiceRegistrar, srv CourseServiceServer) {
+	s.RegisterService(&CourseService_ServiceDesc, srv)
+}
+
+func _CourseService_GetCourse_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetCourseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CourseServiceServer).GetCourse(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CourseService_GetCourse_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CourseServiceServer).GetCourse(ctx, req.(*GetCourseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CourseService_ListCourses_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListCoursesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CourseServiceServer).ListCourses(m, &courseServiceListCoursesServer{ServerStream: stream})
+}
+
+type CourseService_ListCoursesServer interface {
+	Send(*Course) error
+	grpc.ServerStream
+}
+
+type courseServiceListCoursesServer struct {
+	grpc.ServerStream
+}
+
+func (x *courseServiceListCoursesServer) Send(m *Course) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _CourseService_CreateCourse_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateCourseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CourseServiceServer).CreateCourse(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CourseService_CreateCourse_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CourseServiceServer).CreateCourse(ctx, req.(*CreateCourseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CourseService_UpdateCourse_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateCourseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CourseServiceServer).UpdateCourse(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CourseService_UpdateCourse_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CourseServiceServer).UpdateCourse(ctx, req.(*UpdateCourseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CourseService_DeleteCourse_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteCourseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CourseServiceServer).DeleteCourse(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CourseService_DeleteCourse_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CourseServiceServer).DeleteCourse(ctx, req.(*DeleteCourseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CourseService_BulkUpsertCourses_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(CourseServiceServer).BulkUpsertCourses(&courseServiceBulkUpsertCoursesServer{ServerStream: stream})
+}
+
+type CourseService_BulkUpsertCoursesServer interface {
+	Send(*UpsertCourseResult) error
+	Recv() (*UpsertCourseRequest, error)
+	grpc.ServerStream
+}
+
+type courseServiceBulkUpsertCoursesServer struct {
+	grpc.ServerStream
+}
+
+func (x *courseServiceBulkUpsertCoursesServer) Send(m *UpsertCourseResult) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *courseServiceBulkUpsertCoursesServer) Recv() (*UpsertCourseRequest, error) {
+	m := new(UpsertCourseRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _CourseService_GetAuthor_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetAuthorRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CourseServiceServer).GetAuthor(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CourseService_GetAuthor_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CourseServiceServer).GetAuthor(ctx, req.(*GetAuthorRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CourseService_ListAuthors_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListAuthorsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CourseServiceServer).ListAuthors(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CourseService_ListAuthors_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CourseServiceServer).ListAuthors(ctx, req.(*ListAuthorsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CourseService_CreateAuthor_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateAuthorRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CourseServiceServer).CreateAuthor(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CourseService_CreateAuthor_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CourseServiceServer).CreateAuthor(ctx, req.(*CreateAuthorRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CourseService_UpdateAuthor_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateAuthorRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CourseServiceServer).UpdateAuthor(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CourseService_UpdateAuthor_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CourseServiceServer).UpdateAuthor(ctx, req.(*UpdateAuthorRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CourseService_DeleteAuthor_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteAuthorRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CourseServiceServer).DeleteAuthor(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CourseService_DeleteAuthor_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CourseServiceServer).DeleteAuthor(ctx, req.(*DeleteAuthorRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// CourseService_ServiceDesc is the grpc.ServiceDesc for CourseService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var CourseService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "courses.v1.CourseService",
+	HandlerType: (*CourseServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetCourse",
+			Handler:    _CourseService_GetCourse_Handler,
+		},
+		{
+			MethodName: "CreateCourse",
+			Handler:    _CourseService_CreateCourse_Handler,
+		},
+		{
+			MethodName: "UpdateCourse",
+			Handler:    _CourseService_UpdateCourse_Handler,
+		},
+		{
+			MethodName: "DeleteCourse",
+			Handler:    _CourseService_DeleteCourse_Handler,
+		},
+		{
+			MethodName: "GetAuthor",
+			Handler:    _CourseService_GetAuthor_Handler,
+		},
+		{
+			MethodName: "ListAuthors",
+			Handler:    _CourseService_ListAuthors_Handler,
+		},
+		{
+			MethodName: "CreateAuthor",
+			Handler:    _CourseService_CreateAuthor_Handler,
+		},
+		{
+			MethodName: "UpdateAuthor",
+			Handler:    _CourseService_UpdateAuthor_Handler,
+		},
+		{
+			MethodName: "DeleteAuthor",
+			Handler:    _CourseService_DeleteAuthor_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ListCourses",
+			Handler:       _CourseService_ListCourses_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "BulkUpsertCourses",
+			Handler:       _CourseService_BulkUpsertCourses_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "courses/v1/course.proto",
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var ErrPreconditionFailed = errors.New("If-Match does not match the current version")
+
+// etagFor is the strong ETag of a course. The version changes on every
+// write, so it identifies one state of the course.
+func etagFor(c Course) string {
+	return versionETag(c.Version)
+}
+
+// authorETag is the same for an author.
+func authorETag(a Author) string {
+	return versionETag(a.Version)
+}
+
+// expandedETag is the ETag of a course with its author expanded, which
+// changes when either of them does.
+func expandedETag(c Course, a Author) string {
+	return strconv.Quote(strconv.FormatInt(c.Version, 10) + "." + strconv.FormatInt(a.Version, 10))
+}
+
+func versionETag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header lists
+// etag. If-Match needs the strong comparison, so weak tags only count when
+// weak is true.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchVersion checks If-Match against the stored course and returns the
+// version the write must still find, so a change that sneaks in between
+// this check and the write is caught by the store. Without If-Match it
+// returns 0, which tells the store not to check.
+func (s *server) ifMatchVersion(r *http.Request, id string) (int64, error) {
+	return ifMatch(r, func() (int64, error) {
+		current, err := s.store.Get(r.Context(), id)
+		return current.Version, err
+	})
+}
+
+// ifMatchAuthorVersion is ifMatchVersion for authors.
+func (s *server) ifMatchAuthorVersion(r *http.Request, id string) (int64, error) {
+	return ifMatch(r, func() (int64, error) {
+		current, err := s.store.GetAuthor(r.Context(), id)
+		return current.Version, err
+	})
+}
+
+func ifMatch(r *http.Request, currentVersion func() (int64, error)) (int64, error) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return 0, nil
+	}
+
+	version, err := currentVersion()
+	if errors.Is(err, ErrCourseNotFound) || errors.Is(err, ErrAuthorNotFound) {
+		return 0, ErrPreconditionFailed
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !etagMatches(header, versionETag(version), false) {
+		return 0, ErrPreconditionFailed
+	}
+	return version, nil
+}
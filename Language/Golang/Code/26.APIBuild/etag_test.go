@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		header string
+		weak   bool
+		want   bool
+	}{
+		{`"3"`, false, true},
+		{`"2", "3"`, false, true},
+		{`"2"`, false, false},
+		{`*`, false, true},
+		{`W/"3"`, false, false},
+		{`W/"3"`, true, true},
+		{`"3+xml"`, true, false},
+		{`3`, true, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, `"3"`, tt.weak); got != tt.want {
+			t.Errorf("etagMatches(%s, weak %v) = %v, want %v", tt.header, tt.weak, got, tt.want)
+		}
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+
+	res, _ := ts.do(t, "GET", "/course/2", "")
+	etag := res.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("GET /course/2 has no ETag")
+	}
+
+	steps := []struct {
+		name, method, path, body string
+		header                   []string
+		status                   int
+	}{
+		{"not modified", "GET", "/course/2", "", []string{"If-None-Match", etag}, http.StatusNotModified},
+		{"weak not modified", "GET", "/course/2", "", []string{"If-None-Match", "W/" + etag}, http.StatusNotModified},
+		{"other tag", "GET", "/course/2", "", []string{"If-None-Match", `"999"`}, http.StatusOK},
+		{"weak If-Match", "PUT", "/course/2", `{"courseName":"ReactJS","coursePrice":300}`, []string{"If-Match", "W/" + etag}, http.StatusPreconditionFailed},
+		{"stale If-Match", "PUT", "/course/2", `{"courseName":"ReactJS","coursePrice":300}`, []string{"If-Match", `"999"`}, http.StatusPreconditionFailed},
+		{"If-Match", "PUT", "/course/2", `{"courseName":"ReactJS","coursePrice":300}`, []string{"If-Match", etag}, http.StatusOK},
+		{"old tag after the write", "GET", "/course/2", "", []string{"If-None-Match", etag}, http.StatusOK},
+		{"reused If-Match", "PATCH", "/course/2", `{"coursePrice":301}`, []string{"If-Match", etag, "Content-Type", "application/merge-patch+json"}, http.StatusPreconditionFailed},
+		{"If-Match star", "PATCH", "/course/2", `{"coursePrice":301}`, []string{"If-Match", "*", "Content-Type", "application/merge-patch+json"}, http.StatusOK},
+		{"stale delete", "DELETE", "/course/2", "", []string{"If-Match", etag}, http.StatusPreconditionFailed},
+		{"If-Match on a missing course", "PUT", "/course/nope", `{"courseName":"Go","coursePrice":5}`, []string{"If-Match", "*"}, http.StatusPreconditionFailed},
+	}
+	for _, step := range steps {
+		res, body := ts.do(t, step.method, step.path, step.body, step.header...)
+		if res.StatusCode != step.status {
+			t.Fatalf("%s: %s %s: %d, want %d: %s", step.name, step.method, step.path, res.StatusCode, step.status, body)
+		}
+		if res.StatusCode == http.StatusOK && step.method != "GET" && res.Header.Get("ETag") == etag {
+			t.Errorf("%s: the write kept ETag %s", step.name, etag)
+		}
+	}
+}
+
+func TestExpandedETag(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+
+	res, _ := ts.do(t, "GET", "/course/2?expand=author", "")
+	expanded := res.Header.Get("ETag")
+	res, _ = ts.do(t, "GET", "/course/2", "")
+	if expanded == res.Header.Get("ETag") {
+		t.Fatalf("expanded and plain course share ETag %s", expanded)
+	}
+
+	// a change of the author changes the expanded course
+	if res, body := ts.do(t, "PUT", "/authors/1", `{"fullName":"Hitesh C","website":"lco.dev"}`); res.StatusCode != http.StatusOK {
+		t.Fatalf("PUT /authors/1: %d %s", res.StatusCode, body)
+	}
+	if res, _ := ts.do(t, "GET", "/course/2?expand=author", "", "If-None-Match", expanded); res.StatusCode != http.StatusOK {
+		t.Errorf("after the author changed: %d, want 200", res.StatusCode)
+	}
+}
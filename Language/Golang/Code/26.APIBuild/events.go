@@ -0,0 +1,94 @@
+package main
+
+import (
+	"cmp"
+	"crypto/rand"
+	"encoding/hex"
+	"slices"
+	"time"
+)
+
+// maxOutboxEvents is how many events a store keeps. Older ones are dropped
+// and can no longer be replayed.
+const maxOutboxEvents = 10000
+
+const (
+	EventCourseCreated = "course.created"
+	EventCourseUpdated = "course.updated"
+	EventCourseDeleted = "course.deleted"
+)
+
+// Event is a course change as it is sent to webhooks. Seq orders the
+// events of one store; Id stays the same on every delivery of the event,
+// so a receiver can drop the ones it has seen.
+type Event struct {
+	Seq      int64  `json:"seq"`
+	Id       string `json:"id"`
+	Type     string `json:"type"`
+	CourseId string `json:"courseId"`
+	// Course is the course after the change, nil when it was deleted.
+	Course *Course `json:"course,omitempty"`
+	// Version is the course version the change produced, or for a delete
+	// the version that was deleted.
+	Version    int64     `json:"version"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+func newEventId() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return "evt_" + hex.EncodeToString(b[:])
+}
+
+// outbox is the event list a memoryStore keeps. It is not safe for
+// concurrent use, the store's lock covers it.
+type outbox struct {
+	events  []Event
+	lastSeq int64
+}
+
+// next builds the event that follows the last one, without recording it.
+func (o *outbox) next(typ string, course Course) Event {
+	ev := Event{
+		Seq:        o.lastSeq + 1,
+		Id:         newEventId(),
+		Type:       typ,
+		CourseId:   course.CourseId,
+		Version:    course.Version,
+		OccurredAt: time.Now().UTC(),
+	}
+	if typ != EventCourseDeleted {
+		c := course.clone()
+		c.Author = nil
+		ev.Course = &c
+	}
+	return ev
+}
+
+// add records events. Ones it already has are skipped, so replaying a log
+// on top of a snapshot that already holds its events does no harm.
+func (o *outbox) add(events ...Event) {
+	for _, ev := range events {
+		if ev.Seq <= o.lastSeq {
+			continue
+		}
+		o.events = append(o.events, ev)
+		o.lastSeq = ev.Seq
+	}
+	if len(o.events) > maxOutboxEvents {
+		o.events = o.events[len(o.events)-maxOutboxEvents:]
+	}
+}
+
+func (o *outbox) after(seq int64, limit int) []Event {
+	i, _ := slices.BinarySearchFunc(o.events, seq+1, func(ev Event, seq int64) int {
+		return cmp.Compare(ev.Seq, seq)
+	})
+	end := len(o.events)
+	if end-i > limit {
+		end = i + limit
+	}
+	return slices.Clone(o.events[i:end])
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+// graphQLRequest is the body of POST /graphql, as the GraphQL over HTTP
+// spec has it. GET sends the same fields as query parameters.
+type graphQLRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+	Extensions    map[string]any `json:"extensions,omitempty"`
+}
+
+// graphQLHandler serves /graphql. Queries and mutations resolve against
+// the same store as the REST routes and apply the same rules; writes need
+// one of writerRoles, like POST /course does.
+type graphQLHandler struct {
+	s      *server
+	schema graphql.Schema
+}
+
+func newGraphQLHandler(s *server) (*graphQLHandler, error) {
+	schema, err := newGraphQLSchema(s)
+	if err != nil {
+		return nil, err
+	}
+	return &graphQLHandler{s: s, schema: schema}, nil
+}
+
+func (h *graphQLHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req graphQLRequest
+	if r.Method == http.MethodGet {
+		values := r.URL.Query()
+		req.Query = values.Get("query")
+		req.OperationName = values.Get("operationName")
+		if v := values.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				writeProblem(w, r, newProblem(http.StatusBadRequest, "variables must be a JSON object"))
+				return
+			}
+		}
+	} else if err := decodeJSON(w, r, &req); err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	if req.Query == "" {
+		writeProblem(w, r, newProblem(http.StatusBadRequest, "query is required"))
+		return
+	}
+
+	result := h.execute(r, req)
+	if result == nil {
+		// a mutation sent with GET, which a cache or a prefetch could repeat
+		w.Header().Set("Allow", http.MethodPost)
+		writeProblem(w, r, newProblem(http.StatusMethodNotAllowed, "mutations must be sent with POST"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// execute parses, validates, checks the limits and runs one request. A
+// document that fails before execution gets a result with only errors.
+// It returns nil for a mutation that came with GET.
+func (h *graphQLHandler) execute(r *http.Request, req graphQLRequest) *graphql.Result {
+	doc, err := parser.Parse(parser.ParseParams{Source: req.Query})
+	if err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+	}
+	if vr := graphql.ValidateDocument(&h.schema, doc, nil); !vr.IsValid {
+		return &graphql.Result{Errors: vr.Errors}
+	}
+
+	op := findOperation(doc, req.OperationName)
+	if op == nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(errors.New("operationName does not name an operation of the document"))}
+	}
+	if r.Method == http.MethodGet && op.Operation != ast.OperationTypeQuery {
+		return nil
+	}
+	if err := checkQueryLimits(&h.schema, doc, op, req.Variables); err != nil {
+		return &graphql.Result{Errors: []gqlerrors.FormattedError{*err}}
+	}
+
+	ctx := context.WithValue(r.Context(), graphQLLoadersKey{}, newGraphQLLoaders(h.s.store))
+	return graphql.Execute(graphql.ExecuteParams{
+		Schema:        h.schema,
+		AST:           doc,
+		OperationName: req.OperationName,
+		Args:          req.Variables,
+		Context:       ctx,
+	})
+}
+
+// graphQLError carries a Problem into the errors of a GraphQL response.
+// The extensions hold what the REST problem body would: a code made of
+// the problem type, the HTTP status and the invalid fields.
+type graphQLError struct {
+	p *Problem
+}
+
+func resolverError(err error) error {
+	return graphQLError{p: problemFor(err)}
+}
+
+func (e graphQLError) Error() string {
+	if e.p.Detail != "" {
+		return e.p.Detail
+	}
+	return e.p.Title
+}
+
+func (e graphQLError) Extensions() map[string]any {
+	ext := map[string]any{
+		"code":   problemReason(e.p),
+		"status": e.p.Status,
+		"type":   e.p.Type,
+	}
+	if len(e.p.Errors) > 0 {
+		ext["errors"] = e.p.Errors
+	}
+	return ext
+}
+
+func newGraphQLSchema(s *server) (graphql.Schema, error) {
+	var courseType, authorType *graphql.Object
+
+	authorType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Author",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"authorId": &graphql.Field{Type: graphql.NewNonNull(graphql.ID), Resolve: authorField(func(a Author) any { return a.AuthorId })},
+				"fullName": &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: authorField(func(a Author) any { return a.Fullname })},
+				"website":  &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: authorField(func(a Author) any { return a.Website })},
+				"version":  &graphql.Field{Type: graphql.NewNonNull(graphql.Int), Resolve: authorField(func(a Author) any { return a.Version })},
+				"courses": &graphql.Field{
+					Description: "Every course of the author, in creation order.",
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(courseType))),
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						author := p.Source.(Author)
+						thunk := loadersFromContext(p.Context).courses.load(p.Context, author.AuthorId)
+						return func() (any, error) {
+							courses, _, err := thunk()
+							if err != nil {
+								return nil, resolverError(err)
+							}
+							return courses, nil
+						}, nil
+					},
+				},
+			}
+		}),
+	})
+
+	courseType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Course",
+		Fields: graphql.Fields{
+			"courseId":    &graphql.Field{Type: graphql.NewNonNull(graphql.ID), Resolve: courseField(func(c Course) any { return c.CourseId })},
+			"courseName":  &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: courseField(func(c Course) any { return c.CourseName })},
+			"coursePrice": &graphql.Field{Type: graphql.NewNonNull(graphql.Int), Resolve: courseField(func(c Course) any { return c.CoursePrice })},
+			"courseSite":  &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: courseField(func(c Course) any { return c.CourseSite })},
+			"version":     &graphql.Field{Type: graphql.NewNonNull(graphql.Int), Resolve: courseField(func(c Course) any { return c.Version })},
+			"authorId": &graphql.Field{Type: graphql.ID, Resolve: courseField(func(c Course) any {
+				if c.AuthorId == "" {
+					return nil
+				}
+				return c.AuthorId
+			})},
+			"author": &graphql.Field{
+				Type: authorType,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					course := p.Source.(Course)
+					if course.AuthorId == "" {
+						return nil, nil
+					}
+					if course.Author != nil {
+						// joined in already by the courses query
+						return *course.Author, nil
+					}
+					thunk := loadersFromContext(p.Context).authors.load(p.Context, course.AuthorId)
+					return func() (any, error) {
+						author, ok, err := thunk()
+						if err != nil {
+							return nil, resolverError(err)
+						}
+						if !ok {
+							return nil, nil
+						}
+						return author, nil
+					}, nil
+				},
+			},
+		},
+	})
+
+	coursePageType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "CoursePage",
+		Fields: graphql.Fields{
+			"courses": &graphql.Field{
+				Type:    graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(courseType))),
+				Resolve: func(p graphql.ResolveParams) (any, error) { return p.Source.(coursePage).Courses, nil },
+			},
+			"nextCursor": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					if next := p.Source.(coursePage).NextCursor; next != "" {
+						return next, nil
+					}
+					return nil, nil
+				},
+			},
+			"total": &graphql.Field{
+				Type:    graphql.NewNonNull(graphql.Int),
+				Resolve: func(p graphql.ResolveParams) (any, error) { return p.Source.(coursePage).Total, nil },
+			},
+		},
+	})
+
+	courseFilter := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "CourseFilter",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"minPrice":   &graphql.InputObjectFieldConfig{Type: graphql.Int},
+			"maxPrice":   &graphql.InputObjectFieldConfig{Type: graphql.Int},
+			"authorId":   &graphql.InputObjectFieldConfig{Type: graphql.ID},
+			"authorName": &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"courseSite": &graphql.InputObjectFieldConfig{Type: graphql.String},
+		},
+	})
+
+	courseInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "CourseInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"courseName":  &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"coursePrice": &graphql.InputObjectFieldConfig{Type: graphql.Int, DefaultValue: 0},
+			"courseSite":  &graphql.InputObjectFieldConfig{Type: graphql.String, DefaultValue: ""},
+			"authorId":    &graphql.InputObjectFieldConfig{Type: graphql.ID},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"course": &graphql.Field{
+				Type: courseType,
+				Args: graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					course, err := s.store.Get(p.Context, p.Args["id"].(string))
+					if errors.Is(err, ErrCourseNotFound) {
+						return nil, nil
+					}
+					if err != nil {
+						return nil, resolverError(err)
+					}
+					return course, nil
+				},
+			},
+			"courses": &graphql.Field{
+				Description: "A page of courses, with the filters, sort fields and cursors of GET /courses.",
+				Type:        graphql.NewNonNull(coursePageType),
+				Args: graphql.FieldConfigArgument{
+					"filter": &graphql.ArgumentConfig{Type: courseFilter},
+					"sort":   &graphql.ArgumentConfig{Type: graphql.String, Description: "comma separated fields, - in front for descending"},
+					"limit":  &graphql.ArgumentConfig{Type: graphql.Int},
+					"after":  &graphql.ArgumentConfig{Type: graphql.String, Description: "nextCursor of the previous page"},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					q, err := parseCourseQuery(courseQueryValues(p.Args))
+					if err != nil {
+						return nil, resolverError(newProblem(http.StatusBadRequest, err.Error()))
+					}
+					courses, err := s.store.List(p.Context)
+					if err != nil {
+						return nil, resolverError(err)
+					}
+					// joined for the author filter and sort, and kept so
+					// the author field does not have to load them again
+					if err := s.joinAuthors(p.Context, courses); err != nil {
+						return nil, resolverError(err)
+					}
+					return q.apply(courses), nil
+				},
+			},
+			"author": &graphql.Field{
+				Type: authorType,
+				Args: graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					author, err := s.store.GetAuthor(p.Context, p.Args["id"].(string))
+					if errors.Is(err, ErrAuthorNotFound) {
+						return nil, nil
+					}
+					if err != nil {
+						return nil, resolverError(err)
+					}
+					return author, nil
+				},
+			},
+			"authors": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(authorType))),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					authors, err := s.store.ListAuthors(p.Context)
+					if err != nil {
+						return nil, resolverError(err)
+					}
+					return authors, nil
+				},
+			},
+		},
+	})
+
+	versionArg := &graphql.ArgumentConfig{Type: graphql.Int, Description: "fail unless the course still has this version, like If-Match"}
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createCourse": &graphql.Field{
+				Type: graphql.NewNonNull(courseType),
+				Args: graphql.FieldConfigArgument{"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(courseInput)}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					if err := requireWriter(p.Context); err != nil {
+						return nil, resolverError(err)
+					}
+					course := courseFromInput(p.Args["input"])
+					if err := validateStruct(course); err != nil {
+						return nil, resolverError(err)
+					}
+					course.CourseId = s.ids.NewId()
+					course, err := s.store.Create(p.Context, course)
+					if err != nil {
+						return nil, resolverError(err)
+					}
+					return course, nil
+				},
+			},
+			"updateCourse": &graphql.Field{
+				Type: graphql.NewNonNull(courseType),
+				Args: graphql.FieldConfigArgument{
+					"id":              &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"input":           &graphql.ArgumentConfig{Type: graphql.NewNonNull(courseInput)},
+					"expectedVersion": versionArg,
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					if err := requireWriter(p.Context); err != nil {
+						return nil, resolverError(err)
+					}
+					course := courseFromInput(p.Args["input"])
+					course.CourseId = p.Args["id"].(string)
+					if err := validateStruct(course); err != nil {
+						return nil, resolverError(err)
+					}
+					course.Version = int64(intArg(p.Args, "expectedVersion"))
+					course, err := s.store.Update(p.Context, course)
+					if err != nil {
+						return nil, resolverError(err)
+					}
+					return course, nil
+				},
+			},
+			"deleteCourse": &graphql.Field{
+				Description: "Deletes a course and returns its id.",
+				Type:        graphql.NewNonNull(graphql.ID),
+				Args: graphql.FieldConfigArgument{
+					"id":              &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"expectedVersion": versionArg,
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					if err := requireWriter(p.Context); err != nil {
+						return nil, resolverError(err)
+					}
+					id := p.Args["id"].(string)
+					if err := s.store.Delete(p.Context, id, int64(intArg(p.Args, "expectedVersion"))); err != nil {
+						return nil, resolverError(err)
+					}
+					return id, nil
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+}
+
+func courseField(get func(Course) any) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) { return get(p.Source.(Course)), nil }
+}
+
+func authorField(get func(Author) any) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) { return get(p.Source.(Author)), nil }
+}
+
+// requireWriter is requireRole(writerRoles) for a resolver.
+func requireWriter(ctx context.Context) error {
+	claims, ok := claimsFromContext(ctx)
+	if !ok {
+		return ErrUnauthenticated
+	}
+	if !claims.HasRole(writerRoles...) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// courseQueryValues turns the arguments of Query.courses into the query
+// string GET /courses would get, so both go through parseCourseQuery.
+func courseQueryValues(args map[string]any) url.Values {
+	values := url.Values{}
+	if limit, ok := args["limit"].(int); ok {
+		values.Set("limit", strconv.Itoa(limit))
+	}
+	if sort, ok := args["sort"].(string); ok {
+		values.Set("sort", sort)
+	}
+	if after, ok := args["after"].(string); ok {
+		values.Set("cursor", after)
+	}
+	// keep the joined authors in the page for the author field
+	values.Set("expand", "author")
+
+	filter, _ := args["filter"].(map[string]any)
+	params := map[string]string{
+		"minPrice":   "coursePrice.gte",
+		"maxPrice":   "coursePrice.lte",
+		"authorId":   "authorId",
+		"authorName": "author.fullName",
+		"courseSite": "courseSite",
+	}
+	for field, param := range params {
+		switch v := filter[field].(type) {
+		case int:
+			values.Set(param, strconv.Itoa(v))
+		case string:
+			values.Set(param, v)
+		}
+	}
+	return values
+}
+
+func courseFromInput(input any) Course {
+	fields, _ := input.(map[string]any)
+	course := Course{CoursePrice: intArg(fields, "coursePrice")}
+	course.CourseName, _ = fields["courseName"].(string)
+	course.CourseSite, _ = fields["courseSite"].(string)
+	course.AuthorId, _ = fields["authorId"].(string)
+	return course
+}
+
+func intArg(args map[string]any, name string) int {
+	n, _ := args[name].(int)
+	return n
+}
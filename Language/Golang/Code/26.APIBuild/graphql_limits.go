@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+const (
+	// maxQueryDepth allows a course, its author, the author's courses and
+	// one more level, e.g. their authors again.
+	maxQueryDepth = 6
+	// maxQueryComplexity is roughly the number of field values a query
+	// may produce, see queryCost.
+	maxQueryComplexity = 1000
+)
+
+// findOperation picks the operation to run: the one named, or the only
+// one when no name is given.
+func findOperation(doc *ast.Document, name string) *ast.OperationDefinition {
+	var found *ast.OperationDefinition
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if name == "" {
+			if found != nil {
+				return nil
+			}
+			found = op
+		} else if op.Name != nil && op.Name.Value == name {
+			return op
+		}
+	}
+	return found
+}
+
+// checkQueryLimits refuses an operation that nests deeper than
+// maxQueryDepth or costs more than maxQueryComplexity before anything is
+// resolved. Introspection fields are not counted, so tools can still
+// read the schema.
+func checkQueryLimits(schema *graphql.Schema, doc *ast.Document, op *ast.OperationDefinition, variables map[string]any) *gqlerrors.FormattedError {
+	c := queryCost{
+		schema:    schema,
+		fragments: map[string]*ast.FragmentDefinition{},
+		variables: variables,
+		visiting:  map[string]bool{},
+		spreads:   map[fragmentUse]fragmentCost{},
+	}
+	for _, def := range doc.Definitions {
+		if frag, ok := def.(*ast.FragmentDefinition); ok {
+			c.fragments[frag.Name.Value] = frag
+		}
+	}
+
+	root := schema.QueryType()
+	if op.Operation == ast.OperationTypeMutation {
+		root = schema.MutationType()
+	}
+	cost, depth := c.selections(op.SelectionSet, root, 1, defaultPageLimit)
+	if depth > maxQueryDepth {
+		return limitError("QUERY_TOO_DEEP", fmt.Sprintf("query is %d levels deep, at most %d are allowed", depth, maxQueryDepth), depth, maxQueryDepth)
+	}
+	if cost > maxQueryComplexity {
+		return limitError("QUERY_TOO_COMPLEX", fmt.Sprintf("query costs %d, at most %d is allowed; ask for fewer items or fields", cost, maxQueryComplexity), cost, maxQueryComplexity)
+	}
+	return nil
+}
+
+func limitError(code, message string, got, limit int) *gqlerrors.FormattedError {
+	err := gqlerrors.NewFormattedError(message)
+	err.Extensions = map[string]any{"code": code, "value": got, "limit": limit}
+	return &err
+}
+
+// queryCost charges every field 1. A list multiplies what is below it by
+// the limit argument of the field that returns it, e.g. courses(limit:),
+// or by a page of defaultPageLimit when there is none. Costs stop
+// growing at maxCountedCost, so huge limits can not overflow.
+//
+// A fragment is costed once per page size and then looked up, so
+// fragments that spread each other twice over do not take exponential
+// time to check.
+type queryCost struct {
+	schema    *graphql.Schema
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]any
+	visiting  map[string]bool
+	spreads   map[fragmentUse]fragmentCost
+}
+
+type fragmentUse struct {
+	name     string
+	pageSize int
+}
+
+// fragmentCost is what a fragment costs and how many levels it goes
+// below the level it is spread on.
+type fragmentCost struct {
+	cost, depth int
+}
+
+// maxCountedCost is far above any limit, and two of them multiplied still
+// fit in an int.
+const maxCountedCost = 1 << 31
+
+func capCost(cost int) int {
+	return min(cost, maxCountedCost)
+}
+
+// selections returns the cost of a selection set on parent at depth and
+// the deepest level it reaches. pageSize is what a list in it counts as.
+func (c *queryCost) selections(set *ast.SelectionSet, parent *graphql.Object, depth, pageSize int) (cost, maxDepth int) {
+	if set == nil || parent == nil {
+		return 0, depth - 1
+	}
+	maxDepth = depth
+	for _, sel := range set.Selections {
+		var selCost, selDepth int
+		switch sel := sel.(type) {
+		case *ast.Field:
+			def, ok := parent.Fields()[sel.Name.Value]
+			if !ok || strings.HasPrefix(sel.Name.Value, "__") {
+				continue
+			}
+			childPage, hasLimit := c.limitArg(sel)
+			if !hasLimit {
+				childPage = defaultPageLimit
+			}
+			childPage = capCost(childPage)
+			size := 1
+			t := unwrapNonNull(def.Type)
+			if list, ok := t.(*graphql.List); ok {
+				size = pageSize
+				if hasLimit {
+					size = childPage
+				}
+				t = unwrapNonNull(list.OfType)
+			}
+			child, _ := t.(*graphql.Object)
+			childCost, childDepth := c.selections(sel.SelectionSet, child, depth+1, childPage)
+			selCost, selDepth = capCost(1+size*childCost), childDepth
+			if selDepth < depth {
+				selDepth = depth
+			}
+		case *ast.InlineFragment:
+			on := parent
+			if sel.TypeCondition != nil {
+				on, _ = c.schema.Type(sel.TypeCondition.Name.Value).(*graphql.Object)
+			}
+			selCost, selDepth = c.selections(sel.SelectionSet, on, depth, pageSize)
+		case *ast.FragmentSpread:
+			frag := c.fragments[sel.Name.Value]
+			if frag == nil || c.visiting[frag.Name.Value] {
+				// validation has reported it already
+				continue
+			}
+			use := fragmentUse{frag.Name.Value, pageSize}
+			known, ok := c.spreads[use]
+			if !ok {
+				on, _ := c.schema.Type(frag.TypeCondition.Name.Value).(*graphql.Object)
+				c.visiting[frag.Name.Value] = true
+				known.cost, known.depth = c.selections(frag.SelectionSet, on, depth, pageSize)
+				known.depth -= depth
+				c.visiting[frag.Name.Value] = false
+				c.spreads[use] = known
+			}
+			selCost, selDepth = known.cost, depth+known.depth
+		}
+		cost = capCost(cost + selCost)
+		if selDepth > maxDepth {
+			maxDepth = selDepth
+		}
+	}
+	return cost, maxDepth
+}
+
+// limitArg reads the limit argument of a field, given inline or as a
+// variable.
+func (c *queryCost) limitArg(field *ast.Field) (int, bool) {
+	for _, arg := range field.Arguments {
+		if arg.Name.Value != "limit" {
+			continue
+		}
+		switch v := arg.Value.(type) {
+		case *ast.IntValue:
+			if n, err := strconv.Atoi(v.Value); err == nil && n > 0 {
+				return n, true
+			}
+		case *ast.Variable:
+			if n, ok := c.variables[v.Name.Value].(float64); ok && n > 0 {
+				return int(n), true
+			}
+		}
+	}
+	return 0, false
+}
+
+func unwrapNonNull(t graphql.Type) graphql.Type {
+	if nn, ok := t.(*graphql.NonNull); ok {
+		return nn.OfType
+	}
+	return t
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+// limitsOf validates query and returns what checkQueryLimits says about
+// it.
+func limitsOf(t *testing.T, query string, variables map[string]any) *gqlerrors.FormattedError {
+	t.Helper()
+	h, err := newGraphQLHandler(&server{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vr := graphql.ValidateDocument(&h.schema, doc, nil); !vr.IsValid {
+		t.Fatalf("invalid query: %v", vr.Errors)
+	}
+	op := findOperation(doc, "")
+	if op == nil {
+		t.Fatal("no operation")
+	}
+	return checkQueryLimits(&h.schema, doc, op, variables)
+}
+
+func TestQueryLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]any
+		code      string
+		value     int
+	}{
+		{"small", `{ courses(limit: 5) { courses { courseName } } }`, nil, "", 0},
+		{"six levels", `{ course(id: "2") { author { courses { author { courses { courseName } } } } } }`, nil, "", 0},
+		{"seven levels", `{ course(id: "2") { author { courses { author { courses { author { fullName } } } } } } }`, nil, "QUERY_TOO_DEEP", 7},
+		{"seven levels through a fragment", `{ course(id: "2") { author { ...deep } } } fragment deep on Author { courses { author { courses { author { fullName } } } } }`, nil, "QUERY_TOO_DEEP", 7},
+		// courses, then 50 courses with an author with 20 courses:
+		// 1 + 1*(1 + 50*(1 + 1*(1 + 20*1))) = 1102
+		{"limit", `{ courses(limit: 50) { courses { author { courses { courseName } } } } }`, nil, "QUERY_TOO_COMPLEX", 1102},
+		{"limit from a variable", `query($n: Int) { courses(limit: $n) { courses { author { courses { courseName } } } } }`, map[string]any{"n": float64(50)}, "QUERY_TOO_COMPLEX", 1102},
+		{"limit through a fragment", `{ courses(limit: 50) { courses { ...withAuthor } } } fragment withAuthor on Course { author { courses { courseName } } }`, nil, "QUERY_TOO_COMPLEX", 1102},
+		{"default page", `{ authors { courses { author { courses { courseName } } } } }`, nil, "QUERY_TOO_COMPLEX", 1 + 20*(1+20*(1+1*(1+20*1)))},
+		{"huge limit", `{ courses(limit: 2147483647) { courses { author { courses { courseName } } } } }`, nil, "QUERY_TOO_COMPLEX", maxCountedCost},
+		{"introspection", `{ __schema { types { name fields { name type { name ofType { name ofType { name } } } } } } }`, nil, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := limitsOf(t, tt.query, tt.variables)
+			if tt.code == "" {
+				if err != nil {
+					t.Fatalf("refused: %s", err.Message)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("allowed, want %s", tt.code)
+			}
+			if err.Extensions["code"] != tt.code || err.Extensions["value"] != tt.value {
+				t.Errorf("extensions = %v, want code %s and value %d", err.Extensions, tt.code, tt.value)
+			}
+		})
+	}
+}
+
+func TestQueryLimitsFragmentFanOut(t *testing.T) {
+	// every fragment spreads the next one twice, so expanding them all
+	// would visit 2^40 selections
+	const n = 40
+	var b strings.Builder
+	b.WriteString(`{ author(id: "1") { ...F0 } }`)
+	for i := range n {
+		fmt.Fprintf(&b, " fragment F%d on Author { ...F%d ...F%d }", i, i+1, i+1)
+	}
+	fmt.Fprintf(&b, " fragment F%d on Author { fullName }", n)
+
+	start := time.Now()
+	err := limitsOf(t, b.String(), nil)
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("took %v", took)
+	}
+	if err == nil || err.Extensions["code"] != "QUERY_TOO_COMPLEX" {
+		t.Fatalf("got %v, want QUERY_TOO_COMPLEX", err)
+	}
+}
+
+func TestQueryLimitsOverHTTP(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+	body, _ := json.Marshal(graphQLRequest{Query: `{ authors { courses { author { courses { courseName } } } } }`})
+	res, raw := ts.do(t, http.MethodPost, "/graphql", string(body))
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d: %s", res.StatusCode, raw)
+	}
+	var result struct {
+		Data   any
+		Errors []struct {
+			Message    string
+			Extensions map[string]any
+		}
+	}
+	if err := json.Unmarshal([]byte(raw), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Data != nil || len(result.Errors) != 1 || result.Errors[0].Extensions["code"] != "QUERY_TOO_COMPLEX" {
+		t.Errorf("body = %s", raw)
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"example.com/hello/Code/26.APIBuild/coursepb"
+)
+
+// courseService is the gRPC CourseService of proto/courses/v1. It goes
+// through the same store and applies the same rules as the REST handlers;
+// like them it returns plain errors and grpcErrors turns them into a
+// status.
+type courseService struct {
+	coursepb.UnimplementedCourseServiceServer
+	s *server
+}
+
+// newGRPCServer builds the gRPC server with the interceptor chain every
+// call goes through: request id, the server span and the log line, the
+// error mapping, panic recovery and then authentication. It serves TLS
+// with the same certificate as the HTTP server.
+func newGRPCServer(s *server, cfg serverConfig) (*grpc.Server, error) {
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(s.observeUnary, grpcErrorsUnary, s.recoverUnary, s.authUnary),
+		grpc.ChainStreamInterceptor(s.observeStream, grpcErrorsStream, s.recoverStream, s.authStream),
+	}
+	if cfg.TLSCertFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+
+	srv := grpc.NewServer(opts...)
+	coursepb.RegisterCourseServiceServer(srv, &courseService{s: s})
+	// lets grpcurl and friends list the methods without the .proto
+	reflection.Register(srv)
+	return srv, nil
+}
+
+func (cs *courseService) GetCourse(ctx context.Context, req *coursepb.GetCourseRequest) (*coursepb.Course, error) {
+	course, err := cs.s.store.Get(ctx, req.CourseId)
+	if err != nil {
+		return nil, err
+	}
+	if req.ExpandAuthor && course.AuthorId != "" {
+		author, err := cs.s.store.GetAuthor(ctx, course.AuthorId)
+		if err != nil {
+			return nil, err
+		}
+		course.Author = &author
+	}
+	return courseToProto(course), nil
+}
+
+func (cs *courseService) ListCourses(req *coursepb.ListCoursesRequest, stream coursepb.CourseService_ListCoursesServer) error {
+	ctx := stream.Context()
+	if req.AuthorId != "" {
+		if _, err := cs.s.store.GetAuthor(ctx, req.AuthorId); err != nil {
+			return err
+		}
+	}
+
+	courses, err := cs.s.store.List(ctx)
+	if err != nil {
+		return err
+	}
+	if req.ExpandAuthor {
+		if err := cs.s.joinAuthors(ctx, courses); err != nil {
+			return err
+		}
+	}
+	for _, course := range courses {
+		if req.AuthorId != "" && course.AuthorId != req.AuthorId {
+			continue
+		}
+		if err := stream.Send(courseToProto(course)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cs *courseService) CreateCourse(ctx context.Context, req *coursepb.CreateCourseRequest) (*coursepb.Course, error) {
+	if req.Course == nil {
+		return nil, ValidationErrors{{Field: "course", Message: "is required"}}
+	}
+	course := courseFromProto(req.Course)
+	if course.CourseId != "" {
+		return nil, ValidationErrors{{Field: "courseId", Message: "is assigned by the server"}}
+	}
+	if course.Author != nil {
+		return nil, errEmbeddedAuthor
+	}
+	if err := validateStruct(course); err != nil {
+		return nil, err
+	}
+
+	course.CourseId = cs.s.ids.NewId()
+	course, err := cs.s.store.Create(ctx, course)
+	if err != nil {
+		return nil, err
+	}
+	return courseToProto(course), nil
+}
+
+func (cs *courseService) UpdateCourse(ctx context.Context, req *coursepb.UpdateCourseRequest) (*coursepb.Course, error) {
+	if req.Course == nil {
+		return nil, ValidationErrors{{Field: "course", Message: "is required"}}
+	}
+	course := courseFromProto(req.Course)
+	if course.CourseId == "" {
+		return nil, ValidationErrors{{Field: "courseId", Message: "is required"}}
+	}
+	if course.Author != nil {
+		return nil, errEmbeddedAuthor
+	}
+	if err := validateStruct(course); err != nil {
+		return nil, err
+	}
+
+	course.Version = req.ExpectedVersion
+	course, err := cs.s.store.Update(ctx, course)
+	if err != nil {
+		return nil, err
+	}
+	return courseToProto(course), nil
+}
+
+func (cs *courseService) DeleteCourse(ctx context.Context, req *coursepb.DeleteCourseRequest) (*emptypb.Empty, error) {
+	if err := cs.s.store.Delete(ctx, req.CourseId, req.ExpectedVersion); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// BulkUpsertCourses writes each course as it arrives and answers it right
+// away, so a client can keep a window of requests in flight. A course
+// that fails gets its error in the result; only a broken stream or a
+// cancelled call ends the RPC.
+func (cs *courseService) BulkUpsertCourses(stream coursepb.CourseService_BulkUpsertCoursesServer) error {
+	ctx := stream.Context()
+	for i := 0; ; i++ {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		result := &coursepb.UpsertCourseResult{Index: int32(i)}
+		course, created, err := cs.upsert(ctx, req.Course)
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case err != nil:
+			result.Error = grpcStatus(ctx, err).Proto()
+		case created:
+			result.Action, result.Course = coursepb.UpsertCourseResult_ACTION_CREATED, courseToProto(course)
+		default:
+			result.Action, result.Course = coursepb.UpsertCourseResult_ACTION_UPDATED, courseToProto(course)
+		}
+		if err := stream.Send(result); err != nil {
+			return err
+		}
+	}
+}
+
+// upsert replaces the course with the same id, which has to exist. A
+// course without an id is created under a new one; clients never pick ids.
+func (cs *courseService) upsert(ctx context.Context, pb *coursepb.Course) (Course, bool, error) {
+	if pb == nil {
+		return Course{}, false, ValidationErrors{{Field: "course", Message: "is required"}}
+	}
+	course := courseFromProto(pb)
+	var errs ValidationErrors
+	if course.Author != nil {
+		errs = append(errs, errEmbeddedAuthor...)
+	}
+	if err := validateStruct(course); err != nil {
+		var verrs ValidationErrors
+		if !errors.As(err, &verrs) {
+			return Course{}, false, err
+		}
+		errs = append(errs, verrs...)
+	}
+	if len(errs) > 0 {
+		return Course{}, false, errs
+	}
+
+	if course.CourseId == "" {
+		course.CourseId = cs.s.ids.NewId()
+		course, err := cs.s.store.Create(ctx, course)
+		return course, true, err
+	}
+	course.Version = 0
+	course, err := cs.s.store.Update(ctx, course)
+	return course, false, err
+}
+
+func (cs *courseService) GetAuthor(ctx context.Context, req *coursepb.GetAuthorRequest) (*coursepb.Author, error) {
+	author, err := cs.s.store.GetAuthor(ctx, req.AuthorId)
+	if err != nil {
+		return nil, err
+	}
+	return authorToProto(author), nil
+}
+
+func (cs *courseService) ListAuthors(ctx context.Context, req *coursepb.ListAuthorsRequest) (*coursepb.ListAuthorsResponse, error) {
+	authors, err := cs.s.store.ListAuthors(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp := &coursepb.ListAuthorsResponse{Authors: make([]*coursepb.Author, len(authors))}
+	for i, author := range authors {
+		resp.Authors[i] = authorToProto(author)
+	}
+	return resp, nil
+}
+
+func (cs *courseService) CreateAuthor(ctx context.Context, req *coursepb.CreateAuthorRequest) (*coursepb.Author, error) {
+	if req.Author == nil {
+		return nil, ValidationErrors{{Field: "author", Message: "is required"}}
+	}
+	author := authorFromProto(req.Author)
+	if author.AuthorId != "" {
+		return nil, ValidationErrors{{Field: "authorId", Message: "is assigned by the server"}}
+	}
+	if err := validateStruct(author); err != nil {
+		return nil, err
+	}
+
+	author.AuthorId = cs.s.ids.NewId()
+	author, err := cs.s.store.CreateAuthor(ctx, author)
+	if err != nil {
+		return nil, err
+	}
+	return authorToProto(author), nil
+}
+
+func (cs *courseService) UpdateAuthor(ctx context.Context, req *coursepb.UpdateAuthorRequest) (*coursepb.Author, error) {
+	if req.Author == nil {
+		return nil, ValidationErrors{{Field: "author", Message: "is required"}}
+	}
+	author := authorFromProto(req.Author)
+	if author.AuthorId == "" {
+		return nil, ValidationErrors{{Field: "authorId", Message: "is required"}}
+	}
+	if err := validateStruct(author); err != nil {
+		return nil, err
+	}
+
+	author.Version = req.ExpectedVersion
+	author, err := cs.s.store.UpdateAuthor(ctx, author)
+	if err != nil {
+		return nil, err
+	}
+	return authorToProto(author), nil
+}
+
+func (cs *courseService) DeleteAuthor(ctx context.Context, req *coursepb.DeleteAuthorRequest) (*coursepb.DeleteAuthorResponse, error) {
+	deleted, err := cs.s.store.DeleteAuthor(ctx, req.AuthorId, req.ExpectedVersion, req.Cascade)
+	if err != nil {
+		return nil, err
+	}
+	if len(deleted) > 0 {
+		cs.s.logger.InfoContext(ctx, "cascade deleted courses",
+			"author_id", req.AuthorId, "course_ids", deleted, "request_id", requestIdFromContext(ctx))
+	}
+	return &coursepb.DeleteAuthorResponse{DeletedCourseIds: deleted}, nil
+}
+
+func courseToProto(c Course) *coursepb.Course {
+	pb := &coursepb.Course{
+		CourseId:    c.CourseId,
+		CoursePrice: int32(c.CoursePrice),
+		CourseName:  c.CourseName,
+		CourseSite:  c.CourseSite,
+		AuthorId:    c.AuthorId,
+		Version:     c.Version,
+	}
+	if c.Author != nil {
+		pb.Author = authorToProto(*c.Author)
+	}
+	return pb
+}
+
+// courseFromProto ignores the version, requests carry the one they expect
+// in a field of their own.
+func courseFromProto(pb *coursepb.Course) Course {
+	c := Course{
+		CourseId:    pb.CourseId,
+		CoursePrice: int(pb.CoursePrice),
+		CourseName:  pb.CourseName,
+		CourseSite:  pb.CourseSite,
+		AuthorId:    pb.AuthorId,
+	}
+	if pb.Author != nil {
+		author := authorFromProto(pb.Author)
+		c.Author = &author
+	}
+	return c
+}
+
+func authorToProto(a Author) *coursepb.Author {
+	return &coursepb.Author{
+		AuthorId: a.AuthorId,
+		FullName: a.Fullname,
+		Website:  a.Website,
+		Version:  a.Version,
+	}
+}
+
+func authorFromProto(pb *coursepb.Author) Author {
+	return Author{
+		AuthorId: pb.AuthorId,
+		Fullname: pb.FullName,
+		Website:  pb.Website,
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+
+	"example.com/hello/Code/26.APIBuild/coursepb"
+)
+
+// grpcErrorDomain is the ErrorInfo domain of every error we send.
+const grpcErrorDomain = "courses.v1"
+
+// grpcWriteMethods need one of writerRoles, like the REST writes. Every
+// other method is public.
+var grpcWriteMethods = map[string]bool{
+	coursepb.CourseService_CreateCourse_FullMethodName:      true,
+	coursepb.CourseService_UpdateCourse_FullMethodName:      true,
+	coursepb.CourseService_DeleteCourse_FullMethodName:      true,
+	coursepb.CourseService_BulkUpsertCourses_FullMethodName: true,
+	coursepb.CourseService_CreateAuthor_FullMethodName:      true,
+	coursepb.CourseService_UpdateAuthor_FullMethodName:      true,
+	coursepb.CourseService_DeleteAuthor_FullMethodName:      true,
+}
+
+// serverStream swaps the context of a stream, which grpc.ServerStream
+// has no setter for.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (ss *serverStream) Context() context.Context {
+	return ss.ctx
+}
+
+// observeUnary and observeStream do for a call what requestId,
+// traceRequests and accessLog do for an HTTP request: take or make the
+// x-request-id, start the server span and write the log line.
+func (s *server) observeUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, done := s.observe(ctx, info.FullMethod)
+	resp, err := handler(ctx, req)
+	done(err)
+	return resp, err
+}
+
+func (s *server) observeStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, done := s.observe(ss.Context(), info.FullMethod)
+	err := handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	done(err)
+	return err
+}
+
+func (s *server) observe(ctx context.Context, method string) (context.Context, func(error)) {
+	start := time.Now()
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	id := firstMetadata(md, strings.ToLower(requestIdHeader))
+	if !validRequestId(id) {
+		id = s.ids.NewId()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(strings.ToLower(requestIdHeader), id))
+	ctx = context.WithValue(ctx, requestInfoKey{}, &requestInfo{id: id, route: method})
+
+	if remote, ok := parseTraceparent(firstMetadata(md, traceparentHeader)); ok {
+		remote.TraceState = firstMetadata(md, tracestateHeader)
+		ctx = contextWithRemoteSpanContext(ctx, remote)
+	}
+	service, rpc, _ := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	ctx, span := s.tracer.Start(ctx, service+"/"+rpc, SpanKindServer,
+		Attribute{"rpc.system", "grpc"},
+		Attribute{"rpc.service", service},
+		Attribute{"rpc.method", rpc},
+		Attribute{"request.id", id},
+	)
+
+	return ctx, func(err error) {
+		code := status.Code(err)
+		span.SetAttributes(Attribute{"rpc.grpc.status_code", int64(code)})
+		switch code {
+		case codes.Unknown, codes.DeadlineExceeded, codes.Unimplemented, codes.Internal, codes.Unavailable, codes.DataLoss:
+			// the rest are the client's fault, not a failed span
+			span.SetStatus(StatusError, code.String())
+		}
+		span.End()
+
+		remote := ""
+		if p, ok := peer.FromContext(ctx); ok {
+			remote = p.Addr.String()
+		}
+		took := time.Since(start)
+		s.logger.LogAttrs(ctx, slog.LevelInfo, "rpc",
+			slog.String("request_id", id),
+			slog.String("trace_id", span.SpanContext().TraceId.String()),
+			slog.String("span_id", span.SpanContext().SpanId.String()),
+			slog.String("method", method),
+			slog.String("code", code.String()),
+			slog.Float64("latency_ms", float64(took.Microseconds())/1000),
+			slog.String("remote", remote),
+		)
+	}
+}
+
+// grpcErrorsUnary and grpcErrorsStream turn the plain errors of the
+// handlers into a status, the way writeProblem does for REST.
+func grpcErrorsUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	resp, err := handler(ctx, req)
+	if err != nil {
+		return nil, grpcStatus(ctx, err).Err()
+	}
+	return resp, nil
+}
+
+func grpcErrorsStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := handler(srv, ss); err != nil {
+		return grpcStatus(ss.Context(), err).Err()
+	}
+	return nil
+}
+
+// recoverUnary and recoverStream turn a panic into an internal error
+// instead of a dead server.
+func (s *server) recoverUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer s.recoverCall(ctx, &err)
+	return handler(ctx, req)
+}
+
+func (s *server) recoverStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer s.recoverCall(ss.Context(), &err)
+	return handler(srv, ss)
+}
+
+func (s *server) recoverCall(ctx context.Context, err *error) {
+	if rec := recover(); rec != nil {
+		s.logger.LogAttrs(ctx, slog.LevelError, "panic",
+			slog.String("request_id", requestIdFromContext(ctx)),
+			slog.String("error", fmt.Sprint(rec)),
+			slog.String("stack", string(debug.Stack())),
+		)
+		*err = newProblem(http.StatusInternalServerError, "the server hit an unexpected error")
+	}
+}
+
+// authUnary and authStream are authenticate and requireRole in one: a
+// bearer token in the authorization metadata must be valid, and the
+// methods in grpcWriteMethods need a writer role. There are no cookie
+// sessions over gRPC.
+func (s *server) authUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := s.authorizeCall(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *server) authStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authorizeCall(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+}
+
+func (s *server) authorizeCall(ctx context.Context, method string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var claims *Claims
+	if header := firstMetadata(md, "authorization"); header != "" {
+		var err error
+		if claims, err = s.auth.claimsFromBearer(ctx, header); err != nil {
+			return ctx, err
+		}
+		ctx = context.WithValue(ctx, claimsKey{}, claims)
+	}
+
+	if grpcWriteMethods[method] {
+		if claims == nil {
+			return ctx, ErrUnauthenticated
+		}
+		if !claims.HasRole(writerRoles...) {
+			return ctx, ErrForbidden
+		}
+	}
+	return ctx, nil
+}
+
+func firstMetadata(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// grpcStatus is the gRPC side of problemFor. The code follows the HTTP
+// status of the problem and the details carry the rest of it: an
+// ErrorInfo with the problem type, a BadRequest with the invalid fields
+// and a RequestInfo with the request id.
+func grpcStatus(ctx context.Context, err error) *status.Status {
+	if st, ok := status.FromError(err); ok {
+		// already a status, e.g. from the transport
+		return st
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err)
+	}
+
+	p := problemFor(err)
+	message := p.Detail
+	if message == "" {
+		message = p.Title
+	}
+	st := status.New(grpcCode(p.Status, err), message)
+
+	details := []protoadapt.MessageV1{&errdetails.ErrorInfo{
+		Reason: problemReason(p),
+		Domain: grpcErrorDomain,
+		Metadata: map[string]string{
+			"type":   p.Type,
+			"status": strconv.Itoa(p.Status),
+		},
+	}}
+	if len(p.Errors) > 0 {
+		bad := &errdetails.BadRequest{}
+		for _, fe := range p.Errors {
+			bad.FieldViolations = append(bad.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       protoFieldPath(fe.Field),
+				Description: fe.Message,
+			})
+		}
+		details = append(details, bad)
+	}
+	if id := requestIdFromContext(ctx); id != "" {
+		details = append(details, &errdetails.RequestInfo{RequestId: id})
+	}
+
+	if withDetails, err := st.WithDetails(details...); err == nil {
+		return withDetails
+	}
+	return st
+}
+
+// grpcCode maps the HTTP status of a problem. A 409 is ALREADY_EXISTS for
+// a duplicate id and FAILED_PRECONDITION for anything else, like an
+// author that still has courses.
+func grpcCode(httpStatus int, err error) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity, http.StatusUnsupportedMediaType:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		if errors.Is(err, ErrCourseExists) || errors.Is(err, ErrAuthorExists) {
+			return codes.AlreadyExists
+		}
+		return codes.FailedPrecondition
+	case http.StatusPreconditionFailed:
+		return codes.Aborted
+	case http.StatusRequestEntityTooLarge:
+		return codes.ResourceExhausted
+	case http.StatusMethodNotAllowed:
+		return codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	}
+	return codes.Internal
+}
+
+// problemReason makes an ErrorInfo reason out of the problem type, e.g.
+// "/problems/not-found" becomes NOT_FOUND.
+func problemReason(p *Problem) string {
+	name, ok := strings.CutPrefix(p.Type, "/problems/")
+	if !ok {
+		name = p.Title
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return '_'
+		}
+		return unicode.ToUpper(r)
+	}, name)
+}
+
+// protoFieldPath turns the JSON path of a FieldError into the proto field
+// names, e.g. "author.fullName" becomes "author.full_name".
+func protoFieldPath(path string) string {
+	var b strings.Builder
+	for _, r := range path {
+		if unicode.IsUpper(r) {
+			b.WriteByte('_')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"example.com/hello/Code/26.APIBuild/coursepb"
+)
+
+func TestUpsertIds(t *testing.T) {
+	store := newMemoryStore()
+	if err := seedCourses(store); err != nil {
+		t.Fatal(err)
+	}
+	cs := &courseService{s: &server{store: store, ids: &uuidV7Generator{now: time.Now}}}
+	ctx := context.Background()
+
+	course, created, err := cs.upsert(ctx, &coursepb.Course{CourseId: "2", CourseName: "ReactJS 2", CoursePrice: 10})
+	if err != nil || created || course.CourseName != "ReactJS 2" {
+		t.Errorf("upsert 2 = %+v, %v, %v, want course 2 updated", course, created, err)
+	}
+
+	course, created, err = cs.upsert(ctx, &coursepb.Course{CourseName: "Go", CoursePrice: 5})
+	if err != nil || !created || course.CourseId == "" {
+		t.Errorf("upsert without id = %+v, %v, %v, want a new course", course, created, err)
+	}
+
+	if _, _, err := cs.upsert(ctx, &coursepb.Course{CourseId: "mine", CourseName: "Rust", CoursePrice: 5}); !errors.Is(err, ErrCourseNotFound) {
+		t.Errorf("upsert mine: err = %v, want ErrCourseNotFound", err)
+	}
+	if _, err := store.Get(ctx, "mine"); !errors.Is(err, ErrCourseNotFound) {
+		t.Errorf("course mine was created: %v", err)
+	}
+}
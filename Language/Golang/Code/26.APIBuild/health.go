@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// health backs the Kubernetes probes. /healthz only says the process is
+// alive; /readyz also says whether it should get traffic, which it should
+// not before serve starts listening, after a shutdown signal, or while a
+// dependency check fails.
+type health struct {
+	ready  atomic.Bool
+	checks map[string]func(ctx context.Context) error
+}
+
+// healthReport is the body of both probes, also when /readyz fails.
+type healthReport struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+func (h *health) liveness(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, healthReport{Status: "ok"})
+}
+
+func (h *health) readiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	status := http.StatusOK
+	results := make(map[string]string, len(h.checks))
+	if !h.ready.Load() {
+		status = http.StatusServiceUnavailable
+		results["server"] = "not accepting traffic"
+	}
+	for name, check := range h.checks {
+		if err := check(ctx); err != nil {
+			status = http.StatusServiceUnavailable
+			results[name] = err.Error()
+		} else {
+			results[name] = "ok"
+		}
+	}
+
+	body := healthReport{Status: "ready", Checks: results}
+	if status != http.StatusOK {
+		body.Status = "unavailable"
+	}
+	writeHealth(w, status, body)
+}
+
+func writeHealth(w http.ResponseWriter, status int, body healthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// serve runs srv, and grpcSrv unless it is nil, until SIGINT or SIGTERM.
+// Then /readyz starts failing, the listeners close and in-flight requests
+// and calls get cfg.ShutdownTimeout to finish before the remaining
+// connections are cut. A second signal stops the process right away.
+func serve(srv *http.Server, grpcSrv *grpc.Server, cfg serverConfig, h *health) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var grpcLis net.Listener
+	if grpcSrv != nil {
+		var err error
+		if grpcLis, err = net.Listen("tcp", fmt.Sprintf(":%d", cfg.GRPCPort)); err != nil {
+			return err
+		}
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		if cfg.TLSCertFile != "" {
+			errc <- srv.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			errc <- srv.ListenAndServe()
+		}
+	}()
+	grpcErrc := make(chan error, 1)
+	if grpcSrv != nil {
+		go func() { grpcErrc <- grpcSrv.Serve(grpcLis) }()
+	}
+	h.ready.Store(true)
+
+	select {
+	case err := <-errc:
+		if grpcSrv != nil {
+			grpcSrv.Stop()
+		}
+		return err
+	case err := <-grpcErrc:
+		srv.Close()
+		return err
+	case <-ctx.Done():
+	}
+	// from here on a second signal gets the default behavior and kills us
+	stop()
+	h.ready.Store(false)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if grpcSrv != nil {
+		// GracefulStop waits for streams without end, Stop cuts them
+		stopped := make(chan struct{})
+		go func() {
+			grpcSrv.GracefulStop()
+			close(stopped)
+		}()
+		defer func() {
+			select {
+			case <-stopped:
+			case <-shutdownCtx.Done():
+				grpcSrv.Stop()
+			}
+		}()
+	}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		srv.Close()
+		return err
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
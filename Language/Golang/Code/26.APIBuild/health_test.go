@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestHealthProbes(t *testing.T) {
+	var dbErr error
+	h := &health{checks: map[string]func(context.Context) error{
+		"db": func(ctx context.Context) error { return dbErr },
+	}}
+
+	probe := func(handler http.HandlerFunc) (int, healthReport) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Header().Get("Cache-Control") != "no-store" {
+			t.Errorf("Cache-Control = %q", rec.Header().Get("Cache-Control"))
+		}
+		var report healthReport
+		if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, report
+	}
+
+	if status, report := probe(h.liveness); status != http.StatusOK || report.Status != "ok" {
+		t.Errorf("liveness = %d %+v", status, report)
+	}
+
+	// before serve listens
+	status, report := probe(h.readiness)
+	if status != http.StatusServiceUnavailable || report.Status != "unavailable" || report.Checks["server"] == "" {
+		t.Errorf("readiness before start = %d %+v", status, report)
+	}
+
+	h.ready.Store(true)
+	status, report = probe(h.readiness)
+	if status != http.StatusOK || report.Status != "ready" || report.Checks["db"] != "ok" {
+		t.Errorf("readiness = %d %+v", status, report)
+	}
+
+	dbErr = errors.New("connection refused")
+	status, report = probe(h.readiness)
+	if status != http.StatusServiceUnavailable || report.Checks["db"] != "connection refused" {
+		t.Errorf("readiness with a failing check = %d %+v", status, report)
+	}
+
+	// liveness does not care
+	if status, _ := probe(h.liveness); status != http.StatusOK {
+		t.Errorf("liveness with a failing check = %d", status)
+	}
+}
+
+// TestGracefulShutdown sends the test process SIGTERM while a request is
+// in flight. serve has to stop being ready, let the request finish within
+// the shutdown timeout, and return without an error.
+func TestGracefulShutdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		slow     time.Duration
+		timeout  time.Duration
+		finishes bool
+	}{
+		{"drained", 200 * time.Millisecond, 5 * time.Second, true},
+		{"timed out", 5 * time.Second, 100 * time.Millisecond, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started := make(chan struct{})
+			h := &health{}
+			srv := &http.Server{
+				Addr: fmt.Sprintf("127.0.0.1:%d", freePort(t)),
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					close(started)
+					select {
+					case <-time.After(tt.slow):
+					case <-r.Context().Done():
+					}
+					w.Write([]byte("done"))
+				}),
+			}
+			cfg := serverConfig{ShutdownTimeout: tt.timeout}
+
+			served := make(chan error, 1)
+			go func() { served <- serve(srv, nil, cfg, h) }()
+			waitFor(t, h.ready.Load)
+
+			reqErr := make(chan error, 1)
+			go func() {
+				res, err := http.Get("http://" + srv.Addr + "/slow")
+				if err == nil {
+					res.Body.Close()
+					if res.StatusCode != http.StatusOK {
+						err = fmt.Errorf("status %d", res.StatusCode)
+					}
+				}
+				reqErr <- err
+			}()
+			<-started
+
+			p, err := os.FindProcess(os.Getpid())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := p.Signal(syscall.SIGTERM); err != nil {
+				t.Skip("can not signal the test process:", err)
+			}
+			waitFor(t, func() bool { return !h.ready.Load() })
+
+			err = <-served
+			if tt.finishes {
+				if err != nil {
+					t.Errorf("serve = %v", err)
+				}
+				if err := <-reqErr; err != nil {
+					t.Errorf("in-flight request: %v", err)
+				}
+			} else {
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("serve = %v, want the shutdown deadline", err)
+				}
+				if err := <-reqErr; err == nil {
+					t.Error("the request outliving the timeout was not cut")
+				}
+			}
+		})
+	}
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting")
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+
+var ErrIdempotencyInFlight = errors.New("a request with this Idempotency-Key is still being processed")
+var ErrIdempotencyKeyReused = errors.New("this Idempotency-Key was already used with a different request")
+
+// replayedHeaders are the response headers stored and sent again on a
+// replay. Anything request specific, like X-Request-ID, is left out.
+var replayedHeaders = []string{"Content-Type", "ETag", "Location"}
+
+// IdempotencyRecord is what is kept per key: the fingerprint of the first
+// request and, once it finished, its response.
+type IdempotencyRecord struct {
+	Fingerprint string
+	Done        bool
+	Status      int
+	Header      http.Header
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+// IdempotencyStore remembers requests by key. Like AuthStore it is an
+// interface so several instances could share one store.
+type IdempotencyStore interface {
+	// Begin claims the key for a new request. When the key is already
+	// taken it returns the existing record and started is false.
+	Begin(ctx context.Context, key, fingerprint string, ttl time.Duration) (record IdempotencyRecord, started bool, err error)
+	// Complete stores the response of a request Begin started.
+	Complete(ctx context.Context, key string, record IdempotencyRecord) error
+	// Release forgets the key so the client can try again.
+	Release(ctx context.Context, key string) error
+}
+
+// memoryIdempotencyStore drops expired keys whenever a new one is claimed.
+// The keys wait in a heap by expiry, so a claim only looks at the keys
+// that did expire.
+type memoryIdempotencyStore struct {
+	mu       sync.Mutex
+	now      func() time.Time
+	records  map[string]IdempotencyRecord
+	expiries expiryHeap
+}
+
+func newMemoryIdempotencyStore() *memoryIdempotencyStore {
+	return &memoryIdempotencyStore{now: time.Now, records: make(map[string]IdempotencyRecord)}
+}
+
+func (s *memoryIdempotencyStore) Begin(ctx context.Context, key, fingerprint string, ttl time.Duration) (IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for len(s.expiries) > 0 && now.After(s.expiries[0].at) {
+		expired := heap.Pop(&s.expiries).(expiry)
+		// a released key may have been claimed again since
+		if record, ok := s.records[expired.key]; ok && record.ExpiresAt.Equal(expired.at) {
+			delete(s.records, expired.key)
+		}
+	}
+	if record, ok := s.records[key]; ok {
+		return record, false, nil
+	}
+	record := IdempotencyRecord{Fingerprint: fingerprint, ExpiresAt: now.Add(ttl)}
+	s.records[key] = record
+	heap.Push(&s.expiries, expiry{key: key, at: record.ExpiresAt})
+	return record, true, nil
+}
+
+func (s *memoryIdempotencyStore) Complete(ctx context.Context, key string, record IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record.Done = true
+	s.records[key] = record
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+// expiry is one claimed key in expiryHeap.
+type expiry struct {
+	key string
+	at  time.Time
+}
+
+// expiryHeap is a container/heap with the key that expires first on top.
+type expiryHeap []expiry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h expiryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x any)        { *h = append(*h, x.(expiry)) }
+func (h *expiryHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// idempotency makes retries of a handler safe when the client sends an
+// Idempotency-Key. The first request with a key runs and its response is
+// kept for ttl; a retry with the same key and body gets that response
+// again, marked with Idempotent-Replayed. Requests without the header run
+// as before.
+type idempotency struct {
+	store IdempotencyStore
+	ttl   time.Duration
+}
+
+func (i *idempotency) wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if !validRequestId(key) {
+			writeProblem(w, r, newProblem(http.StatusBadRequest, "Idempotency-Key must be 1 to 128 printable characters"))
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				err = ErrBodyTooLarge
+			}
+			writeProblem(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// keys are per caller, two users can not see each other's responses
+		scope := ""
+		if claims, ok := claimsFromContext(r.Context()); ok {
+			scope = claims.Subject
+		}
+		storeKey := scope + "\x00" + r.Method + " " + r.URL.Path + "\x00" + key
+
+		ctx := r.Context()
+		fingerprint := requestFingerprint(r, body)
+		record, started, err := i.store.Begin(ctx, storeKey, fingerprint, i.ttl)
+		switch {
+		case err != nil:
+			writeProblem(w, r, err)
+			return
+		case !started && record.Fingerprint != fingerprint:
+			writeProblem(w, r, ErrIdempotencyKeyReused)
+			return
+		case !started && !record.Done:
+			w.Header().Set("Retry-After", "1")
+			writeProblem(w, r, ErrIdempotencyInFlight)
+			return
+		case !started:
+			for name, values := range record.Header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(record.Status)
+			w.Write(record.Body)
+			return
+		}
+
+		rec := &responseCapture{ResponseWriter: w}
+		completed := false
+		defer func() {
+			if !completed {
+				// the handler panicked, let the client retry
+				i.store.Release(context.WithoutCancel(ctx), storeKey)
+			}
+		}()
+		next(rec, r)
+		completed = true
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		if rec.status >= 500 {
+			// a server error may succeed next time, so it is not kept
+			i.store.Release(context.WithoutCancel(ctx), storeKey)
+			return
+		}
+		record.Status = rec.status
+		record.Body = rec.body.Bytes()
+		record.Header = http.Header{}
+		for _, name := range replayedHeaders {
+			if v := rec.header.Values(name); len(v) > 0 {
+				record.Header[http.CanonicalHeaderKey(name)] = v
+			}
+		}
+		if err := i.store.Complete(context.WithoutCancel(ctx), storeKey, record); err != nil {
+			// the response is already out, a retry would run again
+			i.store.Release(context.WithoutCancel(ctx), storeKey)
+		}
+	}
+}
+
+// requestFingerprint tells a retry from a different request that reuses
+// the key. A retry asking for another response format or API version is
+// a different request, the stored body could not answer it; so is the same
+// body sent with another Content-Type.
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	io.WriteString(h, r.Header.Get("Content-Type")+"\n")
+	io.WriteString(h, formatFromContext(r.Context()).mediaType+"\n")
+	io.WriteString(h, strconv.Itoa(int(apiVersionFromContext(r.Context())))+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseCapture passes the response through and keeps a copy of it.
+// The headers are copied as the handler set them, before writers further
+// out, like etagSuffixWriter, change them; a replay goes through those
+// writers again.
+type responseCapture struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (rec *responseCapture) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+		rec.header = rec.Header().Clone()
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseCapture) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *responseCapture) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestIdempotentCreate retries POST /course with the same key.
+func TestIdempotentCreate(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+	const course = `{"courseName":"Go","coursePrice":5}`
+
+	first, firstBody := ts.do(t, "POST", "/course", course, idempotencyKeyHeader, "k1")
+	if first.StatusCode != http.StatusCreated || first.Header.Get("Idempotent-Replayed") != "" {
+		t.Fatalf("first POST: %d %v %s", first.StatusCode, first.Header, firstBody)
+	}
+	again, againBody := ts.do(t, "POST", "/course", course, idempotencyKeyHeader, "k1")
+	if again.StatusCode != http.StatusCreated || againBody != firstBody || again.Header.Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry: %d %v %s, want the first response replayed", again.StatusCode, again.Header, againBody)
+	}
+	if again.Header.Get("Location") != first.Header.Get("Location") || again.Header.Get("ETag") != first.Header.Get("ETag") {
+		t.Errorf("retry headers %v, want %v", again.Header, first.Header)
+	}
+	if again.Header.Get(requestIdHeader) == first.Header.Get(requestIdHeader) {
+		t.Error("the X-Request-ID was replayed")
+	}
+
+	_, list := ts.do(t, "GET", "/courses?limit=100", "")
+	if n := strings.Count(list, `"courseName":"Go"`); n != 1 {
+		t.Errorf("%d courses created, want 1", n)
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		header []string
+		status int
+	}{
+		{"other body", `{"courseName":"Rust","coursePrice":5}`, nil, http.StatusUnprocessableEntity},
+		{"other content type", course, []string{"Content-Type", "application/merge-patch+json"}, http.StatusUnprocessableEntity},
+		{"other format", course, []string{"Accept", "application/xml"}, http.StatusUnprocessableEntity},
+		{"other key", course, nil, http.StatusCreated},
+		{"bad key", course, nil, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		key := "k1"
+		switch tt.name {
+		case "other key":
+			key = "k2"
+		case "bad key":
+			key = "with space"
+		}
+		res, body := ts.do(t, "POST", "/course", tt.body, append([]string{idempotencyKeyHeader, key}, tt.header...)...)
+		if res.StatusCode != tt.status {
+			t.Errorf("%s: %d %s, want %d", tt.name, res.StatusCode, body, tt.status)
+		}
+	}
+
+	// keys are per caller
+	other, err := newKeySetForTest().Sign(Claims{Subject: "someone else", Role: "admin", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, body := ts.do(t, "POST", "/course", course, idempotencyKeyHeader, "k1", "Authorization", "Bearer "+other)
+	if res.StatusCode != http.StatusCreated || res.Header.Get("Idempotent-Replayed") != "" {
+		t.Errorf("same key, other caller: %d %v %s", res.StatusCode, res.Header, body)
+	}
+}
+
+func newKeySetForTest() *KeySet {
+	keys := newKeySet("", "")
+	keys.AddHMAC("test", []byte("test secret"))
+	keys.UseForSigning("test")
+	return keys
+}
+
+// TestIdempotencyWrap runs wrap around handlers that block, fail and
+// panic.
+func TestIdempotencyWrap(t *testing.T) {
+	i := &idempotency{store: newMemoryIdempotencyStore(), ttl: time.Hour}
+	send := func(h http.HandlerFunc, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/thing", strings.NewReader(`{}`))
+		req.Header.Set(idempotencyKeyHeader, key)
+		rec := httptest.NewRecorder()
+		i.wrap(h)(rec, req)
+		return rec
+	}
+
+	t.Run("in flight", func(t *testing.T) {
+		entered, release := make(chan struct{}), make(chan struct{})
+		done := make(chan *httptest.ResponseRecorder)
+		go func() {
+			done <- send(func(w http.ResponseWriter, r *http.Request) {
+				close(entered)
+				<-release
+				w.WriteHeader(http.StatusCreated)
+			}, "slow")
+		}()
+		<-entered
+
+		rec := send(func(w http.ResponseWriter, r *http.Request) { t.Error("ran twice") }, "slow")
+		if rec.Code != http.StatusConflict || rec.Header().Get("Retry-After") == "" {
+			t.Errorf("while in flight: %d %v", rec.Code, rec.Header())
+		}
+		close(release)
+		if rec := <-done; rec.Code != http.StatusCreated {
+			t.Errorf("first request: %d", rec.Code)
+		}
+	})
+
+	for _, fail := range []string{"5xx", "panic"} {
+		t.Run(fail+" releases the key", func(t *testing.T) {
+			var runs atomic.Int32
+			h := func(w http.ResponseWriter, r *http.Request) {
+				if runs.Add(1) == 1 {
+					if fail == "panic" {
+						panic("boom")
+					}
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.WriteHeader(http.StatusCreated)
+			}
+
+			func() {
+				defer func() { recover() }()
+				send(h, fail)
+			}()
+			if rec := send(h, fail); rec.Code != http.StatusCreated || runs.Load() != 2 {
+				t.Errorf("retry: %d after %d runs, want 201 from a second run", rec.Code, runs.Load())
+			}
+			if rec := send(h, fail); rec.Code != http.StatusCreated || rec.Header().Get("Idempotent-Replayed") != "true" || runs.Load() != 2 {
+				t.Errorf("third try: %d %v after %d runs, want the replay", rec.Code, rec.Header(), runs.Load())
+			}
+		})
+	}
+
+	t.Run("4xx is kept", func(t *testing.T) {
+		var runs atomic.Int32
+		h := func(w http.ResponseWriter, r *http.Request) {
+			runs.Add(1)
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}
+		send(h, "invalid")
+		if rec := send(h, "invalid"); rec.Code != http.StatusUnprocessableEntity || runs.Load() != 1 {
+			t.Errorf("retry: %d after %d runs", rec.Code, runs.Load())
+		}
+	})
+}
+
+func TestMemoryIdempotencyStoreExpiry(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1_700_000_000, 0)
+	s := newMemoryIdempotencyStore()
+	s.now = func() time.Time { return now }
+
+	for n := range 5 {
+		s.Begin(ctx, "k"+strconv.Itoa(n), "f", time.Duration(n+1)*time.Minute)
+	}
+	// released and claimed again, the first heap entry is stale
+	s.Release(ctx, "k0")
+	now = now.Add(30 * time.Second)
+	s.Begin(ctx, "k0", "f2", 10*time.Minute)
+
+	now = now.Add(3 * time.Minute)
+	if _, started, _ := s.Begin(ctx, "k1", "f", time.Minute); !started {
+		t.Error("k1 did not expire")
+	}
+	if len(s.records) != 4 {
+		t.Errorf("records = %v, want k0, k1, k3 and k4", s.records)
+	}
+	if record, started, _ := s.Begin(ctx, "k0", "f3", time.Minute); started || record.Fingerprint != "f2" {
+		t.Errorf("k0 = %+v, started %v, want the second claim kept", record, started)
+	}
+	if _, started, _ := s.Begin(ctx, "k3", "f", time.Minute); started {
+		t.Error("k3 expired early")
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// IdGenerator hands out new course ids. Both implementations put a
+// millisecond timestamp first and are monotonic inside one process, so the
+// ids of one generator sort in creation order as plain strings. Ids of the
+// two kinds do not sort among each other.
+type IdGenerator interface {
+	NewId() string
+}
+
+func newIdGenerator(kind string) (IdGenerator, error) {
+	switch kind {
+	case "uuidv7":
+		return &uuidV7Generator{now: time.Now}, nil
+	case "ulid":
+		return &ulidGenerator{now: time.Now}, nil
+	}
+	return nil, fmt.Errorf("unknown id generator %q", kind)
+}
+
+// uuidV7Generator builds RFC 9562 version 7 UUIDs. The 12 bit rand_a field
+// is used as a counter inside one millisecond (method 1 of the RFC): it
+// starts at a random value with the top bit clear and is incremented for
+// every id in the same millisecond. When it runs out we borrow the next
+// millisecond, so ids never go backwards even if the clock does.
+type uuidV7Generator struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	lastMs  uint64
+	counter uint16
+}
+
+func (g *uuidV7Generator) NewId() string {
+	var b [16]byte
+	if _, err := rand.Read(b[6:]); err != nil {
+		panic(err)
+	}
+
+	g.mu.Lock()
+	ms := uint64(g.now().UnixMilli())
+	if ms > g.lastMs {
+		g.lastMs = ms
+		g.counter = binary.BigEndian.Uint16(b[6:8]) & 0x07ff
+	} else {
+		g.counter++
+		if g.counter > 0x0fff {
+			g.lastMs++
+			g.counter = 0
+		}
+	}
+	ms, counter := g.lastMs, g.counter
+	g.mu.Unlock()
+
+	b[0] = byte(ms >> 40)
+	b[1] = byte(ms >> 32)
+	b[2] = byte(ms >> 24)
+	b[3] = byte(ms >> 16)
+	b[4] = byte(ms >> 8)
+	b[5] = byte(ms)
+	b[6] = 0x70 | byte(counter>>8) // version 7
+	b[7] = byte(counter)
+	b[8] = 0x80 | b[8]&0x3f // variant 10
+
+	var out [36]byte
+	hex.Encode(out[0:8], b[0:4])
+	out[8] = '-'
+	hex.Encode(out[9:13], b[4:6])
+	out[13] = '-'
+	hex.Encode(out[14:18], b[6:8])
+	out[18] = '-'
+	hex.Encode(out[19:23], b[8:10])
+	out[23] = '-'
+	hex.Encode(out[24:], b[10:])
+	return string(out[:])
+}
+
+// crockford is the ULID alphabet, it leaves out I, L, O and U.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ulidGenerator builds ULIDs: 48 bits of milliseconds and 80 random bits,
+// written as 26 Crockford base32 characters. Inside one millisecond the
+// random part is incremented instead of redrawn, as the ULID spec asks for
+// monotonic ids.
+type ulidGenerator struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	lastMs  uint64
+	entropy [10]byte
+}
+
+func (g *ulidGenerator) NewId() string {
+	g.mu.Lock()
+	ms := uint64(g.now().UnixMilli())
+	if ms > g.lastMs {
+		g.lastMs = ms
+		if _, err := rand.Read(g.entropy[:]); err != nil {
+			g.mu.Unlock()
+			panic(err)
+		}
+	} else if !increment(g.entropy[:]) {
+		// the random part wrapped around, move on to the next millisecond
+		g.lastMs++
+	}
+	var b [16]byte
+	ms = g.lastMs
+	b[0] = byte(ms >> 40)
+	b[1] = byte(ms >> 32)
+	b[2] = byte(ms >> 24)
+	b[3] = byte(ms >> 16)
+	b[4] = byte(ms >> 8)
+	b[5] = byte(ms)
+	copy(b[6:], g.entropy[:])
+	g.mu.Unlock()
+
+	return encodeCrockford(b)
+}
+
+// increment adds one to a big-endian number and reports false on overflow.
+func increment(b []byte) bool {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeCrockford writes 128 bits as 26 characters, 5 bits at a time,
+// starting with the 3 leftover high bits.
+func encodeCrockford(b [16]byte) string {
+	hi := binary.BigEndian.Uint64(b[0:8])
+	lo := binary.BigEndian.Uint64(b[8:16])
+
+	var out [26]byte
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+// fakeClock returns the times in steps, one per call, and then the last
+// one forever.
+func fakeClock(steps ...time.Time) func() time.Time {
+	return func() time.Time {
+		now := steps[0]
+		if len(steps) > 1 {
+			steps = steps[1:]
+		}
+		return now
+	}
+}
+
+func TestIdsMonotonic(t *testing.T) {
+	base := time.UnixMilli(1_700_000_000_000)
+	clocks := []struct {
+		name  string
+		steps []time.Time
+	}{
+		{"same millisecond", []time.Time{base}},
+		{"clock goes back", []time.Time{base, base.Add(-time.Second), base.Add(-time.Hour), base}},
+		{"clock moves on", []time.Time{base, base.Add(time.Millisecond), base.Add(2 * time.Millisecond)}},
+	}
+	kinds := []struct {
+		name string
+		new  func(now func() time.Time) IdGenerator
+	}{
+		{"uuidv7", func(now func() time.Time) IdGenerator { return &uuidV7Generator{now: now} }},
+		{"ulid", func(now func() time.Time) IdGenerator { return &ulidGenerator{now: now} }},
+	}
+
+	for _, kind := range kinds {
+		for _, clock := range clocks {
+			t.Run(kind.name+"/"+clock.name, func(t *testing.T) {
+				g := kind.new(fakeClock(clock.steps...))
+				// more than the 4096 ids a UUIDv7 counter holds in a millisecond
+				prev := g.NewId()
+				for i := 0; i < 5000; i++ {
+					id := g.NewId()
+					if id <= prev {
+						t.Fatalf("id %d: %s after %s", i, id, prev)
+					}
+					prev = id
+				}
+			})
+		}
+	}
+}
+
+var (
+	uuidV7Pattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	ulidPattern   = regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+)
+
+func TestIdFormats(t *testing.T) {
+	now := time.UnixMilli(0x0189_2b3c_4d5e)
+	tests := []struct {
+		kind    string
+		pattern *regexp.Regexp
+		// prefix is the millisecond timestamp as the id writes it
+		prefix string
+	}{
+		{"uuidv7", uuidV7Pattern, "01892b3c-4d5e-"},
+		{"ulid", ulidPattern, "01H4NKRKAY"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			g, err := newIdGenerator(tt.kind)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch g := g.(type) {
+			case *uuidV7Generator:
+				g.now = fakeClock(now)
+			case *ulidGenerator:
+				g.now = fakeClock(now)
+			}
+			id := g.NewId()
+			if !tt.pattern.MatchString(id) {
+				t.Errorf("%s does not look like a %s", id, tt.kind)
+			}
+			if id[:len(tt.prefix)] != tt.prefix {
+				t.Errorf("%s does not start with the timestamp %s", id, tt.prefix)
+			}
+		})
+	}
+
+	if _, err := newIdGenerator("serial"); err == nil {
+		t.Error("unknown generator: err = nil")
+	}
+}
+
+func TestULIDOverflow(t *testing.T) {
+	now := time.UnixMilli(1_700_000_000_000)
+	g := &ulidGenerator{now: fakeClock(now)}
+	first := g.NewId()
+	for i := range g.entropy {
+		g.entropy[i] = 0xff
+	}
+	next := g.NewId()
+	if next <= first || g.lastMs != uint64(now.UnixMilli())+1 {
+		t.Errorf("after a full random part: %s after %s, lastMs %d", next, first, g.lastMs)
+	}
+}
+
+func TestEncodeCrockford(t *testing.T) {
+	tests := []struct {
+		in   [16]byte
+		want string
+	}{
+		{[16]byte{}, "00000000000000000000000000"},
+		{[16]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, "7ZZZZZZZZZZZZZZZZZZZZZZZZZ"},
+		{[16]byte{15: 32}, "00000000000000000000000010"},
+	}
+	for _, tt := range tests {
+		if got := encodeCrockford(tt.in); got != tt.want {
+			t.Errorf("encodeCrockford(%x) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
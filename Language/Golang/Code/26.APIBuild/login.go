@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sessionCookie    = "session_id"
+	pbkdf2Iterations = 600000
+)
+
+var ErrBadCredentials = errors.New("wrong username or password")
+var ErrCSRF = errors.New("missing or wrong X-CSRF-Token header")
+
+// user is one account that may log in. Roles end up in the token claims.
+type user struct {
+	Username     string   `json:"username"`
+	PasswordHash string   `json:"passwordHash"`
+	Roles        []string `json:"roles"`
+}
+
+// userDirectory is the list of accounts, read from a JSON file at startup:
+//
+//	[{"username": "hitesh", "passwordHash": "pbkdf2-sha256$...", "roles": ["instructor"]}]
+//
+// Hashes are made with -hash-password.
+type userDirectory struct {
+	users map[string]user
+}
+
+func loadUsers(path string) (*userDirectory, error) {
+	d := &userDirectory{users: make(map[string]user)}
+	if path == "" {
+		return d, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var users []user
+	if err := json.Unmarshal(raw, &users); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, u := range users {
+		d.users[u.Username] = u
+	}
+	return d, nil
+}
+
+// dummyHash is checked for unknown usernames so a wrong name takes as long
+// as a wrong password.
+var dummyHash, _ = hashPassword("not the password")
+
+func (d *userDirectory) check(username, password string) (user, error) {
+	u, ok := d.users[username]
+	if !ok {
+		checkPassword(dummyHash, password)
+		return user{}, ErrBadCredentials
+	}
+	if !checkPassword(u.PasswordHash, password) {
+		return user{}, ErrBadCredentials
+	}
+	return u, nil
+}
+
+// hashPassword returns "pbkdf2-sha256$<iterations>$<salt>$<hash>".
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	hash, err := pbkdf2.Key(sha256.New, password, salt, pbkdf2Iterations, 32)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", pbkdf2Iterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash)), nil
+}
+
+func checkPassword(encoded, password string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false
+	}
+	salt, err1 := base64.RawStdEncoding.DecodeString(parts[2])
+	want, err2 := base64.RawStdEncoding.DecodeString(parts[3])
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	got, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	return err == nil && hmac.Equal(got, want)
+}
+
+// authService issues and checks credentials. In token mode a login gets a
+// short lived access JWT plus a refresh token; every refresh rotates the
+// refresh token and presenting a used one revokes the whole login. In
+// session mode the login lives on the server and the browser keeps a
+// cookie, as in Authentication/Session_Based_Auth.
+type authService struct {
+	keys          *KeySet
+	store         AuthStore
+	users         *userDirectory
+	accessTTL     time.Duration
+	refreshTTL    time.Duration
+	sessionTTL    time.Duration
+	secureCookies bool
+	now           func() time.Time
+}
+
+type loginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type sessionResponse struct {
+	CSRFToken string `json:"csrf_token"`
+	ExpiresIn int    `json:"expires_in"`
+}
+
+// login checks username and password. ?mode=session starts a cookie
+// session instead of handing out tokens.
+func (a *authService) login(w http.ResponseWriter, r *http.Request) {
+	var body loginRequest
+	if err := decodeJSON(w, r, &body); err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	u, err := a.users.check(body.Username, body.Password)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "", "token":
+		tokens, err := a.issueTokens(r.Context(), u, randomToken())
+		if err != nil {
+			writeProblem(w, r, err)
+			return
+		}
+		writeTokenJSON(w, http.StatusOK, tokens)
+	case "session":
+		a.startSession(w, r, u)
+	default:
+		writeProblem(w, r, newProblem(http.StatusBadRequest, "mode must be token or session"))
+	}
+}
+
+// refresh trades a refresh token for a new access and refresh token.
+func (a *authService) refresh(w http.ResponseWriter, r *http.Request) {
+	var body refreshRequest
+	if err := decodeJSON(w, r, &body); err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	old, err := a.store.UseRefreshToken(ctx, hashToken(body.RefreshToken))
+	if errors.Is(err, ErrRefreshTokenReused) {
+		// the token was stolen or replayed, nobody should keep this login
+		if err := a.revokeFamily(ctx, old.Family); err != nil {
+			writeProblem(w, r, err)
+			return
+		}
+		unauthorized(w, r, fmt.Errorf("%w: refresh token reuse detected, the login was revoked", ErrInvalidToken))
+		return
+	}
+	if err != nil {
+		unauthorized(w, r, fmt.Errorf("%w: %v", ErrInvalidToken, err))
+		return
+	}
+	if a.now().After(old.ExpiresAt) {
+		unauthorized(w, r, fmt.Errorf("%w: refresh token expired", ErrInvalidToken))
+		return
+	}
+
+	// pick up role changes made since the login
+	u, ok := a.users.users[old.Subject]
+	if !ok {
+		unauthorized(w, r, fmt.Errorf("%w: user no longer exists", ErrInvalidToken))
+		return
+	}
+
+	tokens, err := a.issueTokens(ctx, u, old.Family)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	writeTokenJSON(w, http.StatusOK, tokens)
+}
+
+// logout ends a cookie session, or revokes the access token and every
+// refresh token of the login the access token belongs to.
+func (a *authService) logout(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims, _ := claimsFromContext(ctx)
+
+	if cookie, err := r.Cookie(sessionCookie); err == nil && cookie.Value == claims.Id {
+		if err := a.store.DeleteSession(ctx, cookie.Value); err != nil {
+			writeProblem(w, r, err)
+			return
+		}
+		http.SetCookie(w, a.cookie("", -1))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if claims.Id != "" {
+		if err := a.store.RevokeAccessToken(ctx, claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+			writeProblem(w, r, err)
+			return
+		}
+	}
+	if claims.SessionId != "" {
+		if err := a.revokeFamily(ctx, claims.SessionId); err != nil {
+			writeProblem(w, r, err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *authService) issueTokens(ctx context.Context, u user, family string) (tokenResponse, error) {
+	if !a.keys.CanSign() {
+		return tokenResponse{}, newProblem(http.StatusNotImplemented, "token login is not configured on this server")
+	}
+
+	now := a.now()
+	accessExpires := now.Add(a.accessTTL)
+	claims := Claims{
+		Subject:   u.Username,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: accessExpires.Unix(),
+		Id:        randomToken(),
+		SessionId: family,
+		Roles:     u.Roles,
+	}
+	access, err := a.keys.Sign(claims)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	refresh := randomToken()
+	err = a.store.SaveRefreshToken(ctx, RefreshToken{
+		Hash:            hashToken(refresh),
+		Family:          family,
+		Subject:         u.Username,
+		Roles:           u.Roles,
+		ExpiresAt:       now.Add(a.refreshTTL),
+		AccessId:        claims.Id,
+		AccessExpiresAt: accessExpires,
+	})
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	return tokenResponse{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(a.accessTTL.Seconds()),
+		RefreshToken: refresh,
+	}, nil
+}
+
+// revokeFamily revokes every refresh token of a login and puts the access
+// tokens issued with them on the revocation list until they expire.
+func (a *authService) revokeFamily(ctx context.Context, family string) error {
+	tokens, err := a.store.RevokeFamily(ctx, family)
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if err := a.store.RevokeAccessToken(ctx, token.AccessId, token.AccessExpiresAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *authService) startSession(w http.ResponseWriter, r *http.Request, u user) {
+	session := Session{
+		Id:        randomToken(),
+		Subject:   u.Username,
+		Roles:     u.Roles,
+		CSRFToken: randomToken(),
+		ExpiresAt: a.now().Add(a.sessionTTL),
+	}
+	if err := a.store.CreateSession(r.Context(), session); err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	http.SetCookie(w, a.cookie(session.Id, int(a.sessionTTL.Seconds())))
+	writeTokenJSON(w, http.StatusOK, sessionResponse{
+		CSRFToken: session.CSRFToken,
+		ExpiresIn: int(a.sessionTTL.Seconds()),
+	})
+}
+
+func (a *authService) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   a.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func (a *authService) claimsFromBearer(ctx context.Context, header string) (*Claims, error) {
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return nil, fmt.Errorf("%w: use the Bearer scheme", ErrInvalidToken)
+	}
+	claims, err := a.keys.Verify(strings.TrimSpace(token))
+	if err != nil {
+		return nil, err
+	}
+	if claims.Id != "" {
+		revoked, err := a.store.IsAccessTokenRevoked(ctx, claims.Id)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, fmt.Errorf("%w: token was revoked", ErrInvalidToken)
+		}
+	}
+	return claims, nil
+}
+
+// claimsFromSession turns a live session into claims. An unknown or
+// expired session is treated as no login at all, so public reads keep
+// working with a stale cookie.
+func (a *authService) claimsFromSession(r *http.Request, id string) (*Claims, error) {
+	session, err := a.store.GetSession(r.Context(), id)
+	if errors.Is(err, ErrSessionNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// a cookie is sent by the browser on its own, so writes must prove
+	// they come from our page by echoing the CSRF token
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		if !hmac.Equal([]byte(r.Header.Get("X-CSRF-Token")), []byte(session.CSRFToken)) {
+			return nil, ErrCSRF
+		}
+	}
+
+	return &Claims{
+		Subject:   session.Subject,
+		Roles:     session.Roles,
+		Id:        session.Id,
+		ExpiresAt: session.ExpiresAt.Unix(),
+	}, nil
+}
+
+// randomToken returns 32 random bytes, base64url encoded.
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// writeTokenJSON answers with credentials, which must never be cached.
+func writeTokenJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestLoginWithSessionCookie logs in again, and refreshes, from a browser
+// that still holds a session cookie but sends no CSRF token.
+func TestLoginWithSessionCookie(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+	hash, err := hashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.auth.users.users["hitesh"] = user{Username: "hitesh", PasswordHash: hash, Roles: []string{"instructor"}}
+	const credentials = `{"username":"hitesh","password":"secret"}`
+
+	res, body := ts.do(t, "POST", "/login?mode=session", credentials, "Authorization", "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("session login: %d %s", res.StatusCode, body)
+	}
+	var live string
+	for _, c := range res.Cookies() {
+		if c.Name == sessionCookie {
+			live = c.Value
+		}
+	}
+	if live == "" {
+		t.Fatal("session login set no cookie")
+	}
+
+	for _, cookie := range []string{live, "stale"} {
+		header := []string{"Authorization", "", "Cookie", sessionCookie + "=" + cookie}
+
+		res, body = ts.do(t, "POST", "/login", credentials, header...)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("login with cookie %s: %d %s", cookie, res.StatusCode, body)
+		}
+		var tokens tokenResponse
+		if err := json.Unmarshal([]byte(body), &tokens); err != nil {
+			t.Fatal(err)
+		}
+
+		res, body = ts.do(t, "POST", "/token/refresh", `{"refresh_token":"`+tokens.RefreshToken+`"}`, header...)
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("refresh with cookie %s: %d %s", cookie, res.StatusCode, body)
+		}
+	}
+
+	// other writes still need the CSRF token
+	res, body = ts.do(t, "POST", "/course", `{"courseName":"Go","coursePrice":5}`, "Authorization", "", "Cookie", sessionCookie+"="+live)
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("POST /course without CSRF token: %d %s, want 403", res.StatusCode, body)
+	}
+}
+
+func TestMemoryAuthStorePurge(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1_700_000_000, 0)
+	s := newMemoryAuthStore()
+	s.now = func() time.Time { return now }
+
+	s.CreateSession(ctx, Session{Id: "old", ExpiresAt: now.Add(time.Second)})
+	now = now.Add(2 * time.Second)
+	if _, err := s.GetSession(ctx, "old"); err != ErrSessionNotFound {
+		t.Errorf("expired session: err = %v", err)
+	}
+
+	// a write soon after the last purge leaves the expired entry alone
+	s.CreateSession(ctx, Session{Id: "new", ExpiresAt: now.Add(time.Hour)})
+	if _, ok := s.sessions["old"]; !ok {
+		t.Error("purged again before authPurgeInterval")
+	}
+
+	now = now.Add(authPurgeInterval)
+	s.RevokeAccessToken(ctx, "jti", now.Add(time.Minute))
+	if _, ok := s.sessions["old"]; ok {
+		t.Error("expired session not purged after authPurgeInterval")
+	}
+	if _, ok := s.sessions["new"]; !ok {
+		t.Error("live session purged")
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The collectors below write the Prometheus text exposition format
+// (version 0.0.4) themselves, so the API needs no client library and a
+// test can scrape /metrics without a Prometheus server.
+
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// latencyBuckets are the bucket bounds from Observability/Prometheus.
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	writeTo(w *bufio.Writer)
+}
+
+// metricsRegistry writes its collectors in the order they were added.
+type metricsRegistry struct {
+	collectors []collector
+}
+
+func (reg *metricsRegistry) add(c collector) {
+	reg.collectors = append(reg.collectors, c)
+}
+
+func (reg *metricsRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metricsContentType)
+	out := bufio.NewWriter(w)
+	for _, c := range reg.collectors {
+		c.writeTo(out)
+	}
+	out.Flush()
+}
+
+// series is one set of label values.
+type series struct {
+	labels []string
+	value  float64
+}
+
+// metricVec is a counter or gauge with labels.
+type metricVec struct {
+	name   string
+	help   string
+	kind   string // "counter" or "gauge"
+	labels []string
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func newMetricVec(kind, name, help string, labels ...string) *metricVec {
+	return &metricVec{name: name, help: help, kind: kind, labels: labels, series: make(map[string]*series)}
+}
+
+// add changes the series for values by delta. Counters only go up.
+func (m *metricVec) add(delta float64, values ...string) {
+	if m.kind == "counter" && delta < 0 {
+		panic("metrics: counter " + m.name + " can not go down")
+	}
+	key := strings.Join(values, "\xff")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labels: values}
+		m.series[key] = s
+	}
+	s.value += delta
+}
+
+func (m *metricVec) writeTo(w *bufio.Writer) {
+	writeHeader(w, m.name, m.help, m.kind)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range sortedKeys(m.series) {
+		s := m.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(m.labels, s.labels, "", ""), formatValue(s.value))
+	}
+}
+
+// histogramVec counts observations into cumulative buckets.
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogram
+}
+
+type histogram struct {
+	labels []string
+	counts []uint64 // per bucket, not cumulative; the last one is +Inf
+	sum    float64
+	count  uint64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogram)}
+}
+
+func (h *histogramVec) observe(value float64, values ...string) {
+	key := strings.Join(values, "\xff")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{labels: values, counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	i, _ := slices.BinarySearch(h.buckets, value)
+	s.counts[i]++
+	s.sum += value
+	s.count++
+}
+
+func (h *histogramVec) writeTo(w *bufio.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.labels, "le", formatValue(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, s.labels, "", ""), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, s.labels, "", ""), s.count)
+	}
+}
+
+// gaugeFunc is a gauge without labels whose value is read at scrape time.
+type gaugeFunc struct {
+	name  string
+	help  string
+	value func() (float64, error)
+}
+
+func (g *gaugeFunc) writeTo(w *bufio.Writer) {
+	v, err := g.value()
+	if err != nil {
+		// leave the series out rather than report a wrong number
+		return
+	}
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatValue(v))
+}
+
+func writeHeader(w *bufio.Writer, name, help, kind string) {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// formatLabels renders {a="1",b="2"}, with an extra label (le) at the end
+// when extraName is set.
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, name, escape.Replace(values[i]))
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, extraName, extraValue)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// apiMetrics are the metrics of the course service.
+type apiMetrics struct {
+	registry        *metricsRegistry
+	requestsTotal   *metricVec
+	requestDuration *histogramVec
+	inFlight        *metricVec
+	// webhookDeliveries counts webhook attempts by how they ended
+	webhookDeliveries *metricVec
+	cacheLookups      *metricVec
+}
+
+func newAPIMetrics(store CourseStore) *apiMetrics {
+	m := &apiMetrics{
+		registry: &metricsRegistry{},
+		requestsTotal: newMetricVec("counter", "courses_http_requests_total",
+			"Total HTTP requests by method, route template and status code.",
+			"method", "route", "status_code"),
+		requestDuration: newHistogramVec("courses_http_request_duration_seconds",
+			"HTTP request latency distribution in seconds.",
+			latencyBuckets, "method", "route"),
+		inFlight: newMetricVec("gauge", "courses_http_requests_in_flight",
+			"Requests currently being served by route template.",
+			"method", "route"),
+		webhookDeliveries: newMetricVec("counter", "courses_webhook_deliveries_total",
+			"Webhook delivery attempts by result: delivered, retried or dead_lettered.",
+			"result"),
+		cacheLookups: newMetricVec("counter", "courses_http_cache_lookups_total",
+			"Response cache lookups by result: hit, stale, miss or bypass.",
+			"result"),
+	}
+
+	m.registry.add(m.requestsTotal)
+	m.registry.add(m.requestDuration)
+	m.registry.add(m.inFlight)
+	m.registry.add(m.webhookDeliveries)
+	m.registry.add(m.cacheLookups)
+	m.registry.add(&gaugeFunc{
+		name: "courses_catalog_courses",
+		help: "Number of courses in the store.",
+		value: func() (float64, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			n, err := store.Count(ctx)
+			return float64(n), err
+		},
+	})
+	m.registry.add(&gaugeFunc{
+		name: "courses_catalog_authors",
+		help: "Number of authors in the store.",
+		value: func() (float64, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			n, err := store.CountAuthors(ctx)
+			return float64(n), err
+		},
+	})
+	m.registry.add(&gaugeFunc{
+		name: "courses_go_goroutines",
+		help: "Number of goroutines that currently exist.",
+		value: func() (float64, error) {
+			return float64(runtime.NumGoroutine()), nil
+		},
+	})
+	return m
+}
+
+// observe records a finished request. Requests no route matched are
+// grouped under "unmatched" so random paths can not blow up the series.
+func (m *apiMetrics) observe(method, route string, status int, took time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	method = methodLabel(method)
+	m.requestsTotal.add(1, method, route, strconv.Itoa(status))
+	m.requestDuration.observe(took.Seconds(), method, route)
+}
+
+// knownMethods are the methods that get a series of their own.
+var knownMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodDelete, http.MethodOptions, http.MethodConnect, http.MethodTrace,
+}
+
+// methodLabel is method as a label value. Any method works over HTTP, so
+// the ones made up by clients share "OTHER" like unmatched routes do.
+func methodLabel(method string) string {
+	if slices.Contains(knownMethods, method) {
+		return method
+	}
+	return "OTHER"
+}
+
+// trackInFlight is a mux middleware, it runs after routing so the gauge is
+// keyed by the route template.
+func (m *apiMetrics) trackInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unmatched"
+		if info := infoFromContext(r.Context()); info != nil && info.route != "" {
+			route = info.route
+		}
+		method := methodLabel(r.Method)
+		m.inFlight.add(1, method, route)
+		defer m.inFlight.add(-1, method, route)
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// sampleLine is one sample of the text exposition format.
+var sampleLine = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*(\{([a-zA-Z_][a-zA-Z0-9_]*="[^"]*",?)*\})? \S+$`)
+
+func TestMetricsScrape(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+
+	ts.do(t, "GET", "/courses", "")
+	ts.do(t, "GET", "/courses", "")
+	ts.do(t, "GET", "/course/2", "")
+	ts.do(t, "GET", "/course/missing", "")
+	ts.do(t, "POST", "/course", `{"courseName":"Go","coursePrice":10}`)
+	ts.do(t, "BREW", "/courses", "")
+
+	res, body := ts.do(t, "GET", "/metrics", "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("GET /metrics: %d", res.StatusCode)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != metricsContentType {
+		t.Errorf("Content-Type = %q, want %q", ct, metricsContentType)
+	}
+
+	samples := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		if strings.HasPrefix(line, "# HELP ") || strings.HasPrefix(line, "# TYPE ") {
+			continue
+		}
+		if !sampleLine.MatchString(line) {
+			t.Errorf("not a sample line: %q", line)
+		}
+		samples[line] = true
+	}
+
+	for _, want := range []string{
+		// route templates, not paths
+		`courses_http_requests_total{method="GET",route="/courses",status_code="200"} 2`,
+		`courses_http_requests_total{method="GET",route="/course/{id}",status_code="200"} 1`,
+		`courses_http_requests_total{method="GET",route="/course/{id}",status_code="404"} 1`,
+		`courses_http_requests_total{method="POST",route="/course",status_code="201"} 1`,
+		// a made-up method does not get a series of its own
+		`courses_http_requests_total{method="OTHER",route="unmatched",status_code="405"} 1`,
+		`courses_http_request_duration_seconds_bucket{method="GET",route="/courses",le="+Inf"} 2`,
+		`courses_http_request_duration_seconds_count{method="GET",route="/courses"} 2`,
+		`courses_http_requests_in_flight{method="GET",route="/courses"} 0`,
+		`courses_http_requests_in_flight{method="GET",route="/metrics"} 1`,
+		`courses_catalog_courses 3`,
+		`courses_catalog_authors 1`,
+	} {
+		if !samples[want] {
+			t.Errorf("missing sample %s", want)
+		}
+	}
+	for _, want := range []string{
+		"# TYPE courses_http_requests_total counter",
+		"# TYPE courses_http_request_duration_seconds histogram",
+		"# TYPE courses_http_requests_in_flight gauge",
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("missing %q", want)
+		}
+	}
+	if strings.Contains(body, `method="BREW"`) {
+		t.Error("the BREW method got a series")
+	}
+}
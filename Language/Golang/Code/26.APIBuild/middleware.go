@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const requestIdHeader = "X-Request-ID"
+
+// requestInfo travels in the context of every request. The outer
+// middlewares create it and the router fills in the matched route, which
+// only the router knows.
+type requestInfo struct {
+	id    string
+	route string
+}
+
+type requestInfoKey struct{}
+
+func infoFromContext(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// requestIdFromContext returns the X-Request-ID of the request, or "".
+func requestIdFromContext(ctx context.Context) string {
+	if info := infoFromContext(ctx); info != nil {
+		return info.id
+	}
+	return ""
+}
+
+// withMiddleware wraps the router in the chain every request goes through:
+// request id, the server span, the access log and metrics, then panic
+// recovery. They sit outside the router so 404s and 405s are logged,
+// traced and counted too.
+func (s *server) withMiddleware(r *mux.Router) http.Handler {
+	r.Use(recordRoute, s.metrics.trackInFlight)
+	return s.requestId(s.traceRequests(s.accessLog(s.recoverPanic(r))))
+}
+
+// requestId keeps the X-Request-ID a client or proxy sent, or makes a new
+// one, and echoes it on the response.
+func (s *server) requestId(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIdHeader)
+		if !validRequestId(id) {
+			id = s.ids.NewId()
+		}
+		w.Header().Set(requestIdHeader, id)
+
+		ctx := context.WithValue(r.Context(), requestInfoKey{}, &requestInfo{id: id})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validRequestId only accepts short, printable ids so a client can not
+// inject anything odd into our logs.
+func validRequestId(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// recordRoute is a mux middleware that stores the route template, e.g.
+// "/course/{id}", so logs and metrics do not get one entry per id.
+func recordRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info := infoFromContext(r.Context()); info != nil {
+			if route := mux.CurrentRoute(r); route != nil {
+				info.route, _ = route.GetPathTemplate()
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// statusRecorder remembers what a handler wrote.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the real writer.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// accessLog writes one JSON line per request and records it in the
+// request metrics.
+func (s *server) accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r)
+
+		info := infoFromContext(r.Context())
+		route := info.route
+		if route == "" {
+			route = "unmatched"
+		}
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		var traceId, spanId string
+		if span := SpanFromContext(r.Context()); span != nil {
+			traceId = span.SpanContext().TraceId.String()
+			spanId = span.SpanContext().SpanId.String()
+		}
+		took := time.Since(start)
+		s.metrics.observe(r.Method, route, rec.status, took)
+		s.logger.LogAttrs(r.Context(), slog.LevelInfo, "request",
+			slog.String("request_id", info.id),
+			slog.String("trace_id", traceId),
+			slog.String("span_id", spanId),
+			slog.String("method", r.Method),
+			slog.String("route", route),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Float64("latency_ms", float64(took.Microseconds())/1000),
+			slog.Int("bytes", rec.bytes),
+			slog.String("remote", r.RemoteAddr),
+		)
+	})
+}
+
+// recoverPanic turns a panic in a handler into a 500 problem instead of a
+// dropped connection, with the same defer/recover as 23.Recover.
+func (s *server) recoverPanic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				if rec == http.ErrAbortHandler {
+					// the handler wants the connection dropped, let net/http do it
+					panic(rec)
+				}
+
+				s.logger.LogAttrs(r.Context(), slog.LevelError, "panic",
+					slog.String("request_id", requestIdFromContext(r.Context())),
+					slog.String("error", fmt.Sprint(rec)),
+					slog.String("stack", string(debug.Stack())),
+				)
+				if sr, ok := w.(*statusRecorder); ok && sr.status != 0 {
+					// the response already started, all we can do is log
+					return
+				}
+				w.Header().Set("Connection", "close")
+				writeProblem(w, r, newProblem(http.StatusInternalServerError, "the server hit an unexpected error"))
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
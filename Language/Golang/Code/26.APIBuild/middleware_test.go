@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// newMiddlewareServer wraps a small router in withMiddleware and logs to
+// the returned buffer, one JSON object per line.
+func newMiddlewareServer() (http.Handler, *bytes.Buffer) {
+	var logs bytes.Buffer
+	s := &server{
+		ids:     &uuidV7Generator{now: time.Now},
+		logger:  slog.New(slog.NewJSONHandler(&logs, nil)),
+		metrics: newAPIMetrics(newMemoryStore()),
+		tracer:  newTracer(nil, 1),
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/thing/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("thing"))
+	})
+	r.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	r.HandleFunc("/panic-late", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("half"))
+		panic("boom")
+	})
+	r.HandleFunc("/abort", func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+	return s.withMiddleware(r), &logs
+}
+
+// logLines decodes every line written to logs.
+func logLines(t *testing.T, logs *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func TestRequestId(t *testing.T) {
+	h, _ := newMiddlewareServer()
+
+	tests := []struct {
+		name, sent string
+		kept       bool
+	}{
+		{"kept", "req-1", true},
+		{"missing", "", false},
+		{"space", "req 1", false},
+		{"newline", "req\n1", false},
+		{"too long", strings.Repeat("a", 129), false},
+		{"longest", strings.Repeat("a", 128), true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/thing/1", nil)
+		if tt.sent != "" {
+			req.Header.Set(requestIdHeader, tt.sent)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		got := rec.Header().Get(requestIdHeader)
+		if tt.kept && got != tt.sent {
+			t.Errorf("%s: X-Request-ID = %q, want %q", tt.name, got, tt.sent)
+		}
+		if !tt.kept && (got == tt.sent || !validRequestId(got)) {
+			t.Errorf("%s: X-Request-ID = %q, want a new id", tt.name, got)
+		}
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	h, logs := newMiddlewareServer()
+
+	for _, path := range []string{"/thing/42", "/nope"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set(requestIdHeader, "req"+path)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	lines := logLines(t, logs)
+	if len(lines) != 2 {
+		t.Fatalf("%d log lines, want 2: %s", len(lines), logs)
+	}
+	tests := []struct {
+		path, route string
+		status      float64
+	}{
+		{"/thing/42", "/thing/{id}", http.StatusOK},
+		{"/nope", "unmatched", http.StatusNotFound},
+	}
+	for i, tt := range tests {
+		line := lines[i]
+		if line["msg"] != "request" || line["request_id"] != "req"+tt.path || line["method"] != "GET" ||
+			line["path"] != tt.path || line["route"] != tt.route || line["status"] != tt.status {
+			t.Errorf("log line %d = %v", i, line)
+		}
+		if line["trace_id"] == "" || line["span_id"] == "" {
+			t.Errorf("log line %d has no trace: %v", i, line)
+		}
+		if _, ok := line["latency_ms"].(float64); !ok {
+			t.Errorf("log line %d has no latency: %v", i, line)
+		}
+	}
+	if lines[0]["bytes"] != float64(len("thing")) {
+		t.Errorf("bytes = %v, want %d", lines[0]["bytes"], len("thing"))
+	}
+}
+
+func TestRecoverPanic(t *testing.T) {
+	h, logs := newMiddlewareServer()
+
+	req := httptest.NewRequest("GET", "/panic", nil)
+	req.Header.Set(requestIdHeader, "req-1")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError || rec.Header().Get("Connection") != "close" {
+		t.Errorf("panic: %d Connection %q, want 500 close", rec.Code, rec.Header().Get("Connection"))
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("panic: Content-Type %q", ct)
+	}
+	if strings.Contains(rec.Body.String(), "boom") {
+		t.Errorf("panic value leaked to the client: %s", rec.Body)
+	}
+
+	lines := logLines(t, logs)
+	if len(lines) != 2 {
+		t.Fatalf("%d log lines, want the panic and the request: %s", len(lines), logs)
+	}
+	if lines[0]["msg"] != "panic" || lines[0]["level"] != "ERROR" || lines[0]["error"] != "boom" ||
+		lines[0]["request_id"] != "req-1" || !strings.Contains(lines[0]["stack"].(string), "goroutine") {
+		t.Errorf("panic log = %v", lines[0])
+	}
+	if lines[1]["status"] != float64(http.StatusInternalServerError) {
+		t.Errorf("access log = %v, want status 500", lines[1])
+	}
+
+	// once the response started it can only be logged
+	logs.Reset()
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/panic-late", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "half" {
+		t.Errorf("late panic: %d %q, want the 200 that was already sent", rec.Code, rec.Body)
+	}
+	if lines := logLines(t, logs); len(lines) != 2 || lines[0]["msg"] != "panic" {
+		t.Errorf("late panic was not logged: %s", logs)
+	}
+}
+
+func TestRecoverPanicAbort(t *testing.T) {
+	h, _ := newMiddlewareServer()
+
+	defer func() {
+		if rec := recover(); rec != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler passed on", rec)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/abort", nil))
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+var ErrNotAcceptable = errors.New("none of the media types in Accept can be produced")
+var ErrMalformedBody = errors.New("malformed body")
+
+// entityFormat is one media type courses and authors can be read and
+// written as. All of them use the JSON field names.
+type entityFormat struct {
+	mediaType string
+	// aliases are other names clients use for the same format
+	aliases []string
+	// etagSuffix goes into the ETag of a representation in this format,
+	// since the bytes differ from the JSON one of the same version
+	etagSuffix string
+	encode     func(w io.Writer, v any) error
+	decode     func(body []byte, v any) error
+}
+
+var jsonFormat = &entityFormat{
+	mediaType: "application/json",
+	encode: func(w io.Writer, v any) error {
+		return json.NewEncoder(w).Encode(v)
+	},
+}
+
+// entityFormats are in order of preference, the first one wins when
+// Accept likes several of them the same.
+var entityFormats = []*entityFormat{
+	jsonFormat,
+	{
+		mediaType:  "application/xml",
+		aliases:    []string{"text/xml"},
+		etagSuffix: "+xml",
+		encode:     encodeXML,
+		decode:     decodeXML,
+	},
+	{
+		mediaType:  "application/msgpack",
+		aliases:    []string{"application/x-msgpack", "application/vnd.msgpack"},
+		etagSuffix: "+msgpack",
+		encode: func(w io.Writer, v any) error {
+			enc := msgpack.NewEncoder(w)
+			enc.SetCustomStructTag("json")
+			enc.UseCompactInts(true)
+			return enc.Encode(v)
+		},
+		decode: func(body []byte, v any) error {
+			rd := bytes.NewReader(body)
+			dec := msgpack.NewDecoder(rd)
+			dec.SetCustomStructTag("json")
+			dec.DisallowUnknownFields(true)
+			if err := dec.Decode(v); err != nil {
+				return err
+			}
+			if rd.Len() > 0 {
+				return errors.New("body must hold a single object")
+			}
+			return nil
+		},
+	},
+	{
+		mediaType:  "application/cbor",
+		etagSuffix: "+cbor",
+		encode: func(w io.Writer, v any) error {
+			return cbor.NewEncoder(w).Encode(v)
+		},
+		decode: func(body []byte, v any) error {
+			return cborDecMode.Unmarshal(body, v)
+		},
+	},
+}
+
+var cborDecMode, _ = cbor.DecOptions{ExtraReturnErrors: cbor.ExtraDecErrorUnknownField}.DecMode()
+
+func (f *entityFormat) is(mediaType string) bool {
+	if mediaType == f.mediaType {
+		return true
+	}
+	for _, alias := range f.aliases {
+		if mediaType == alias {
+			return true
+		}
+	}
+	return false
+}
+
+// withETagSuffix puts suffix inside the quotes of tag, e.g. "3" becomes
+// "3+xml".
+func withETagSuffix(tag, suffix string) string {
+	if suffix == "" || !strings.HasSuffix(tag, `"`) {
+		return tag
+	}
+	return strings.TrimSuffix(tag, `"`) + suffix + `"`
+}
+
+// etagFormat tells which format an ETag sent by a client was made for and
+// returns it as the JSON ETag the handlers compare against.
+func etagFormat(tag string) (*entityFormat, string) {
+	for _, f := range entityFormats {
+		if f.etagSuffix != "" && strings.HasSuffix(tag, f.etagSuffix+`"`) {
+			return f, strings.TrimSuffix(tag, f.etagSuffix+`"`) + `"`
+		}
+	}
+	return jsonFormat, tag
+}
+
+// formatFor picks the format to answer an Accept header with. Every
+// format takes the q of the most specific range that matches it. No
+// Accept at all means JSON.
+func formatFor(accept string) (*entityFormat, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return jsonFormat, true
+	}
+
+	type acceptRange struct {
+		mediaType string
+		q         float64
+	}
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType, q})
+	}
+
+	var best *entityFormat
+	bestQ := 0.0
+	for _, f := range entityFormats {
+		specificity, q := -1, 0.0
+		for _, rng := range ranges {
+			s := -1
+			switch {
+			case f.is(rng.mediaType):
+				s = 2
+			case strings.HasSuffix(rng.mediaType, "/*") && matchesType(f, strings.TrimSuffix(rng.mediaType, "*")):
+				s = 1
+			case rng.mediaType == "*/*":
+				s = 0
+			}
+			if s > specificity {
+				specificity, q = s, rng.q
+			}
+		}
+		if q > bestQ {
+			best, bestQ = f, q
+		}
+	}
+	return best, best != nil
+}
+
+func matchesType(f *entityFormat, prefix string) bool {
+	if strings.HasPrefix(f.mediaType, prefix) {
+		return true
+	}
+	for _, alias := range f.aliases {
+		if strings.HasPrefix(alias, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+type entityFormatKey struct{}
+
+func formatFromContext(ctx context.Context) *entityFormat {
+	if f, ok := ctx.Value(entityFormatKey{}).(*entityFormat); ok {
+		return f
+	}
+	return jsonFormat
+}
+
+// negotiate picks the response format of a course or author route from
+// Accept, or answers 406. It gives every format its own ETag and maps the
+// ETags in If-Match and If-None-Match back to the JSON ones, so handlers
+// only ever deal with those.
+func negotiate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+
+		f, ok := formatFor(r.Header.Get("Accept"))
+		if !ok {
+			writeProblem(w, r, fmt.Errorf("%w, ask for one of %s", ErrNotAcceptable, strings.Join(entityMediaTypes(), ", ")))
+			return
+		}
+
+		if im := r.Header.Get("If-Match"); im != "" {
+			// a write checks the version, whichever format it was read in
+			r.Header.Set("If-Match", rewriteETags(im, func(tag string) (string, bool) {
+				_, tag = etagFormat(tag)
+				return tag, true
+			}))
+		}
+		if inm := r.Header.Get("If-None-Match"); inm != "" {
+			inm = rewriteETags(inm, func(tag string) (string, bool) {
+				tf, tag := etagFormat(tag)
+				return tag, tf == f
+			})
+			if inm == "" {
+				r.Header.Del("If-None-Match")
+			} else {
+				r.Header.Set("If-None-Match", inm)
+			}
+		}
+
+		next(&etagSuffixWriter{ResponseWriter: w, suffix: f.etagSuffix}, r.WithContext(context.WithValue(r.Context(), entityFormatKey{}, f)))
+	}
+}
+
+// rewriteETags maps every tag of an If-Match or If-None-Match header and
+// drops those keep is false for. "*" is kept as it is.
+func rewriteETags(header string, mapTag func(tag string) (string, bool)) string {
+	var out []string
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			out = append(out, tag)
+			continue
+		}
+		if tag, keep := mapTag(tag); keep {
+			out = append(out, tag)
+		}
+	}
+	return strings.Join(out, ", ")
+}
+
+func entityMediaTypes() []string {
+	types := make([]string, len(entityFormats))
+	for i, f := range entityFormats {
+		types[i] = f.mediaType
+	}
+	return types
+}
+
+// etagSuffixWriter adds the format, or the API version, to the ETag the
+// handler set.
+type etagSuffixWriter struct {
+	http.ResponseWriter
+	suffix      string
+	wroteHeader bool
+}
+
+func (ew *etagSuffixWriter) WriteHeader(status int) {
+	if !ew.wroteHeader {
+		ew.wroteHeader = true
+		if etag := ew.Header().Get("ETag"); etag != "" {
+			ew.Header().Set("ETag", withETagSuffix(etag, ew.suffix))
+		}
+	}
+	ew.ResponseWriter.WriteHeader(status)
+}
+
+func (ew *etagSuffixWriter) Write(b []byte) (int, error) {
+	if !ew.wroteHeader {
+		ew.WriteHeader(http.StatusOK)
+	}
+	return ew.ResponseWriter.Write(b)
+}
+
+func (ew *etagSuffixWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
+
+// writeEntity answers with v in the format negotiate picked.
+func writeEntity(w http.ResponseWriter, r *http.Request, status int, v any) {
+	f := formatFromContext(r.Context())
+	w.Header().Set("Content-Type", f.mediaType)
+	w.WriteHeader(status)
+	f.encode(w, v)
+}
+
+// decodeEntity is decodeJSON for every entity format, picked by
+// Content-Type. A body without one is JSON.
+func decodeEntity(w http.ResponseWriter, r *http.Request, v any) error {
+	ct := r.Header.Get("Content-Type")
+	if ct == "" {
+		return decodeJSON(w, r, v)
+	}
+	mediaType, _, _ := mime.ParseMediaType(ct)
+	if jsonFormat.is(mediaType) {
+		return decodeJSON(w, r, v)
+	}
+
+	var f *entityFormat
+	for _, candidate := range entityFormats {
+		if candidate.is(mediaType) {
+			f = candidate
+		}
+	}
+	if f == nil {
+		return fmt.Errorf("%w: send the body as one of %s", ErrUnsupportedMediaType, strings.Join(entityMediaTypes(), ", "))
+	}
+
+	if r.Body == nil || r.Body == http.NoBody {
+		return ErrEmptyBody
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return ErrBodyTooLarge
+		}
+		return err
+	}
+	if len(body) == 0 {
+		return ErrEmptyBody
+	}
+	if err := f.decode(body, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedBody, err)
+	}
+	return nil
+}
+
+// encodeXML writes v as an XML document named by its XMLName field, or
+// else after its type, e.g. <authorList>.
+func encodeXML(w io.Writer, v any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if _, named := t.FieldByName("XMLName"); named {
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		return enc.Flush()
+	}
+	first, size := utf8.DecodeRuneInString(t.Name())
+	root := xml.StartElement{Name: xml.Name{Local: string(unicode.ToLower(first)) + t.Name()[size:]}}
+	if err := enc.EncodeElement(v, root); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+// decodeXML is xml.Unmarshal that, like the other formats, rejects
+// elements and attributes v has no field for.
+func decodeXML(body []byte, v any) error {
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	root := false
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) && root {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if root {
+				return errors.New("body must hold a single element")
+			}
+			root = true
+			if err := checkXMLType(dec, tok, reflect.TypeOf(v)); err != nil {
+				return err
+			}
+		case xml.CharData:
+			if len(bytes.TrimSpace(tok)) > 0 {
+				return errors.New("text outside the root element")
+			}
+		}
+	}
+	return xml.Unmarshal(body, v)
+}
+
+var (
+	xmlUnmarshalerType  = reflect.TypeFor[xml.Unmarshaler]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+// checkXMLType walks the element start, which encoding/xml decodes into
+// t, and fails on the first child or attribute t has no field for.
+// Types that decode themselves are trusted.
+func checkXMLType(dec *xml.Decoder, start xml.StartElement, t reflect.Type) error {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8 {
+		t = t.Elem()
+	}
+	pt := reflect.PointerTo(t)
+	if t.Kind() != reflect.Struct || pt.Implements(xmlUnmarshalerType) || pt.Implements(textUnmarshalerType) {
+		return dec.Skip()
+	}
+	return checkXMLShape(dec, start, xmlShapeOf(t))
+}
+
+func checkXMLShape(dec *xml.Decoder, start xml.StartElement, shape *xmlShape) error {
+	for _, attr := range start.Attr {
+		if attr.Name.Space == "xmlns" || attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+			continue
+		}
+		if !shape.any && !shape.attrs[attr.Name.Local] {
+			return fmt.Errorf("unknown attribute %q in <%s>", attr.Name.Local, start.Name.Local)
+		}
+	}
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			child, ok := shape.elems[tok.Name.Local]
+			switch {
+			case !ok && shape.any:
+				err = dec.Skip()
+			case !ok:
+				return fmt.Errorf("unknown element <%s> in <%s>", tok.Name.Local, start.Name.Local)
+			case child.typ != nil:
+				err = checkXMLType(dec, tok, child.typ)
+			default:
+				err = checkXMLShape(dec, tok, child)
+			}
+			if err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// xmlShape is what an element may hold: either a field of type typ, or
+// the children and attributes of a struct, or of a step of an "a>b" path.
+type xmlShape struct {
+	typ   reflect.Type
+	elems map[string]*xmlShape
+	attrs map[string]bool
+	// any is set by an ",any" or ",innerxml" field, which takes everything
+	any bool
+}
+
+// xmlShapeOf maps the fields of struct t the way encoding/xml does.
+func xmlShapeOf(t reflect.Type) *xmlShape {
+	shape := &xmlShape{elems: map[string]*xmlShape{}, attrs: map[string]bool{}}
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Name == "XMLName" {
+			continue
+		}
+		tag := f.Tag.Get("xml")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			// reflect.VisibleFields lists the promoted fields as well
+			continue
+		}
+		flags := strings.Split(opts, ",")
+		switch {
+		case slices.Contains(flags, "any"), slices.Contains(flags, "innerxml"):
+			shape.any = true
+			continue
+		case slices.Contains(flags, "chardata"), slices.Contains(flags, "cdata"), slices.Contains(flags, "comment"):
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if slices.Contains(flags, "attr") {
+			shape.attrs[name] = true
+			continue
+		}
+		parent, path := shape, strings.Split(name, ">")
+		for _, step := range path[:len(path)-1] {
+			next, ok := parent.elems[step]
+			if !ok {
+				next = &xmlShape{elems: map[string]*xmlShape{}, attrs: map[string]bool{}}
+				parent.elems[step] = next
+			}
+			parent = next
+		}
+		parent.elems[path[len(path)-1]] = &xmlShape{typ: f.Type}
+	}
+	return shape
+}
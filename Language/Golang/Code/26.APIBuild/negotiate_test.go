@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestXMLDecodeStrict(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		ok   bool
+	}{
+		{"known fields", `<course><courseName>Go</courseName><coursePrice>5</coursePrice></course>`, true},
+		{"unknown element", `<course><courseName>Go</courseName><discount>5</discount></course>`, false},
+		{"unknown nested element", `<course><courseName>Go</courseName><author><fullName>A</fullName><age>3</age></author></course>`, false},
+		{"unknown attribute", `<course draft="true"><courseName>Go</courseName></course>`, false},
+		{"namespace declaration", `<course xmlns="urn:x"><courseName>Go</courseName></course>`, true},
+		{"second root", `<course><courseName>Go</courseName></course><course/>`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var course Course
+			err := decodeXML([]byte(tt.body), &course)
+			if (err == nil) != tt.ok {
+				t.Errorf("err = %v, want ok = %v", err, tt.ok)
+			}
+		})
+	}
+
+	var list authorList
+	if err := decodeXML([]byte(`<authorList><authors><author><fullName>A</fullName></author></authors><total>1</total></authorList>`), &list); err != nil || len(list.Authors) != 1 {
+		t.Errorf("a>b path: %+v, %v", list, err)
+	}
+	if err := decodeXML([]byte(`<authorList><authors><writer/></authors></authorList>`), &list); err == nil {
+		t.Error("unknown element in a>b path: err = nil")
+	}
+}
+
+func TestIdempotentReplayETag(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+
+	var etags []string
+	for range 2 {
+		res, body := ts.do(t, "POST", "/course", `{"courseName":"Go","coursePrice":5}`,
+			"Accept", "application/xml", "Idempotency-Key", "k1")
+		if res.StatusCode != http.StatusCreated {
+			t.Fatalf("POST: %d %s", res.StatusCode, body)
+		}
+		etags = append(etags, res.Header.Get("ETag"))
+	}
+	if etags[0] != etags[1] || strings.Count(etags[1], "+xml") != 1 {
+		t.Errorf("ETags = %q, want the same XML ETag twice", etags)
+	}
+}
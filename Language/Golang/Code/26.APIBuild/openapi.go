@@ -0,0 +1,816 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/graphql-go/graphql"
+)
+
+// The OpenAPI document is generated at startup from the routes the router
+// really has and from the struct tags of the types the handlers read and
+// write. Every route carries a name and apiOperations holds its docs under
+// that name. buildOpenAPI fails when a route has no docs or docs have no
+// route, so main refuses to start instead of serving a stale contract.
+
+//go:embed docs.html
+var docsPage []byte
+
+// swaggerUI holds the Swagger UI assets docs.html loads, so the docs page
+// does not run scripts from a CDN. VERSION is the swagger-ui-dist release
+// they come from. To update, from 26.APIBuild:
+//
+//	v=$(cat swaggerui/VERSION)
+//	curl -fsSL https://registry.npmjs.org/swagger-ui-dist/-/swagger-ui-dist-$v.tgz |
+//	  tar -xz -C swaggerui --strip-components=1 \
+//	  package/swagger-ui.css package/swagger-ui-bundle.js package/LICENSE
+//
+// and check the tarball against the integrity npm lists for the release.
+//
+//go:embed swaggerui
+var swaggerUI embed.FS
+
+// apiOperation documents one route.
+type apiOperation struct {
+	Summary string
+	Tag     string
+	// Roles nil means public, an empty slice any logged in user.
+	Roles  []string
+	Params []apiParam
+	// Bodies maps a request media type to a value of the Go type it decodes into.
+	Bodies map[string]any
+	Status int
+	// Response is a value of the Go type written on success; oneOf lists
+	// alternatives. ResponseType defaults to application/json.
+	Response     any
+	ResponseType string
+	// Responses replaces Response and ResponseType when the response comes
+	// in several media types.
+	Responses       map[string]any
+	ResponseHeaders []string
+	Errors          []int
+	// ErrorBodies replaces the problem details of some Errors by a JSON
+	// value of another Go type.
+	ErrorBodies map[int]any
+	// Negotiated routes also read and write the other entityFormats, so
+	// every JSON body and response is listed in those too, and they may
+	// answer 406.
+	Negotiated bool
+	Deprecated bool
+}
+
+type apiParam struct {
+	Name        string
+	In          string // "query", "header" or "path"
+	Description string
+	Type        string
+}
+
+type oneOf []any
+
+var courseIdParam = apiParam{Name: "id", In: "path", Description: "id of the course", Type: "string"}
+var authorIdParam = apiParam{Name: "id", In: "path", Description: "id of the author", Type: "string"}
+var webhookIdParam = apiParam{Name: "id", In: "path", Description: "id of the subscription", Type: "string"}
+var deadLetterIdParam = apiParam{Name: "id", In: "path", Description: "id of the dead letter", Type: "string"}
+var expandParam = apiParam{Name: "expand", In: "query", Description: "author to embed the author of each course", Type: "string"}
+
+// courseListParams are the query parameters of every course listing.
+var courseListParams = []apiParam{
+	{Name: "limit", In: "query", Description: fmt.Sprintf("page size, 1 to %d", maxPageLimit), Type: "integer"},
+	{Name: "cursor", In: "query", Description: "next_cursor of the previous page", Type: "string"},
+	{Name: "sort", In: "query", Description: "comma separated fields, - for descending, e.g. -coursePrice,courseName; ties, and a list without sort, go by courseId", Type: "string"},
+	{Name: "coursePrice.gte", In: "query", Description: "lowest price", Type: "integer"},
+	{Name: "coursePrice.lte", In: "query", Description: "highest price", Type: "integer"},
+	{Name: "author.fullName", In: "query", Description: "author name, case insensitive", Type: "string"},
+	{Name: "courseSite", In: "query", Description: "course site, case insensitive", Type: "string"},
+	expandParam,
+}
+var ifNoneMatchParam = apiParam{Name: "If-None-Match", In: "header", Description: "answers 304 when the ETag still matches", Type: "string"}
+var acceptVersionParam = apiParam{Name: "Accept-Version", In: "header", Description: "1 (default) or 2, the course schema to use; see /v1 and /v2", Type: "string"}
+var ifMatchParam = apiParam{Name: "If-Match", In: "header", Description: "ETag the change is based on; 412 when the course changed since", Type: "string"}
+
+var apiOperations = map[string]apiOperation{
+	"home":     {Summary: "Welcome page", Tag: "meta", Status: 200, Response: "", ResponseType: "text/html"},
+	"liveness": {Summary: "Liveness probe", Tag: "meta", Status: 200, Response: healthReport{}},
+	"readiness": {
+		Summary:     "Readiness probe, 503 while starting, stopping or a dependency is down",
+		Tag:         "meta",
+		Status:      200,
+		Response:    healthReport{},
+		Errors:      []int{503},
+		ErrorBodies: map[int]any{503: healthReport{}},
+	},
+	"metrics": {Summary: "Prometheus metrics", Tag: "meta", Status: 200, Response: "", ResponseType: "text/plain"},
+	"openapi": {Summary: "This document", Tag: "meta", Status: 200, Response: map[string]any{}},
+	"docs":    {Summary: "Interactive API docs", Tag: "meta", Status: 200, Response: "", ResponseType: "text/html"},
+	"docsAsset": {
+		Summary:      "Script or stylesheet of the docs page",
+		Tag:          "meta",
+		Params:       []apiParam{{Name: "file", In: "path", Description: "name of the asset", Type: "string"}},
+		Status:       200,
+		Response:     "",
+		ResponseType: "text/plain",
+		Errors:       []int{404},
+	},
+
+	"login": {
+		Summary: "Log in with username and password",
+		Tag:     "auth",
+		Params:  []apiParam{{Name: "mode", In: "query", Description: "token (default) or session for a cookie session", Type: "string"}},
+		Bodies:  map[string]any{"application/json": loginRequest{}},
+		Status:  200,
+		// session mode also sets the session cookie
+		Response: oneOf{tokenResponse{}, sessionResponse{}},
+		Errors:   []int{400, 401, 415},
+	},
+	"refreshToken": {
+		Summary:  "Trade a refresh token for a new token pair",
+		Tag:      "auth",
+		Bodies:   map[string]any{"application/json": refreshRequest{}},
+		Status:   200,
+		Response: tokenResponse{},
+		Errors:   []int{400, 401, 415},
+	},
+	"logout": {Summary: "Revoke the current tokens or session", Tag: "auth", Roles: []string{}, Status: 204},
+
+	"listCourses": {
+		Summary:         "List courses a page at a time",
+		Tag:             "courses",
+		Negotiated:      true,
+		Params:          append([]apiParam{acceptVersionParam, {Name: "authorId", In: "query", Description: "id of the author", Type: "string"}}, courseListParams...),
+		Status:          200,
+		Response:        coursePageV1{},
+		ResponseHeaders: []string{"Cache-Control", "Age", "X-Cache"},
+		Errors:          []int{400},
+	},
+	"searchCourses": {
+		Summary:    "Full text search over course name, site and author name, best match first",
+		Tag:        "courses",
+		Negotiated: true,
+		Params: []apiParam{
+			acceptVersionParam,
+			{Name: "q", In: "query", Description: "words to look for; each has to match, as a prefix or with a typo", Type: "string"},
+			{Name: "limit", In: "query", Description: fmt.Sprintf("page size, 1 to %d", maxPageLimit), Type: "integer"},
+			{Name: "offset", In: "query", Description: "number of results to skip", Type: "integer"},
+		},
+		Status:   200,
+		Response: searchResultsV1{},
+		Errors:   []int{400},
+	},
+	"exportCourses": {
+		Summary: "Download every course as JSON, NDJSON or CSV",
+		Tag:     "courses",
+		Params: []apiParam{{Name: "format", In: "query", Type: "string",
+			Description: "json, ndjson or csv; without it the Accept header decides, else JSON"}},
+		Status:          200,
+		Responses:       map[string]any{"application/json": []Course{}, ndjsonType: Course{}, csvType: ""},
+		ResponseHeaders: []string{"Content-Disposition"},
+		Errors:          []int{400},
+	},
+	"importCourses": {
+		Summary: "Create or replace many courses from JSON, NDJSON or CSV",
+		Tag:     "courses",
+		Roles:   writerRoles,
+		Params: []apiParam{
+			{Name: "mode", In: "query", Type: "string",
+				Description: "atomic (default) writes nothing unless every row is good, best-effort writes the good rows"},
+			{Name: "dryRun", In: "query", Type: "boolean", Description: "true to only check the rows"},
+		},
+		Bodies:      map[string]any{"application/json": []Course{}, ndjsonType: Course{}, csvType: ""},
+		Status:      200,
+		Response:    importReport{},
+		Errors:      []int{400, 413, 415, 422, 500},
+		ErrorBodies: map[int]any{422: importReport{}, 500: importReport{}},
+	},
+	"getCourse": {
+		Summary:         "Get one course",
+		Tag:             "courses",
+		Negotiated:      true,
+		Params:          []apiParam{acceptVersionParam, courseIdParam, expandParam, ifNoneMatchParam},
+		Status:          200,
+		Response:        courseV1{},
+		ResponseHeaders: []string{"ETag", "Cache-Control", "Age", "X-Cache"},
+		Errors:          []int{304, 400, 404},
+	},
+	"createCourse": {
+		Summary:    "Create a course, the server assigns courseId",
+		Tag:        "courses",
+		Negotiated: true,
+		Roles:      writerRoles,
+		Params: []apiParam{acceptVersionParam, {Name: "Idempotency-Key", In: "header", Type: "string",
+			Description: "makes retries safe: a repeat with the same key and body gets the first response again"}},
+		Bodies:          map[string]any{"application/json": courseV1{}},
+		Status:          201,
+		Response:        courseV1{},
+		ResponseHeaders: []string{"ETag", "Location", "Idempotent-Replayed"},
+		Errors:          []int{400, 409, 413, 415, 422},
+	},
+	"replaceCourse": {
+		Summary:         "Replace a course",
+		Tag:             "courses",
+		Negotiated:      true,
+		Roles:           writerRoles,
+		Params:          []apiParam{acceptVersionParam, courseIdParam, ifMatchParam},
+		Bodies:          map[string]any{"application/json": courseV1{}},
+		Status:          200,
+		Response:        courseV1{},
+		ResponseHeaders: []string{"ETag"},
+		Errors:          []int{400, 404, 412, 413, 415, 422},
+	},
+	"patchCourse": {
+		Summary:    "Change part of a course",
+		Tag:        "courses",
+		Negotiated: true,
+		Roles:      writerRoles,
+		Params:     []apiParam{acceptVersionParam, courseIdParam, ifMatchParam},
+		Bodies: map[string]any{
+			mergePatchType: map[string]any{},
+			jsonPatchType:  []patchOp{},
+		},
+		Status:          200,
+		Response:        courseV1{},
+		ResponseHeaders: []string{"ETag"},
+		Errors:          []int{400, 404, 409, 412, 413, 415, 422},
+	},
+	"deleteCourse": {
+		Summary:    "Delete a course",
+		Tag:        "courses",
+		Negotiated: true,
+		Roles:      writerRoles,
+		Params:     []apiParam{acceptVersionParam, courseIdParam, ifMatchParam},
+		Status:     204,
+		Errors:     []int{400, 404, 412},
+	},
+
+	"listAuthors": {
+		Summary:    "List all authors",
+		Tag:        "authors",
+		Negotiated: true,
+		Status:     200,
+		Response:   authorList{},
+	},
+	"getAuthor": {
+		Summary:         "Get one author",
+		Tag:             "authors",
+		Negotiated:      true,
+		Params:          []apiParam{authorIdParam, ifNoneMatchParam},
+		Status:          200,
+		Response:        Author{},
+		ResponseHeaders: []string{"ETag"},
+		Errors:          []int{304, 404},
+	},
+	"createAuthor": {
+		Summary:         "Create an author, the server assigns authorId",
+		Tag:             "authors",
+		Negotiated:      true,
+		Roles:           writerRoles,
+		Bodies:          map[string]any{"application/json": Author{}},
+		Status:          201,
+		Response:        Author{},
+		ResponseHeaders: []string{"ETag", "Location"},
+		Errors:          []int{400, 413, 415, 422},
+	},
+	"replaceAuthor": {
+		Summary:         "Replace an author, all their courses show the change",
+		Tag:             "authors",
+		Negotiated:      true,
+		Roles:           writerRoles,
+		Params:          []apiParam{authorIdParam, ifMatchParam},
+		Bodies:          map[string]any{"application/json": Author{}},
+		Status:          200,
+		Response:        Author{},
+		ResponseHeaders: []string{"ETag"},
+		Errors:          []int{400, 404, 412, 413, 415, 422},
+	},
+	"deleteAuthor": {
+		Summary:    "Delete an author, 409 while they have courses unless cascade=true",
+		Tag:        "authors",
+		Negotiated: true,
+		Roles:      writerRoles,
+		Params: []apiParam{authorIdParam, ifMatchParam,
+			{Name: "cascade", In: "query", Description: "true deletes the courses of the author as well", Type: "boolean"}},
+		Status: 204,
+		Errors: []int{400, 404, 409, 412},
+	},
+	"listAuthorCourses": {
+		Summary:    "List the courses of one author",
+		Tag:        "authors",
+		Negotiated: true,
+		Params:     append([]apiParam{acceptVersionParam, authorIdParam}, courseListParams...),
+		Status:     200,
+		Response:   coursePageV1{},
+		Errors:     []int{400, 404},
+	},
+	"graphqlQuery": {
+		Summary: "Run a GraphQL query given in the query string; mutations need POST",
+		Tag:     "graphql",
+		Params: []apiParam{
+			{Name: "query", In: "query", Description: "the GraphQL document", Type: "string"},
+			{Name: "operationName", In: "query", Description: "operation to run when the document has several", Type: "string"},
+			{Name: "variables", In: "query", Description: "variables as a JSON object", Type: "string"},
+		},
+		Status:   200,
+		Response: graphql.Result{},
+		Errors:   []int{400, 405},
+	},
+	"graphql": {
+		Summary:  "Run a GraphQL query or mutation over courses and authors",
+		Tag:      "graphql",
+		Bodies:   map[string]any{"application/json": graphQLRequest{}},
+		Status:   200,
+		Response: graphql.Result{},
+		Errors:   []int{400, 413, 415},
+	},
+	"listWebhooks": {
+		Summary:  "List webhook subscriptions, without their secrets",
+		Tag:      "webhooks",
+		Roles:    adminRoles,
+		Status:   200,
+		Response: subscriptionList{},
+	},
+	"createWebhook": {
+		Summary:         "Subscribe a URL to course events; the answer holds the signing secret, it is not shown again",
+		Tag:             "webhooks",
+		Roles:           adminRoles,
+		Bodies:          map[string]any{"application/json": subscriptionRequest{}},
+		Status:          201,
+		Response:        Subscription{},
+		ResponseHeaders: []string{"Location"},
+		Errors:          []int{400, 415, 422},
+	},
+	"getWebhook": {
+		Summary:  "Get one webhook subscription",
+		Tag:      "webhooks",
+		Roles:    adminRoles,
+		Params:   []apiParam{webhookIdParam},
+		Status:   200,
+		Response: Subscription{},
+		Errors:   []int{404},
+	},
+	"deleteWebhook": {
+		Summary: "Delete a webhook subscription and its pending retries",
+		Tag:     "webhooks",
+		Roles:   adminRoles,
+		Params:  []apiParam{webhookIdParam},
+		Status:  204,
+		Errors:  []int{404},
+	},
+	"replayWebhook": {
+		Summary:  "Send the outbox events after a seq to one subscription again",
+		Tag:      "webhooks",
+		Roles:    adminRoles,
+		Params:   []apiParam{webhookIdParam},
+		Bodies:   map[string]any{"application/json": replayRequest{}},
+		Status:   202,
+		Response: replayResult{},
+		Errors:   []int{400, 404, 415, 422},
+	},
+	"listEvents": {
+		Summary: "Read the outbox of course events, oldest first",
+		Tag:     "webhooks",
+		Roles:   adminRoles,
+		Params: []apiParam{
+			{Name: "after", In: "query", Description: "only events with a larger seq", Type: "integer"},
+			{Name: "limit", In: "query", Description: fmt.Sprintf("page size, 1 to %d", maxPageLimit), Type: "integer"},
+		},
+		Status:   200,
+		Response: eventPage{},
+		Errors:   []int{400},
+	},
+	"listDeadLetters": {
+		Summary:  "List events that could not be delivered",
+		Tag:      "webhooks",
+		Roles:    adminRoles,
+		Status:   200,
+		Response: deadLetterList{},
+	},
+	"retryDeadLetter": {
+		Summary:  "Deliver a dead letter again",
+		Tag:      "webhooks",
+		Roles:    adminRoles,
+		Params:   []apiParam{deadLetterIdParam},
+		Status:   202,
+		Response: replayResult{},
+		Errors:   []int{404},
+	},
+	"deleteDeadLetter": {
+		Summary: "Drop a dead letter",
+		Tag:     "webhooks",
+		Roles:   adminRoles,
+		Params:  []apiParam{deadLetterIdParam},
+		Status:  204,
+		Errors:  []int{404},
+	},
+}
+
+// openAPIHandler serves the document newRouter built.
+type openAPIHandler struct {
+	body []byte
+}
+
+func (h *openAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(h.body)
+}
+
+func serveDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docsPage)
+}
+
+func serveDocsAsset(w http.ResponseWriter, r *http.Request) {
+	name := "swaggerui/" + mux.Vars(r)["file"]
+	if _, err := fs.Stat(swaggerUI, name); err != nil {
+		notFoundHandler(w, r)
+		return
+	}
+	// the assets only change with a new build
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeFileFS(w, r, swaggerUI, name)
+}
+
+// buildOpenAPI walks the router and returns the OpenAPI 3.1 document.
+// versionedOperations are the operations mounted once per routeVersion.
+var versionedOperations = []string{"listCourses", "searchCourses", "getCourse", "createCourse", "replaceCourse", "patchCourse", "deleteCourse", "listAuthorCourses"}
+
+// withVersionedOperations adds the docs of the routes under /v1 and /v2,
+// made from those of the unprefixed routes, which document v1.
+func withVersionedOperations(ops map[string]apiOperation, policies map[apiVersion]versionPolicy) map[string]apiOperation {
+	out := maps.Clone(ops)
+	v2Types := map[reflect.Type]any{
+		reflect.TypeOf(courseV1{}):        courseV2{},
+		reflect.TypeOf(coursePageV1{}):    coursePageV2{},
+		reflect.TypeOf(searchResultsV1{}): searchResultsV2{},
+	}
+	deprecationHeaders := []string{"Deprecation", "Sunset", "Link"}
+
+	for _, name := range versionedOperations {
+		base := ops[name]
+		base.ResponseHeaders = append(slices.Clone(base.ResponseHeaders), deprecationHeaders...)
+		out[name] = base
+
+		for _, v := range routeVersions[1:] {
+			op := ops[name]
+			op.Params = slices.DeleteFunc(slices.Clone(op.Params), func(p apiParam) bool { return p == acceptVersionParam })
+			if !policies[v.version].deprecated.IsZero() {
+				op.Deprecated = true
+				op.ResponseHeaders = base.ResponseHeaders
+			}
+			if v.version == apiV2 {
+				if t, ok := v2Types[reflect.TypeOf(op.Response)]; ok {
+					op.Response = t
+				}
+				bodies := map[string]any{}
+				for mediaType, body := range op.Bodies {
+					if t, ok := v2Types[reflect.TypeOf(body)]; ok {
+						body = t
+					}
+					bodies[mediaType] = body
+				}
+				op.Bodies = bodies
+			}
+			out[name+v.suffix] = op
+		}
+	}
+	return out
+}
+
+func buildOpenAPI(r *mux.Router, ops map[string]apiOperation) ([]byte, error) {
+	schemas := &schemaBuilder{components: map[string]any{}}
+	paths := map[string]map[string]any{}
+	documented := map[string]bool{}
+	var drift []string
+
+	err := r.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return fmt.Errorf("route %s has no methods", tpl)
+		}
+		name := route.GetName()
+		op, ok := ops[name]
+		if !ok {
+			drift = append(drift, fmt.Sprintf("%s %s (name %q) is not documented", strings.Join(methods, ","), tpl, name))
+			return nil
+		}
+		documented[name] = true
+
+		path, pathParams := openAPIPath(tpl)
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		for _, method := range methods {
+			paths[path][strings.ToLower(method)] = schemas.operation(name, op, pathParams)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for name := range ops {
+		if !documented[name] {
+			drift = append(drift, fmt.Sprintf("operation %q has no route", name))
+		}
+	}
+	if len(drift) > 0 {
+		slices.Sort(drift)
+		return nil, fmt.Errorf("openapi: routes and docs disagree: %s", strings.Join(drift, "; "))
+	}
+
+	schemas.schemaFor(reflect.TypeOf(Problem{}))
+	doc := map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":       "Courses API",
+			"version":     "1.0.0",
+			"description": "Courses of LearnCodeOnline. Errors are RFC 9457 problem details.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas.components,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"cookieAuth": map[string]any{"type": "apiKey", "in": "cookie", "name": sessionCookie},
+			},
+		},
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// openAPIPath turns a mux template like /course/{id:[0-9]+} into
+// /course/{id} and returns the names of its variables.
+func openAPIPath(tpl string) (string, []string) {
+	var b strings.Builder
+	var names []string
+	for {
+		start := strings.IndexByte(tpl, '{')
+		if start < 0 {
+			b.WriteString(tpl)
+			return b.String(), names
+		}
+		end := strings.IndexByte(tpl[start:], '}') + start
+		name, _, _ := strings.Cut(tpl[start+1:end], ":")
+		names = append(names, name)
+		b.WriteString(tpl[:start] + "{" + name + "}")
+		tpl = tpl[end+1:]
+	}
+}
+
+// schemaBuilder turns Go types into JSON Schema (2020-12, the dialect of
+// OpenAPI 3.1). Named structs go to components/schemas and are referenced.
+type schemaBuilder struct {
+	components map[string]any
+}
+
+func (b *schemaBuilder) operation(name string, op apiOperation, pathParams []string) map[string]any {
+	out := map[string]any{"operationId": name, "summary": op.Summary, "tags": []string{op.Tag}}
+	if op.Deprecated {
+		out["deprecated"] = true
+	}
+
+	var params []any
+	for _, p := range pathParams {
+		if !slices.ContainsFunc(op.Params, func(q apiParam) bool { return q.Name == p && q.In == "path" }) {
+			op.Params = append(op.Params, apiParam{Name: p, In: "path", Type: "string"})
+		}
+	}
+	for _, p := range op.Params {
+		param := map[string]any{"name": p.Name, "in": p.In, "schema": map[string]any{"type": p.Type}}
+		if p.Description != "" {
+			param["description"] = p.Description
+		}
+		if p.In == "path" {
+			param["required"] = true
+		}
+		params = append(params, param)
+	}
+	if len(params) > 0 {
+		out["parameters"] = params
+	}
+
+	if len(op.Bodies) > 0 {
+		content := map[string]any{}
+		for mediaType, v := range op.Bodies {
+			for _, mt := range negotiatedTypes(op, mediaType) {
+				content[mt] = map[string]any{"schema": b.schemaFor(reflect.TypeOf(v))}
+			}
+		}
+		out["requestBody"] = map[string]any{"required": true, "content": content}
+	}
+
+	responses := map[string]any{}
+	success := map[string]any{"description": http.StatusText(op.Status)}
+	if op.Response != nil {
+		mediaType := op.ResponseType
+		if mediaType == "" {
+			mediaType = "application/json"
+		}
+		content := map[string]any{}
+		for _, mt := range negotiatedTypes(op, mediaType) {
+			content[mt] = map[string]any{"schema": b.responseSchema(op.Response)}
+		}
+		success["content"] = content
+	}
+	if len(op.Responses) > 0 {
+		content := map[string]any{}
+		for mediaType, v := range op.Responses {
+			content[mediaType] = map[string]any{"schema": b.responseSchema(v)}
+		}
+		success["content"] = content
+	}
+	if len(op.ResponseHeaders) > 0 {
+		headers := map[string]any{}
+		for _, h := range op.ResponseHeaders {
+			headers[h] = map[string]any{"schema": map[string]any{"type": "string"}}
+		}
+		success["headers"] = headers
+	}
+	responses[strconv.Itoa(op.Status)] = success
+
+	errs := op.Errors
+	if op.Roles != nil {
+		security := []any{map[string]any{"bearerAuth": []string{}}, map[string]any{"cookieAuth": []string{}}}
+		out["security"] = security
+		errs = append(errs, 401)
+		if len(op.Roles) > 0 {
+			out["description"] = "Needs one of the roles: " + strings.Join(op.Roles, ", ") + "."
+			errs = append(errs, 403)
+		}
+	}
+	problemTypes := []string{"application/problem+json"}
+	if op.Negotiated {
+		errs = append(errs, 406)
+		problemTypes = append(problemTypes, "application/problem+xml")
+	}
+	for _, status := range errs {
+		if status == http.StatusNotModified {
+			responses["304"] = map[string]any{"description": http.StatusText(status)}
+			continue
+		}
+		content := map[string]any{}
+		for _, mt := range problemTypes {
+			content[mt] = map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/Problem"}}
+		}
+		if body, ok := op.ErrorBodies[status]; ok {
+			content = map[string]any{"application/json": map[string]any{"schema": b.responseSchema(body)}}
+		}
+		responses[strconv.Itoa(status)] = map[string]any{
+			"description": http.StatusText(status),
+			"content":     content,
+		}
+	}
+	out["responses"] = responses
+	return out
+}
+
+// negotiatedTypes is mediaType, plus the other entity formats when op is
+// negotiated and mediaType is JSON.
+func negotiatedTypes(op apiOperation, mediaType string) []string {
+	if !op.Negotiated || mediaType != jsonFormat.mediaType {
+		return []string{mediaType}
+	}
+	return entityMediaTypes()
+}
+
+func (b *schemaBuilder) responseSchema(v any) map[string]any {
+	if alternatives, ok := v.(oneOf); ok {
+		var schemas []any
+		for _, alt := range alternatives {
+			schemas = append(schemas, b.schemaFor(reflect.TypeOf(alt)))
+		}
+		return map[string]any{"oneOf": schemas}
+	}
+	return b.schemaFor(reflect.TypeOf(v))
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+func (b *schemaBuilder) schemaFor(t reflect.Type) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == rawMessageType:
+		// any JSON value
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return b.schemaFor(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": b.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": b.schemaFor(t.Elem())}
+	case reflect.Interface:
+		return map[string]any{}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		// unexported types like coursePage still get a capitalized name
+		name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+		if _, done := b.components[name]; !done {
+			// placeholder first, so a type that contains itself terminates
+			b.components[name] = true
+			b.components[name] = b.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+// rulesFrom maps a DTO to the model whose validate tags hold its rules,
+// matched by JSON name.
+var rulesFrom = map[reflect.Type]reflect.Type{
+	reflect.TypeOf(courseV1{}): reflect.TypeOf(Course{}),
+	reflect.TypeOf(courseV2{}): reflect.TypeOf(Course{}),
+}
+
+// structSchema follows encoding/json for names and omitted fields and
+// the validate tag for constraints. Pointer fields may be null.
+func (b *schemaBuilder) structSchema(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	var required []string
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" && opts == "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		schema := b.schemaFor(field.Type)
+		rules := field.Tag.Get("validate")
+		if model, ok := rulesFrom[t]; ok {
+			rules = fieldRules(model, name)
+		}
+		for _, rule := range strings.Split(rules, ",") {
+			key, value, _ := strings.Cut(rule, "=")
+			n, _ := strconv.Atoi(value)
+			switch {
+			case key == "required":
+				required = append(required, name)
+			case key == "url":
+				schema["description"] = "URL, the scheme may be left out"
+			case key == "min" && field.Type.Kind() == reflect.String:
+				schema["minLength"] = n
+			case key == "max" && field.Type.Kind() == reflect.String:
+				schema["maxLength"] = n
+			case key == "min":
+				schema["minimum"] = n
+			case key == "max":
+				schema["maximum"] = n
+			}
+		}
+		if field.Type.Kind() == reflect.Pointer && field.Type.Elem() != rawMessageType {
+			schema = map[string]any{"anyOf": []any{schema, map[string]any{"type": "null"}}}
+		}
+		properties[name] = schema
+	}
+
+	out := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		out["required"] = required
+	}
+	return out
+}
+
+// fieldRules returns the validate tag of the field of t called name in
+// JSON.
+func fieldRules(t reflect.Type, name string) string {
+	for i := 0; i < t.NumField(); i++ {
+		if jsonName(t.Field(i)) == name {
+			return t.Field(i).Tag.Get("validate")
+		}
+	}
+	return ""
+}
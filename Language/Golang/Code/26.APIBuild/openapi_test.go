@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"mime"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestOpenAPIDrift checks that a route without docs, or docs without a
+// route, stop the spec from being built.
+func TestOpenAPIDrift(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+	router := ts.router
+
+	ops := withVersionedOperations(apiOperations, nil)
+	if _, err := buildOpenAPI(router, ops); err != nil {
+		t.Fatalf("the routes and their docs disagree: %v", err)
+	}
+
+	missing := maps.Clone(ops)
+	delete(missing, "getCourseV2")
+	if _, err := buildOpenAPI(router, missing); err == nil || !strings.Contains(err.Error(), `"getCourseV2"`) {
+		t.Errorf("undocumented route: err = %v", err)
+	}
+
+	extra := maps.Clone(ops)
+	extra["renameCourse"] = apiOperation{Summary: "not routed"}
+	if _, err := buildOpenAPI(router, extra); err == nil || !strings.Contains(err.Error(), `"renameCourse"`) {
+		t.Errorf("operation without a route: err = %v", err)
+	}
+}
+
+// TestOpenAPIMatchesHandlers calls every GET operation in the served spec
+// and checks the handler answers with a documented status and, for JSON,
+// a body that fits the documented schema.
+func TestOpenAPIMatchesHandlers(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+
+	_, raw := ts.do(t, "GET", "/openapi.json", "")
+	var spec map[string]any
+	if err := json.Unmarshal([]byte(raw), &spec); err != nil {
+		t.Fatal(err)
+	}
+
+	paths := spec["paths"].(map[string]any)
+	for _, path := range slices.Sorted(maps.Keys(paths)) {
+		get, ok := paths[path].(map[string]any)["get"].(map[string]any)
+		if !ok {
+			continue
+		}
+		t.Run(path, func(t *testing.T) {
+			res, body := ts.do(t, "GET", examplePath(path), "")
+			responses := get["responses"].(map[string]any)
+			documented, ok := responses[strconv.Itoa(res.StatusCode)].(map[string]any)
+			if !ok {
+				t.Fatalf("status %d is not documented, only %v: %s", res.StatusCode, slices.Sorted(maps.Keys(responses)), body)
+			}
+
+			mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+			content, _ := documented["content"].(map[string]any)
+			if content == nil {
+				return
+			}
+			media, ok := content[mediaType].(map[string]any)
+			if !ok {
+				t.Fatalf("Content-Type %s is not documented, only %v", mediaType, slices.Sorted(maps.Keys(content)))
+			}
+			if !strings.HasSuffix(mediaType, "json") {
+				return
+			}
+			var value any
+			if err := json.Unmarshal([]byte(body), &value); err != nil {
+				t.Fatal(err)
+			}
+			if err := checkSchema(spec, media["schema"].(map[string]any), value, "body"); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+var pathParam = regexp.MustCompile(`\{[^}]+\}`)
+
+// examplePath fills in the path parameters with ids of the seeded data.
+func examplePath(path string) string {
+	id := "2"
+	if strings.HasPrefix(path, "/authors/") {
+		id = "1"
+	}
+	return pathParam.ReplaceAllString(path, id)
+}
+
+// checkSchema is just enough JSON Schema for the documents buildOpenAPI
+// makes: $ref, anyOf, type, properties, required and items.
+func checkSchema(spec, schema map[string]any, value any, at string) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		target, ok := spec["components"].(map[string]any)["schemas"].(map[string]any)[name].(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: unknown schema %s", at, ref)
+		}
+		return checkSchema(spec, target, value, at)
+	}
+	if anyOf, ok := schema["anyOf"].([]any); ok {
+		var errs []string
+		for _, option := range anyOf {
+			err := checkSchema(spec, option.(map[string]any), value, at)
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, err.Error())
+		}
+		return fmt.Errorf("%s: fits none of anyOf: %s", at, strings.Join(errs, "; "))
+	}
+
+	types := schemaTypes(schema["type"])
+	if len(types) == 0 {
+		return nil
+	}
+	switch v := value.(type) {
+	case nil:
+		if !slices.Contains(types, "null") {
+			return fmt.Errorf("%s: null, want %v", at, types)
+		}
+	case bool:
+		if !slices.Contains(types, "boolean") {
+			return fmt.Errorf("%s: boolean, want %v", at, types)
+		}
+	case string:
+		if !slices.Contains(types, "string") {
+			return fmt.Errorf("%s: string, want %v", at, types)
+		}
+	case float64:
+		if !slices.Contains(types, "number") && !(slices.Contains(types, "integer") && v == float64(int64(v))) {
+			return fmt.Errorf("%s: number %v, want %v", at, v, types)
+		}
+	case []any:
+		if !slices.Contains(types, "array") {
+			return fmt.Errorf("%s: array, want %v", at, types)
+		}
+		items, _ := schema["items"].(map[string]any)
+		for i, item := range v {
+			if items == nil {
+				break
+			}
+			if err := checkSchema(spec, items, item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		if !slices.Contains(types, "object") {
+			return fmt.Errorf("%s: object, want %v", at, types)
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		for _, name := range slices.Sorted(maps.Keys(v)) {
+			if properties == nil {
+				break
+			}
+			property, ok := properties[name].(map[string]any)
+			if !ok {
+				return fmt.Errorf("%s: property %q is not documented", at, name)
+			}
+			if err := checkSchema(spec, property, v[name], at+"."+name); err != nil {
+				return err
+			}
+		}
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := v[name.(string)]; !ok {
+				return fmt.Errorf("%s: required property %q is missing", at, name)
+			}
+		}
+	}
+	return nil
+}
+
+func schemaTypes(t any) []string {
+	switch t := t.(type) {
+	case string:
+		return []string{t}
+	case []any:
+		out := make([]string, len(t))
+		for i, name := range t {
+			out[i] = name.(string)
+		}
+		return out
+	}
+	return nil
+}
+
+// TestDocsAssets checks the docs page loads nothing from other origins and
+// its assets are served from the binary.
+func TestDocsAssets(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+
+	if external := regexp.MustCompile(`(?i)(src|href)="(https?:)?//`).FindString(string(docsPage)); external != "" {
+		t.Errorf("docs.html loads %s... from another origin", external)
+	}
+
+	res, body := ts.do(t, "GET", "/docs/VERSION", "")
+	if res.StatusCode != 200 || strings.TrimSpace(body) == "" || res.Header.Get("Cache-Control") == "" {
+		t.Errorf("GET /docs/VERSION: %d %q %v", res.StatusCode, body, res.Header)
+	}
+	for _, path := range []string{"/docs/nope.js", "/docs/..%2Fdocs.html"} {
+		res, body := ts.do(t, "GET", path, "")
+		if res.StatusCode != 404 || res.Header.Get("Content-Type") != "application/problem+json" {
+			t.Errorf("GET %s: %d %s", path, res.StatusCode, body)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestJSONPatchRoot(t *testing.T) {
+	tests := []struct {
+		name    string
+		ops     string
+		want    string
+		wantErr error
+	}{
+		{"replace root", `[{"op":"replace","path":"","value":{"b":2}}]`, `{"b":2}`, nil},
+		{"add root", `[{"op":"add","path":"","value":[1]}]`, `[1]`, nil},
+		{"replace root then member", `[{"op":"replace","path":"","value":{"b":2}},{"op":"replace","path":"/b","value":3}]`, `{"b":3}`, nil},
+		{"remove root", `[{"op":"remove","path":""}]`, ``, ErrInvalidPatch},
+		{"replace missing member", `[{"op":"replace","path":"/c","value":1}]`, ``, ErrInvalidPatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc any = map[string]any{"a": 1.0}
+			var ops []patchOp
+			if err := json.Unmarshal([]byte(tt.ops), &ops); err != nil {
+				t.Fatal(err)
+			}
+			got, err := jsonPatch(doc, ops)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want any
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// Problem is an RFC 7807 error body. Handlers return plain errors and
+// writeProblem turns them into a Problem, so every route reports failures
+// the same way.
+type Problem struct {
+	Type      string           `json:"type" xml:"type"`
+	Title     string           `json:"title" xml:"title"`
+	Status    int              `json:"status" xml:"status"`
+	Detail    string           `json:"detail,omitempty" xml:"detail,omitempty"`
+	Instance  string           `json:"instance,omitempty" xml:"instance,omitempty"`
+	RequestId string           `json:"requestId,omitempty" xml:"requestId,omitempty"`
+	Errors    ValidationErrors `json:"errors,omitempty" xml:"errors>error,omitempty"`
+}
+
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Title + ": " + p.Detail
+	}
+	return p.Title
+}
+
+var ErrUnsupportedMediaType = errors.New("unsupported media type")
+
+// newProblem builds a Problem for one of the statuses below. Type is a
+// relative URI under /problems/, one per kind of failure.
+func newProblem(status int, detail string) *Problem {
+	p := &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+	switch status {
+	case http.StatusBadRequest:
+		p.Type = "/problems/bad-request"
+	case http.StatusUnauthorized:
+		p.Type = "/problems/unauthorized"
+	case http.StatusForbidden:
+		p.Type = "/problems/forbidden"
+	case http.StatusNotFound:
+		p.Type = "/problems/not-found"
+	case http.StatusMethodNotAllowed:
+		p.Type = "/problems/method-not-allowed"
+	case http.StatusNotAcceptable:
+		p.Type = "/problems/not-acceptable"
+	case http.StatusConflict:
+		p.Type = "/problems/conflict"
+	case http.StatusPreconditionFailed:
+		p.Type = "/problems/precondition-failed"
+	case http.StatusRequestEntityTooLarge:
+		p.Type = "/problems/body-too-large"
+	case http.StatusUnsupportedMediaType:
+		p.Type = "/problems/unsupported-media-type"
+	case http.StatusUnprocessableEntity:
+		p.Type = "/problems/validation"
+		p.Title = "Validation failed"
+	}
+	return p
+}
+
+// problemFor maps an error coming out of decoding, validation or a store
+// onto the Problem the client should see. Anything unknown is a 500 and
+// its text stays in the server log.
+func problemFor(err error) *Problem {
+	var p *Problem
+	var verrs ValidationErrors
+	switch {
+	case errors.As(err, &p):
+		return p
+	case errors.As(err, &verrs):
+		p := newProblem(http.StatusUnprocessableEntity, "the request body has invalid fields")
+		p.Errors = verrs
+		return p
+	case errors.Is(err, ErrUnauthenticated), errors.Is(err, ErrInvalidToken), errors.Is(err, ErrBadCredentials):
+		return newProblem(http.StatusUnauthorized, err.Error())
+	case errors.Is(err, ErrForbidden), errors.Is(err, ErrCSRF):
+		return newProblem(http.StatusForbidden, err.Error())
+	case errors.Is(err, ErrCourseNotFound), errors.Is(err, ErrAuthorNotFound),
+		errors.Is(err, ErrSubscriptionNotFound), errors.Is(err, ErrDeadLetterNotFound):
+		return newProblem(http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrCourseExists), errors.Is(err, ErrAuthorExists):
+		return newProblem(http.StatusConflict, err.Error())
+	case errors.Is(err, ErrAuthorHasCourses):
+		return newProblem(http.StatusConflict, "the author still has courses, delete them first or pass cascade=true")
+	case errors.Is(err, ErrUnknownAuthor):
+		p := newProblem(http.StatusUnprocessableEntity, "the request body has invalid fields")
+		p.Errors = ValidationErrors{{Field: "authorId", Message: "does not name an author"}}
+		return p
+	case errors.Is(err, ErrPreconditionFailed), errors.Is(err, ErrVersionMismatch):
+		return newProblem(http.StatusPreconditionFailed, err.Error())
+	case errors.Is(err, ErrBodyTooLarge), errors.Is(err, ErrImportTooLarge):
+		return newProblem(http.StatusRequestEntityTooLarge, err.Error())
+	case errors.Is(err, ErrUnsupportedMediaType):
+		return newProblem(http.StatusUnsupportedMediaType, err.Error())
+	case errors.Is(err, ErrNotAcceptable):
+		return newProblem(http.StatusNotAcceptable, err.Error())
+	case errors.Is(err, ErrEmptyBody), errors.Is(err, ErrMalformedJSON), errors.Is(err, ErrMalformedBody),
+		errors.Is(err, ErrUnsupportedVersion):
+		return newProblem(http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrPatchTestFailed):
+		return newProblem(http.StatusConflict, err.Error())
+	case errors.Is(err, ErrIdempotencyInFlight):
+		return newProblem(http.StatusConflict, err.Error())
+	case errors.Is(err, ErrIdempotencyKeyReused):
+		p := newProblem(http.StatusUnprocessableEntity, err.Error())
+		p.Type = "/problems/idempotency-key-reused"
+		p.Title = "Idempotency-Key reused"
+		return p
+	case errors.Is(err, ErrInvalidPatch):
+		p := newProblem(http.StatusUnprocessableEntity, err.Error())
+		p.Type = "/problems/invalid-patch"
+		p.Title = "Patch can not be applied"
+		return p
+	}
+
+	slog.Error("internal error", "error", err)
+	return newProblem(http.StatusInternalServerError, "")
+}
+
+// writeProblem answers the request with err as application/problem+json,
+// or as application/problem+xml to a client that negotiated XML. The
+// binary formats get JSON, which every client can read.
+func writeProblem(w http.ResponseWriter, r *http.Request, err error) {
+	p := *problemFor(err)
+	p.Instance = r.URL.Path
+	p.RequestId = requestIdFromContext(r.Context())
+
+	if formatFromContext(r.Context()).mediaType == "application/xml" {
+		w.Header().Set("Content-Type", "application/problem+xml")
+		w.WriteHeader(p.Status)
+		io.WriteString(w, xml.Header)
+		xml.NewEncoder(w).EncodeElement(p, xml.StartElement{Name: xml.Name{Space: "urn:ietf:rfc:7807", Local: "problem"}})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, newProblem(http.StatusNotFound, "no route for "+r.URL.Path))
+}
+
+func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, newProblem(http.StatusMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path))
+}
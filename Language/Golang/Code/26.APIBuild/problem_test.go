@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestProblemFor(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		typ    string
+	}{
+		{ValidationErrors{{Field: "courseName", Message: "is required"}}, http.StatusUnprocessableEntity, "/problems/validation"},
+		{fmt.Errorf("load: %w", ErrCourseNotFound), http.StatusNotFound, "/problems/not-found"},
+		{ErrCourseExists, http.StatusConflict, "/problems/conflict"},
+		{ErrUnknownAuthor, http.StatusUnprocessableEntity, "/problems/validation"},
+		{ErrVersionMismatch, http.StatusPreconditionFailed, "/problems/precondition-failed"},
+		{ErrBodyTooLarge, http.StatusRequestEntityTooLarge, "/problems/body-too-large"},
+		{fmt.Errorf("%w: send JSON", ErrUnsupportedMediaType), http.StatusUnsupportedMediaType, "/problems/unsupported-media-type"},
+		{ErrMalformedJSON, http.StatusBadRequest, "/problems/bad-request"},
+		{ErrUnauthenticated, http.StatusUnauthorized, "/problems/unauthorized"},
+		{ErrForbidden, http.StatusForbidden, "/problems/forbidden"},
+		{ErrIdempotencyKeyReused, http.StatusUnprocessableEntity, "/problems/idempotency-key-reused"},
+		{newProblem(http.StatusTeapot, "short and stout"), http.StatusTeapot, "about:blank"},
+	}
+	for _, tt := range tests {
+		p := problemFor(tt.err)
+		if p.Status != tt.status || p.Type != tt.typ || p.Title == "" {
+			t.Errorf("problemFor(%v) = %d %s %q, want %d %s", tt.err, p.Status, p.Type, p.Title, tt.status, tt.typ)
+		}
+	}
+
+	// the text of an unknown error stays in the log
+	p := problemFor(errors.New("dial tcp 10.0.0.7:5432: connection refused"))
+	if p.Status != http.StatusInternalServerError || p.Detail != "" {
+		t.Errorf("unknown error = %+v, want a 500 without detail", p)
+	}
+}
+
+func TestProblemResponses(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+
+	tests := []struct {
+		method, path string
+		header       []string
+		status       int
+		contentType  string
+	}{
+		{"GET", "/course/nope", nil, http.StatusNotFound, "application/problem+json"},
+		{"GET", "/no/such/route", nil, http.StatusNotFound, "application/problem+json"},
+		{"PUT", "/courses", nil, http.StatusMethodNotAllowed, "application/problem+json"},
+		{"GET", "/course/nope", []string{"Accept", "application/xml"}, http.StatusNotFound, "application/problem+xml"},
+		// binary formats get JSON problems
+		{"GET", "/course/nope", []string{"Accept", "application/cbor"}, http.StatusNotFound, "application/problem+json"},
+	}
+	for _, tt := range tests {
+		header := append([]string{"X-Request-ID", "req-1"}, tt.header...)
+		res, body := ts.do(t, tt.method, tt.path, "", header...)
+		if res.StatusCode != tt.status || res.Header.Get("Content-Type") != tt.contentType {
+			t.Errorf("%s %s: %d %s, want %d %s", tt.method, tt.path, res.StatusCode, res.Header.Get("Content-Type"), tt.status, tt.contentType)
+			continue
+		}
+		var p Problem
+		var err error
+		if strings.HasSuffix(tt.contentType, "xml") {
+			err = xml.Unmarshal([]byte(body), &p)
+		} else {
+			err = json.Unmarshal([]byte(body), &p)
+		}
+		if err != nil {
+			t.Fatalf("%s %s: %v: %s", tt.method, tt.path, err, body)
+		}
+		if p.Status != tt.status || p.Instance != tt.path || p.RequestId != "req-1" {
+			t.Errorf("%s %s: problem %+v, want status %d, instance and request id", tt.method, tt.path, p, tt.status)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// coursePage is the envelope GET /courses answers with.
+type coursePage struct {
+	Courses    []Course `json:"courses" xml:"courses>course"`
+	NextCursor string   `json:"next_cursor,omitempty" xml:"next_cursor,omitempty"`
+	Total      int      `json:"total" xml:"total"`
+}
+
+type sortField struct {
+	name string
+	desc bool
+}
+
+// courseQuery is the parsed form of ?limit=&cursor=&sort=&coursePrice.gte=
+// &coursePrice.lte=&author.fullName=&authorId=&courseSite=&expand=
+type courseQuery struct {
+	limit        int
+	cursor       *pageCursor
+	sort         []sortField
+	minPrice     *int
+	maxPrice     *int
+	author       string
+	authorId     string
+	site         string
+	expandAuthor bool
+}
+
+// pageCursor remembers the last course of a page. The next page starts
+// right after it in sort order, so inserts and deletes between two
+// requests do not make a client skip or repeat courses.
+type pageCursor struct {
+	Sort   string `json:"s"`
+	Id     string `json:"i"`
+	Name   string `json:"n,omitempty"`
+	Site   string `json:"w,omitempty"`
+	Author string `json:"a,omitempty"`
+	Price  int    `json:"p,omitempty"`
+}
+
+// courseComparers are the fields a client may sort on.
+var courseComparers = map[string]func(a, b Course) int{
+	"courseId": func(a, b Course) int {
+		return cmp.Compare(a.CourseId, b.CourseId)
+	},
+	"courseName": func(a, b Course) int {
+		return cmp.Compare(a.CourseName, b.CourseName)
+	},
+	"coursePrice": func(a, b Course) int {
+		return cmp.Compare(a.CoursePrice, b.CoursePrice)
+	},
+	"courseSite": func(a, b Course) int {
+		return cmp.Compare(a.CourseSite, b.CourseSite)
+	},
+	"author.fullName": func(a, b Course) int {
+		return cmp.Compare(authorName(a), authorName(b))
+	},
+}
+
+func authorName(c Course) string {
+	if c.Author == nil {
+		return ""
+	}
+	return c.Author.Fullname
+}
+
+func parseCourseQuery(values url.Values) (courseQuery, error) {
+	q := courseQuery{limit: defaultPageLimit}
+
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+		q.limit = limit
+	}
+
+	sortParam := values.Get("sort")
+	if sortParam != "" {
+		for _, name := range strings.Split(sortParam, ",") {
+			field := sortField{name: name}
+			if strings.HasPrefix(name, "-") {
+				field = sortField{name: name[1:], desc: true}
+			}
+			if _, ok := courseComparers[field.name]; !ok {
+				return q, fmt.Errorf("can not sort on %q", field.name)
+			}
+			q.sort = append(q.sort, field)
+		}
+	}
+
+	if v := values.Get("cursor"); v != "" {
+		cursor, err := decodeCursor(v)
+		if err != nil || cursor.Sort != sortParam {
+			return q, fmt.Errorf("invalid cursor")
+		}
+		q.cursor = &cursor
+	}
+
+	var err error
+	if q.minPrice, err = intParam(values, "coursePrice.gte"); err != nil {
+		return q, err
+	}
+	if q.maxPrice, err = intParam(values, "coursePrice.lte"); err != nil {
+		return q, err
+	}
+	q.author = values.Get("author.fullName")
+	q.authorId = values.Get("authorId")
+	q.site = values.Get("courseSite")
+	if q.expandAuthor, err = parseExpand(values); err != nil {
+		return q, err
+	}
+
+	return q, nil
+}
+
+// parseExpand reads ?expand=author, the only relation a course has.
+func parseExpand(values url.Values) (bool, error) {
+	expand := false
+	for _, v := range values["expand"] {
+		for _, name := range strings.Split(v, ",") {
+			if name != "author" {
+				return false, fmt.Errorf("can not expand %q", name)
+			}
+			expand = true
+		}
+	}
+	return expand, nil
+}
+
+func intParam(values url.Values, name string) (*int, error) {
+	v := values.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a number", name)
+	}
+	return &n, nil
+}
+
+func (q courseQuery) matches(c Course) bool {
+	if q.minPrice != nil && c.CoursePrice < *q.minPrice {
+		return false
+	}
+	if q.maxPrice != nil && c.CoursePrice > *q.maxPrice {
+		return false
+	}
+	if q.author != "" && !strings.EqualFold(authorName(c), q.author) {
+		return false
+	}
+	if q.authorId != "" && c.AuthorId != q.authorId {
+		return false
+	}
+	if q.site != "" && !strings.EqualFold(c.CourseSite, q.site) {
+		return false
+	}
+	return true
+}
+
+// compare orders two courses by the requested fields and then by CourseId,
+// so the order is total and a cursor always points at one place. Without
+// ?sort= that is courseId order, which is not creation order: the seeded
+// ids and UUIDv7 and ULID ids do not sort among each other by time.
+func (q courseQuery) compare(a, b Course) int {
+	for _, field := range q.sort {
+		c := courseComparers[field.name](a, b)
+		if field.desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(a.CourseId, b.CourseId)
+}
+
+// apply filters, sorts and cuts one page out of courses.
+func (q courseQuery) apply(courses []Course) coursePage {
+	matched := make([]Course, 0, len(courses))
+	for _, course := range courses {
+		if q.matches(course) {
+			matched = append(matched, course)
+		}
+	}
+
+	slices.SortFunc(matched, q.compare)
+
+	start := 0
+	if q.cursor != nil {
+		last := q.cursor.course()
+		start, _ = slices.BinarySearchFunc(matched, last, q.compare)
+		if start < len(matched) && q.compare(matched[start], last) == 0 {
+			start++
+		}
+	}
+
+	end := start + q.limit
+	if end > len(matched) {
+		end = len(matched)
+	}
+
+	page := coursePage{Courses: matched[start:end], Total: len(matched)}
+	if end < len(matched) {
+		page.NextCursor = encodeCursor(q.sortParam(), matched[end-1])
+	}
+	if !q.expandAuthor {
+		// the authors were only joined in to filter and sort on their names
+		for i := range page.Courses {
+			page.Courses[i].Author = nil
+		}
+	}
+	return page
+}
+
+func (q courseQuery) sortParam() string {
+	names := make([]string, len(q.sort))
+	for i, field := range q.sort {
+		names[i] = field.name
+		if field.desc {
+			names[i] = "-" + field.name
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+func (c pageCursor) course() Course {
+	return Course{
+		CourseId:    c.Id,
+		CourseName:  c.Name,
+		CourseSite:  c.Site,
+		CoursePrice: c.Price,
+		Author:      &Author{Fullname: c.Author},
+	}
+}
+
+func encodeCursor(sort string, last Course) string {
+	cursor := pageCursor{
+		Sort:   sort,
+		Id:     last.CourseId,
+		Name:   last.CourseName,
+		Site:   last.CourseSite,
+		Author: authorName(last),
+		Price:  last.CoursePrice,
+	}
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s string) (pageCursor, error) {
+	var cursor pageCursor
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(raw, &cursor)
+	return cursor, err
+}
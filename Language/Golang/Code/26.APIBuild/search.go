@@ -0,0 +1,500 @@
+package main
+
+import (
+	"cmp"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The search index is an in-process inverted index over courseName,
+// courseSite and the author's name. Every term of the query has to match,
+// either exactly, as a prefix (so "reac" finds "react") or within a small
+// edit distance (so "recat" does too). Hits are ranked with BM25 and the
+// weaker matches count for less.
+
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+
+	prefixMatchWeight = 0.7
+	typoMatchWeight   = 0.5
+
+	snippetRadius = 60 // bytes of context kept around the first highlight
+
+	maxQueryLength = 200
+)
+
+// searchCourses answers GET /courses/search?q=&limit=&offset=. Results are
+// ranked, so they are paged by offset rather than by cursor. Like the other
+// course routes it answers in the negotiated format and version.
+func (s *server) searchCourses(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	q := strings.TrimSpace(values.Get("q"))
+	if len(tokenize(q)) == 0 {
+		writeProblem(w, r, newProblem(http.StatusBadRequest, "q must contain at least one word"))
+		return
+	}
+	if len(q) > maxQueryLength {
+		writeProblem(w, r, newProblem(http.StatusBadRequest, fmt.Sprintf("q must be at most %d bytes", maxQueryLength)))
+		return
+	}
+
+	limit, offset := defaultPageLimit, 0
+	if v := values.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxPageLimit {
+			writeProblem(w, r, newProblem(http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxPageLimit)))
+			return
+		}
+	}
+	if v := values.Get("offset"); v != "" {
+		var err error
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			writeProblem(w, r, newProblem(http.StatusBadRequest, "offset must be a number of at least 0"))
+			return
+		}
+	}
+
+	hits, total := s.search.search(q, offset, limit)
+	writeEntity(w, r, http.StatusOK, searchResultsFor(r.Context(), hits, total))
+}
+
+type searchField struct {
+	name   string
+	weight float64
+	text   func(c Course, authorName string) string
+}
+
+// searchFields are the indexed fields. A term in the name counts more than
+// one in the site.
+var searchFields = []searchField{
+	{"courseName", 2, func(c Course, _ string) string { return c.CourseName }},
+	{"author.fullName", 1.5, func(_ Course, authorName string) string { return authorName }},
+	{"courseSite", 1, func(c Course, _ string) string { return c.CourseSite }},
+}
+
+type searchDoc struct {
+	course     Course
+	authorName string
+	length     float64
+	terms      map[string]float64 // term to its field weighted frequency
+}
+
+// searchIndex is safe for concurrent use. Writers replace a course's
+// postings as a whole, so a reader never sees half a document.
+type searchIndex struct {
+	mu       sync.RWMutex
+	docs     map[string]*searchDoc
+	postings map[string]map[string]float64 // term to course id to frequency
+	grams    map[string]map[string]bool    // bigram to the terms that have it
+	totalLen float64
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		docs:     make(map[string]*searchDoc),
+		postings: make(map[string]map[string]float64),
+		grams:    make(map[string]map[string]bool),
+	}
+}
+
+// put adds or replaces a course.
+func (idx *searchIndex) put(course Course, authorName string) {
+	course.Author = nil // the name is indexed, the rest comes from the store
+	doc := &searchDoc{course: course, authorName: authorName, terms: make(map[string]float64)}
+	for _, field := range searchFields {
+		for _, tok := range tokenize(field.text(course, authorName)) {
+			doc.terms[tok.term] += field.weight
+			doc.length++
+		}
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(course.CourseId)
+	idx.docs[course.CourseId] = doc
+	idx.totalLen += doc.length
+	for term, tf := range doc.terms {
+		if idx.postings[term] == nil {
+			idx.postings[term] = make(map[string]float64)
+			for _, gram := range termGrams(term) {
+				if idx.grams[gram] == nil {
+					idx.grams[gram] = make(map[string]bool)
+				}
+				idx.grams[gram][term] = true
+			}
+		}
+		idx.postings[term][course.CourseId] = tf
+	}
+}
+
+func (idx *searchIndex) remove(courseId string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(courseId)
+}
+
+// renameAuthor reindexes the courses of an author under a new name.
+func (idx *searchIndex) renameAuthor(authorId, name string) {
+	idx.mu.RLock()
+	var courses []Course
+	for _, doc := range idx.docs {
+		if doc.course.AuthorId == authorId {
+			courses = append(courses, doc.course)
+		}
+	}
+	idx.mu.RUnlock()
+
+	for _, course := range courses {
+		idx.put(course, name)
+	}
+}
+
+func (idx *searchIndex) removeLocked(courseId string) {
+	doc, ok := idx.docs[courseId]
+	if !ok {
+		return
+	}
+	for term := range doc.terms {
+		delete(idx.postings[term], courseId)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+			for _, gram := range termGrams(term) {
+				delete(idx.grams[gram], term)
+				if len(idx.grams[gram]) == 0 {
+					delete(idx.grams, gram)
+				}
+			}
+		}
+	}
+	idx.totalLen -= doc.length
+	delete(idx.docs, courseId)
+}
+
+// searchHit is one result of GET /courses/search. Highlights holds the
+// matched fields as HTML, escaped, with the matches in <mark> tags.
+type searchHit struct {
+	Course     Course
+	Score      float64
+	Highlights fieldHighlights
+}
+
+// fieldHighlights maps a field name to its highlighted HTML.
+type fieldHighlights map[string]string
+
+// MarshalXML writes one <highlight field="..."> per field, encoding/xml
+// has no way to write a map.
+func (h fieldHighlights) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, field := range slices.Sorted(maps.Keys(h)) {
+		el := xml.StartElement{
+			Name: xml.Name{Local: "highlight"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "field"}, Value: field}},
+		}
+		if err := e.EncodeElement(h[field], el); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// search returns every course that matches all terms of the query, best
+// first, and the number of them.
+func (idx *searchIndex) search(query string, offset, limit int) ([]searchHit, int) {
+	var queryTerms []string
+	for _, tok := range tokenize(query) {
+		if !slices.Contains(queryTerms, tok.term) {
+			queryTerms = append(queryTerms, tok.term)
+		}
+	}
+	if len(queryTerms) == 0 {
+		return nil, 0
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	n := float64(len(idx.docs))
+	avgLen := idx.totalLen / math.Max(n, 1)
+
+	scores := make(map[string]float64)
+	matchedTerms := make(map[string][]string) // course id to the index terms it matched
+	for i, qt := range queryTerms {
+		termScores := make(map[string]float64)
+		for term, weight := range idx.expand(qt) {
+			postings := idx.postings[term]
+			df := float64(len(postings))
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			for id, tf := range postings {
+				doc := idx.docs[id]
+				norm := tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*doc.length/avgLen))
+				// a term matched several ways only counts its best match
+				termScores[id] = math.Max(termScores[id], weight*idf*norm)
+				matchedTerms[id] = append(matchedTerms[id], term)
+			}
+		}
+
+		// every query term has to match
+		if i == 0 {
+			for id, score := range termScores {
+				scores[id] = score
+			}
+			continue
+		}
+		for id := range scores {
+			if score, ok := termScores[id]; ok {
+				scores[id] += score
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	ids := make([]string, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	slices.SortFunc(ids, func(a, b string) int {
+		if c := cmp.Compare(scores[b], scores[a]); c != 0 {
+			return c
+		}
+		return cmp.Compare(a, b)
+	})
+
+	total := len(ids)
+	if offset > len(ids) {
+		offset = len(ids)
+	}
+	ids = ids[offset:]
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	hits := make([]searchHit, len(ids))
+	for i, id := range ids {
+		doc := idx.docs[id]
+		hits[i] = searchHit{
+			Course:     doc.course.clone(),
+			Score:      math.Round(scores[id]*1000) / 1000,
+			Highlights: highlights(doc, matchedTerms[id]),
+		}
+	}
+	return hits, total
+}
+
+// expand maps a query term to the index terms it matches and how much
+// each match is worth. Must be called with idx.mu held.
+//
+// Only terms that share enough bigrams with the query term are looked at.
+// A term starting with the query term has all of its bigrams but the last.
+// One typo loses at most three (swapping neighbours breaks the bigrams
+// before, between and after them), so a term within maxTypos shares all
+// but 3*maxTypos of them and is at most maxTypos runes longer or shorter.
+func (idx *searchIndex) expand(queryTerm string) map[string]float64 {
+	out := make(map[string]float64)
+	if _, ok := idx.postings[queryTerm]; ok {
+		out[queryTerm] = 1
+	}
+
+	queryGrams := termGrams(queryTerm)
+	shared := make(map[string]int)
+	for _, gram := range queryGrams {
+		for term := range idx.grams[gram] {
+			shared[term]++
+		}
+	}
+
+	queryLen := utf8.RuneCountInString(queryTerm)
+	maxTypos := allowedTypos(queryTerm)
+	minShared := len(queryGrams) - 3*maxTypos
+	for term, n := range shared {
+		switch {
+		case term == queryTerm:
+		case queryLen >= 2 && n >= len(queryGrams)-1 && strings.HasPrefix(term, queryTerm):
+			out[term] = prefixMatchWeight
+		case maxTypos > 0 && n >= minShared && abs(utf8.RuneCountInString(term)-queryLen) <= maxTypos &&
+			editDistance(queryTerm, term, maxTypos) <= maxTypos:
+			out[term] = typoMatchWeight
+		}
+	}
+	if maxTypos > 0 && minShared <= 0 {
+		// a term like "aaaa" has too few distinct bigrams to rule anything out
+		for term := range idx.postings {
+			if _, seen := shared[term]; !seen && term != queryTerm &&
+				abs(utf8.RuneCountInString(term)-queryLen) <= maxTypos && editDistance(queryTerm, term, maxTypos) <= maxTypos {
+				out[term] = typoMatchWeight
+			}
+		}
+	}
+	return out
+}
+
+// termGrams returns the distinct bigrams of a term padded with ^ and $,
+// which tokenize never leaves in a term: "go" has "^g", "go" and "o$".
+func termGrams(term string) []string {
+	runes := []rune("^" + term + "$")
+	grams := make([]string, 0, len(runes)-1)
+	for i := 0; i+1 < len(runes); i++ {
+		gram := string(runes[i : i+2])
+		if !slices.Contains(grams, gram) {
+			grams = append(grams, gram)
+		}
+	}
+	return grams
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// allowedTypos grows with the term, short words have too many neighbours.
+func allowedTypos(term string) int {
+	switch n := utf8.RuneCountInString(term); {
+	case n >= 8:
+		return 2
+	case n >= 4:
+		return 1
+	}
+	return 0
+}
+
+// editDistance is the optimal string alignment distance (Levenshtein plus
+// swapping two neighbours) between a and b. It gives up early and returns
+// max+1 once the distance is known to be above max.
+func editDistance(a, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > max || -d > max {
+		return max + 1
+	}
+
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = minInt(cur[j], prev2[j-2]+1)
+			}
+			rowMin = minInt(rowMin, cur[j])
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
+
+func minInt(first int, rest ...int) int {
+	for _, v := range rest {
+		if v < first {
+			first = v
+		}
+	}
+	return first
+}
+
+type token struct {
+	term       string
+	start, end int // byte offsets in the original text
+}
+
+// tokenize splits on anything that is not a letter or digit and
+// lowercases, so "lco.dev" is "lco" and "dev".
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case word && start < 0:
+			start = i
+		case !word && start >= 0:
+			tokens = append(tokens, token{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{strings.ToLower(text[start:]), start, len(text)})
+	}
+	return tokens
+}
+
+// highlights marks the matched terms in every field that has one. Long
+// fields are cut down to the text around the first match.
+func highlights(doc *searchDoc, matched []string) fieldHighlights {
+	out := make(map[string]string)
+	for _, field := range searchFields {
+		text := field.text(doc.course, doc.authorName)
+		var marks []token
+		for _, tok := range tokenize(text) {
+			if slices.Contains(matched, tok.term) {
+				marks = append(marks, tok)
+			}
+		}
+		if len(marks) > 0 {
+			out[field.name] = snippet(text, marks)
+		}
+	}
+	return out
+}
+
+func snippet(text string, marks []token) string {
+	from, to := 0, len(text)
+	if marks[0].start > snippetRadius {
+		from = marks[0].start - snippetRadius
+		for !utf8.RuneStart(text[from]) {
+			from++
+		}
+	}
+	if marks[0].end+snippetRadius < len(text) {
+		to = marks[0].end + snippetRadius
+		for to < len(text) && !utf8.RuneStart(text[to]) {
+			to++
+		}
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := from
+	for _, m := range marks {
+		if m.start < from || m.end > to {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[pos:m.start]))
+		b.WriteString("<mark>" + html.EscapeString(text[m.start:m.end]) + "</mark>")
+		pos = m.end
+	}
+	b.WriteString(html.EscapeString(text[pos:to]))
+	if to < len(text) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
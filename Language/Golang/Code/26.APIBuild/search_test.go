@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSearchVersionsAndFormats(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+
+	res, body := ts.do(t, "GET", "/courses/search?q=react", "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("v1: %d %s", res.StatusCode, body)
+	}
+	var v1 searchResultsV1
+	if err := json.Unmarshal([]byte(body), &v1); err != nil {
+		t.Fatal(err)
+	}
+	if v1.Total != 1 || v1.Results[0].Course.CoursePrice != 299 {
+		t.Errorf("v1 = %+v, want ReactJS at 299", v1)
+	}
+	if got := v1.Results[0].Highlights["courseName"]; got != "<mark>ReactJS</mark>" {
+		t.Errorf("highlight = %q", got)
+	}
+
+	for _, path := range []string{"/v2/courses/search?q=react", "/courses/search?q=react"} {
+		res, body = ts.do(t, "GET", path, "", "Accept-Version", "2")
+		var v2 searchResultsV2
+		if err := json.Unmarshal([]byte(body), &v2); err != nil {
+			t.Fatalf("%s: %v: %s", path, err, body)
+		}
+		if v2.Total != 1 || v2.Results[0].Course.Price != (money{Amount: 29900, Currency: catalogCurrency}) {
+			t.Errorf("%s = %+v, want ReactJS at 29900 USD cents", path, v2)
+		}
+	}
+
+	res, body = ts.do(t, "GET", "/v2/courses/search?q=react", "", "Accept", "application/xml")
+	if ct := res.Header.Get("Content-Type"); ct != "application/xml" {
+		t.Fatalf("Content-Type = %q: %s", ct, body)
+	}
+	var doc struct {
+		Results []struct {
+			Amount     int64 `xml:"course>price>amount"`
+			Highlights []struct {
+				Field string `xml:"field,attr"`
+				HTML  string `xml:",chardata"`
+			} `xml:"highlights>highlight"`
+		} `xml:"results>result"`
+	}
+	if err := xml.Unmarshal([]byte(body), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Results) != 1 || doc.Results[0].Amount != 29900 ||
+		len(doc.Results[0].Highlights) != 1 || doc.Results[0].Highlights[0].Field != "courseName" {
+		t.Errorf("xml = %+v: %s", doc, body)
+	}
+
+	if res, _ := ts.do(t, "GET", "/courses/search?q=react", "", "Accept", "text/csv"); res.StatusCode != http.StatusNotAcceptable {
+		t.Errorf("text/csv: %d, want 406", res.StatusCode)
+	}
+}
+
+// TestExpandCandidates checks the bigram filter in expand against trying
+// every term in the index, on made up words that are near each other.
+func TestExpandCandidates(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	word := func() string {
+		const letters = "abcdeé"
+		n := 1 + rng.IntN(10)
+		var b strings.Builder
+		for range n {
+			b.WriteRune([]rune(letters)[rng.IntN(len([]rune(letters)))])
+		}
+		return b.String()
+	}
+
+	idx := newSearchIndex()
+	for i := range 300 {
+		idx.put(Course{CourseId: strconv.Itoa(i), CourseName: word() + " " + word()}, "")
+	}
+	// removed courses take their terms out of the bigram index too
+	for i := range 100 {
+		idx.remove(strconv.Itoa(i))
+	}
+	for gram, terms := range idx.grams {
+		for term := range terms {
+			if idx.postings[term] == nil {
+				t.Fatalf("bigram %q still lists the removed term %q", gram, term)
+			}
+		}
+	}
+
+	queries := []string{"aaaa", "aaaaaaaa", "abab", "éé"}
+	for range 500 {
+		queries = append(queries, word())
+	}
+	for _, q := range queries {
+		want := make(map[string]float64)
+		maxTypos := allowedTypos(q)
+		for term := range idx.postings {
+			switch {
+			case term == q:
+				want[term] = 1
+			case utf8.RuneCountInString(q) >= 2 && strings.HasPrefix(term, q):
+				want[term] = prefixMatchWeight
+			case maxTypos > 0 && editDistance(q, term, maxTypos) <= maxTypos:
+				want[term] = typoMatchWeight
+			}
+		}
+		if got := idx.expand(q); !maps.Equal(got, want) {
+			t.Errorf("expand(%q) = %v, want %v", q, got, want)
+		}
+	}
+}
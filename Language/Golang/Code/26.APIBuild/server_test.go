@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// testServer is the API wired the way main wires it, with a memory cache
+// and no background dispatcher, behind an httptest.Server.
+type testServer struct {
+	*httptest.Server
+	// token may write courses
+	token    string
+	tracer   *Tracer
+	router   *mux.Router
+	auth     *authService
+	webhooks *dispatcher
+}
+
+// testV1Policy deprecates v1 on the test server, so the headers can be
+// checked.
+var testV1Policy = versionPolicy{
+	deprecated: time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC),
+	sunset:     time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC),
+}
+
+func newTestServer(t *testing.T, store CourseStore) *testServer {
+	return newTracedTestServer(t, store, nil)
+}
+
+// newTracedTestServer is newTestServer with spans going to exporter.
+func newTracedTestServer(t *testing.T, store CourseStore, exporter SpanExporter) *testServer {
+	t.Helper()
+	ctx := context.Background()
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	if err := seedCourses(store); err != nil {
+		t.Fatal(err)
+	}
+	indexed, err := newIndexedStore(ctx, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := newKeySet("", "")
+	keys.AddHMAC("test", []byte("test secret"))
+	if err := keys.UseForSigning("test"); err != nil {
+		t.Fatal(err)
+	}
+	token, err := keys.Sign(Claims{Subject: "tester", Role: "admin", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracer := newTracer(exporter, 1)
+	metrics := newAPIMetrics(store)
+	cacheStore := newMemoryCacheStore()
+	s := &server{
+		store:   newTracedStore(newInvalidatingStore(indexed, cacheStore, courseGenTTL(time.Minute, time.Minute), logger), tracer, "memory"),
+		ids:     &uuidV7Generator{now: time.Now},
+		logger:  logger,
+		metrics: metrics,
+		tracer:  tracer,
+		search:  indexed.index,
+		auth: &authService{
+			keys:       keys,
+			store:      newMemoryAuthStore(),
+			users:      &userDirectory{users: map[string]user{}},
+			accessTTL:  time.Minute,
+			refreshTTL: time.Hour,
+			sessionTTL: time.Hour,
+			now:        time.Now,
+		},
+		idempotency: &idempotency{store: newMemoryIdempotencyStore(), ttl: time.Hour},
+		cache: &responseCache{
+			store:    cacheStore,
+			ttl:      time.Minute,
+			staleFor: time.Minute,
+			logger:   logger,
+			lookups:  metrics.cacheLookups,
+		},
+		health:          &health{checks: map[string]func(context.Context) error{}},
+		webhooks:        newDispatcher(store, newMemoryWebhookStore(), webhookConfig{}, tracer, logger, metrics.webhookDeliveries),
+		versionPolicies: map[apiVersion]versionPolicy{apiV1: testV1Policy},
+	}
+
+	router, err := newRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := &testServer{Server: httptest.NewServer(s.withMiddleware(router)), token: token, tracer: tracer, router: router, auth: s.auth, webhooks: s.webhooks}
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// do sends a request with the writer token and returns the response with
+// its body read. header is name, value pairs.
+func (ts *testServer) do(t *testing.T, method, path, body string, header ...string) (*http.Response, string) {
+	t.Helper()
+	res, raw, err := ts.send(method, path, body, header...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, raw
+}
+
+// send is do for goroutines other than the test's, which may not call
+// t.Fatal.
+func (ts *testServer) send(method, path, body string, header ...string) (*http.Response, string, error) {
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+ts.token)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	raw, err := io.ReadAll(res.Body)
+	return res, string(raw), err
+}
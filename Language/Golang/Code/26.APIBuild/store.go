@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// CourseStore is everything the handlers need from a storage backend.
+// The handlers only talk to this interface, so a backend can be swapped
+// at startup (memory or file) or in tests.
+//
+// Every course carries a Version that the store owns: Create sets it to 1
+// and each Update bumps it. Update and Delete take the version the caller
+// last saw and fail with ErrVersionMismatch if the course changed since;
+// a version of 0 skips that check. Authors are versioned the same way.
+//
+// Authors live in the same store because courses point at them by
+// AuthorId: a course can only be written with an author that exists
+// (ErrUnknownAuthor), and an author with courses is only deleted when the
+// caller asks for a cascade (ErrAuthorHasCourses). One store can check
+// that under one lock.
+//
+// Every course change also records an Event in the store's outbox, in the
+// same step as the change itself: either both happen or neither does.
+// Events reads the outbox back for whoever publishes the events.
+type CourseStore interface {
+	List(ctx context.Context) ([]Course, error)
+	// Count is len(List) without copying the courses.
+	Count(ctx context.Context) (int, error)
+	Get(ctx context.Context, id string) (Course, error)
+	Create(ctx context.Context, course Course) (Course, error)
+	Update(ctx context.Context, course Course) (Course, error)
+	Delete(ctx context.Context, id string, version int64) error
+
+	ListAuthors(ctx context.Context) ([]Author, error)
+	CountAuthors(ctx context.Context) (int, error)
+	GetAuthor(ctx context.Context, id string) (Author, error)
+	// GetAuthors looks up many authors in one call, for batched loads.
+	// Ids without an author are left out of the map.
+	GetAuthors(ctx context.Context, ids []string) (map[string]Author, error)
+	CreateAuthor(ctx context.Context, author Author) (Author, error)
+	UpdateAuthor(ctx context.Context, author Author) (Author, error)
+	// DeleteAuthor returns the ids of the courses a cascade deleted.
+	DeleteAuthor(ctx context.Context, id string, version int64, cascade bool) ([]string, error)
+
+	// Events returns up to limit events with a Seq above after, oldest
+	// first. Only the newest maxOutboxEvents are kept.
+	Events(ctx context.Context, after int64, limit int) ([]Event, error)
+}
+
+var ErrCourseNotFound = errors.New("course not found")
+var ErrCourseExists = errors.New("course already exists")
+var ErrVersionMismatch = errors.New("it was changed by someone else")
+var ErrAuthorNotFound = errors.New("author not found")
+var ErrAuthorExists = errors.New("author already exists")
+var ErrAuthorHasCourses = errors.New("author still has courses")
+var ErrUnknownAuthor = errors.New("authorId does not name an author")
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	snapshotFile = "courses.snapshot.json"
+	logFile      = "courses.log"
+)
+
+// logEntry is one line of the append-only log.
+// "put" stores the whole course, "delete" only needs the id.
+// "putAuthor" and "deleteAuthor" do the same for authors; a cascading
+// delete lists the courses that went with the author in Ids, so the whole
+// cascade is one line and can not be torn in half. The outbox events of a
+// change are on the same line as the change.
+type logEntry struct {
+	Op      string   `json:"op"`
+	Course  *Course  `json:"course,omitempty"`
+	Author  *Author  `json:"author,omitempty"`
+	Version int64    `json:"version,omitempty"`
+	Id      string   `json:"id,omitempty"`
+	Ids     []string `json:"ids,omitempty"`
+	Events  []Event  `json:"events,omitempty"`
+}
+
+// storedCourse is a course in the snapshot. Version is not part of the
+// course JSON the API sends, so it is written next to it.
+type storedCourse struct {
+	Course
+	Version int64 `json:"version"`
+}
+
+type storedAuthor struct {
+	Author
+	Version int64 `json:"version"`
+}
+
+// snapshot is the snapshot file. Before authors existed it was only the
+// array of courses, loadSnapshot still reads that.
+type snapshot struct {
+	Courses []storedCourse `json:"courses"`
+	Authors []storedAuthor `json:"authors"`
+	Events  []Event        `json:"events,omitempty"`
+}
+
+// fileStore keeps the working set in a memoryStore and makes every change
+// durable by appending it to a log before applying it. A ticker writes a
+// full snapshot every so often and truncates the log, so startup only
+// replays the changes made since the last snapshot.
+type fileStore struct {
+	mu   sync.Mutex // serializes writers so the log order matches memory
+	mem  *memoryStore
+	dir  string
+	log  *os.File
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newFileStore(dir string, snapshotEvery time.Duration) (*fileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &fileStore{
+		mem:  newMemoryStore(),
+		dir:  dir,
+		done: make(chan struct{}),
+	}
+
+	if err := s.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	torn, err := s.replayLog()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, logFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s.log = f
+	if torn > 0 {
+		// start a clean log, new lines must not follow the torn ones
+		slog.Warn("skipped torn writes in the log", "dir", dir, "lines", torn)
+		if err := s.snapshotLocked(); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	if snapshotEvery > 0 {
+		s.wg.Add(1)
+		go s.snapshotLoop(snapshotEvery)
+	}
+	return s, nil
+}
+
+func (s *fileStore) List(ctx context.Context) ([]Course, error) {
+	return s.mem.List(ctx)
+}
+
+func (s *fileStore) Count(ctx context.Context) (int, error) {
+	return s.mem.Count(ctx)
+}
+
+func (s *fileStore) Get(ctx context.Context, id string) (Course, error) {
+	return s.mem.Get(ctx, id)
+}
+
+func (s *fileStore) Create(ctx context.Context, course Course) (Course, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.mem.Get(ctx, course.CourseId); err == nil {
+		return Course{}, ErrCourseExists
+	}
+	if err := s.checkAuthor(ctx, course.AuthorId); err != nil {
+		return Course{}, err
+	}
+	course.Version = 1
+	ev := s.mem.nextEvent(EventCourseCreated, course)
+	if err := s.appendLog(logEntry{Op: "put", Course: &course, Version: course.Version, Events: []Event{ev}}); err != nil {
+		return Course{}, err
+	}
+	s.mem.put(course)
+	s.mem.addEvents(ev)
+	return course, nil
+}
+
+func (s *fileStore) Update(ctx context.Context, course Course) (Course, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := s.mem.Get(ctx, course.CourseId)
+	if err != nil {
+		return Course{}, err
+	}
+	if course.Version != 0 && course.Version != current.Version {
+		return Course{}, ErrVersionMismatch
+	}
+	if err := s.checkAuthor(ctx, course.AuthorId); err != nil {
+		return Course{}, err
+	}
+	course.Version = current.Version + 1
+	ev := s.mem.nextEvent(EventCourseUpdated, course)
+	if err := s.appendLog(logEntry{Op: "put", Course: &course, Version: course.Version, Events: []Event{ev}}); err != nil {
+		return Course{}, err
+	}
+	s.mem.put(course)
+	s.mem.addEvents(ev)
+	return course, nil
+}
+
+func (s *fileStore) Delete(ctx context.Context, id string, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := s.mem.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if version != 0 && version != current.Version {
+		return ErrVersionMismatch
+	}
+	ev := s.mem.nextEvent(EventCourseDeleted, current)
+	if err := s.appendLog(logEntry{Op: "delete", Id: id, Events: []Event{ev}}); err != nil {
+		return err
+	}
+	s.mem.remove(id)
+	s.mem.addEvents(ev)
+	return nil
+}
+
+func (s *fileStore) ListAuthors(ctx context.Context) ([]Author, error) {
+	return s.mem.ListAuthors(ctx)
+}
+
+func (s *fileStore) CountAuthors(ctx context.Context) (int, error) {
+	return s.mem.CountAuthors(ctx)
+}
+
+func (s *fileStore) GetAuthor(ctx context.Context, id string) (Author, error) {
+	return s.mem.GetAuthor(ctx, id)
+}
+
+func (s *fileStore) GetAuthors(ctx context.Context, ids []string) (map[string]Author, error) {
+	return s.mem.GetAuthors(ctx, ids)
+}
+
+func (s *fileStore) CreateAuthor(ctx context.Context, author Author) (Author, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.mem.GetAuthor(ctx, author.AuthorId); err == nil {
+		return Author{}, ErrAuthorExists
+	}
+	author.Version = 1
+	if err := s.appendLog(logEntry{Op: "putAuthor", Author: &author, Version: author.Version}); err != nil {
+		return Author{}, err
+	}
+	s.mem.putAuthor(author)
+	return author, nil
+}
+
+func (s *fileStore) UpdateAuthor(ctx context.Context, author Author) (Author, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := s.mem.GetAuthor(ctx, author.AuthorId)
+	if err != nil {
+		return Author{}, err
+	}
+	if author.Version != 0 && author.Version != current.Version {
+		return Author{}, ErrVersionMismatch
+	}
+	author.Version = current.Version + 1
+	if err := s.appendLog(logEntry{Op: "putAuthor", Author: &author, Version: author.Version}); err != nil {
+		return Author{}, err
+	}
+	s.mem.putAuthor(author)
+	return author, nil
+}
+
+func (s *fileStore) DeleteAuthor(ctx context.Context, id string, version int64, cascade bool) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := s.mem.GetAuthor(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if version != 0 && version != current.Version {
+		return nil, ErrVersionMismatch
+	}
+	courseIds := s.mem.coursesOf(id)
+	if len(courseIds) > 0 && !cascade {
+		return nil, ErrAuthorHasCourses
+	}
+	events := make([]Event, len(courseIds))
+	for i, courseId := range courseIds {
+		course, err := s.mem.Get(ctx, courseId)
+		if err != nil {
+			return nil, err
+		}
+		// nothing is recorded until the log line is written, so the
+		// sequence numbers are counted on from the next one
+		events[i] = s.mem.nextEvent(EventCourseDeleted, course)
+		events[i].Seq += int64(i)
+	}
+	if err := s.appendLog(logEntry{Op: "deleteAuthor", Id: id, Ids: courseIds, Events: events}); err != nil {
+		return nil, err
+	}
+	for _, courseId := range courseIds {
+		s.mem.remove(courseId)
+	}
+	s.mem.removeAuthor(id)
+	s.mem.addEvents(events...)
+	return courseIds, nil
+}
+
+func (s *fileStore) Events(ctx context.Context, after int64, limit int) ([]Event, error) {
+	return s.mem.Events(ctx, after, limit)
+}
+
+// checkAuthor must be called with s.mu held, so the author can not be
+// deleted before the course that points at it is written.
+func (s *fileStore) checkAuthor(ctx context.Context, id string) error {
+	if id == "" {
+		return nil
+	}
+	if _, err := s.mem.GetAuthor(ctx, id); err != nil {
+		return ErrUnknownAuthor
+	}
+	return nil
+}
+
+// Close stops the snapshot ticker, writes a final snapshot and closes the log.
+func (s *fileStore) Close() error {
+	close(s.done)
+	s.wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.snapshotLocked(); err != nil {
+		return err
+	}
+	return s.log.Close()
+}
+
+// Snapshot writes the current state to disk and truncates the log.
+func (s *fileStore) Snapshot() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshotLocked()
+}
+
+func (s *fileStore) snapshotLoop(every time.Duration) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Snapshot(); err != nil {
+				slog.Error("snapshot failed", "error", err)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// appendLog must be called with s.mu held.
+func (s *fileStore) appendLog(entry logEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if _, err := s.log.Write(line); err != nil {
+		return err
+	}
+	return s.log.Sync()
+}
+
+// snapshotLocked must be called with s.mu held. The snapshot is written to a
+// temp file and renamed into place, so a crash never leaves a half-written
+// snapshot behind. If we crash after the rename but before the truncate the
+// old log is replayed on top of the new snapshot, which is harmless because
+// puts and deletes are idempotent.
+func (s *fileStore) snapshotLocked() error {
+	courses, err := s.mem.List(context.Background())
+	if err != nil {
+		return err
+	}
+	authors, err := s.mem.ListAuthors(context.Background())
+	if err != nil {
+		return err
+	}
+	events, err := s.mem.Events(context.Background(), 0, maxOutboxEvents)
+	if err != nil {
+		return err
+	}
+	stored := snapshot{
+		Courses: make([]storedCourse, len(courses)),
+		Authors: make([]storedAuthor, len(authors)),
+		Events:  events,
+	}
+	for i, course := range courses {
+		stored.Courses[i] = storedCourse{Course: course, Version: course.Version}
+	}
+	for i, author := range authors {
+		stored.Authors[i] = storedAuthor{Author: author, Version: author.Version}
+	}
+
+	tmp, err := os.CreateTemp(s.dir, snapshotFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := json.NewEncoder(tmp).Encode(stored); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, snapshotFile)); err != nil {
+		return err
+	}
+
+	if s.log == nil {
+		return nil
+	}
+	// the log is opened with O_APPEND, so new writes land at offset 0
+	return s.log.Truncate(0)
+}
+
+func (s *fileStore) loadSnapshot() error {
+	f, err := os.Open(filepath.Join(s.dir, snapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(f).Decode(&raw); err != nil {
+		return err
+	}
+	var stored snapshot
+	if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("[")) {
+		err = json.Unmarshal(raw, &stored.Courses)
+	} else {
+		err = json.Unmarshal(raw, &stored)
+	}
+	if err != nil {
+		return err
+	}
+
+	// authors first, so the order of the snapshot is the order of the store
+	for _, sa := range stored.Authors {
+		sa.Author.Version = versionOrFirst(sa.Version)
+		s.mem.putAuthor(sa.Author)
+	}
+	for _, sc := range stored.Courses {
+		sc.Course.Version = versionOrFirst(sc.Version)
+		s.mem.put(sc.Course)
+	}
+	s.mem.addEvents(stored.Events...)
+	return nil
+}
+
+// replayLog applies the log on top of the snapshot and returns how many
+// lines it skipped. A line that is cut short or does not parse is a torn
+// write; the lines after it were still acknowledged, so they are applied.
+func (s *fileStore) replayLog() (torn int, err error) {
+	f, err := os.Open(filepath.Join(s.dir, logFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	rd := bufio.NewReader(f)
+	for {
+		line, err := rd.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				torn++
+			}
+			return torn, nil
+		}
+		if err != nil {
+			return torn, err
+		}
+		var entry logEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			torn++
+			continue
+		}
+
+		switch entry.Op {
+		case "put":
+			if entry.Course != nil {
+				entry.Course.Version = versionOrFirst(entry.Version)
+				s.mem.put(*entry.Course)
+			}
+		case "delete":
+			s.mem.remove(entry.Id)
+		case "putAuthor":
+			if entry.Author != nil {
+				entry.Author.Version = versionOrFirst(entry.Version)
+				s.mem.putAuthor(*entry.Author)
+			}
+		case "deleteAuthor":
+			for _, id := range entry.Ids {
+				s.mem.remove(id)
+			}
+			s.mem.removeAuthor(entry.Id)
+		}
+		s.mem.addEvents(entry.Events...)
+	}
+}
+
+// versionOrFirst treats data written before courses had versions as
+// version 1.
+func versionOrFirst(version int64) int64 {
+	if version == 0 {
+		return 1
+	}
+	return version
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// indexedStore wraps another CourseStore and keeps a search index in step
+// with it. Writes go through one at a time, so the index sees them in the
+// order the store applied them.
+type indexedStore struct {
+	CourseStore
+	index *searchIndex
+	mu    sync.Mutex
+}
+
+// newIndexedStore indexes everything already in next.
+func newIndexedStore(ctx context.Context, next CourseStore) (*indexedStore, error) {
+	s := &indexedStore{CourseStore: next, index: newSearchIndex()}
+
+	courses, err := next.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	authors, err := next.ListAuthors(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string, len(authors))
+	for _, author := range authors {
+		names[author.AuthorId] = author.Fullname
+	}
+	for _, course := range courses {
+		s.index.put(course, names[course.AuthorId])
+	}
+	return s, nil
+}
+
+// put indexes a course the store accepted. The course was written, so a
+// failed author lookup only costs the author's name in the index.
+func (s *indexedStore) put(ctx context.Context, course Course) {
+	name := ""
+	if course.AuthorId != "" {
+		if author, err := s.CourseStore.GetAuthor(ctx, course.AuthorId); err == nil {
+			name = author.Fullname
+		}
+	}
+	s.index.put(course, name)
+}
+
+func (s *indexedStore) Create(ctx context.Context, course Course) (Course, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	created, err := s.CourseStore.Create(ctx, course)
+	if err == nil {
+		s.put(ctx, created)
+	}
+	return created, err
+}
+
+func (s *indexedStore) Update(ctx context.Context, course Course) (Course, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updated, err := s.CourseStore.Update(ctx, course)
+	if err == nil {
+		s.put(ctx, updated)
+	}
+	return updated, err
+}
+
+func (s *indexedStore) Delete(ctx context.Context, id string, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.CourseStore.Delete(ctx, id, version)
+	if err == nil || errors.Is(err, ErrCourseNotFound) {
+		s.index.remove(id)
+	}
+	return err
+}
+
+func (s *indexedStore) UpdateAuthor(ctx context.Context, author Author) (Author, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updated, err := s.CourseStore.UpdateAuthor(ctx, author)
+	if err == nil {
+		s.index.renameAuthor(updated.AuthorId, updated.Fullname)
+	}
+	return updated, err
+}
+
+func (s *indexedStore) DeleteAuthor(ctx context.Context, id string, version int64, cascade bool) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted, err := s.CourseStore.DeleteAuthor(ctx, id, version, cascade)
+	for _, courseId := range deleted {
+		s.index.remove(courseId)
+	}
+	return deleted, err
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// invalidatingStore wraps another CourseStore and turns every write into
+// invalidation events for the response cache: it bumps the generations
+// the cached responses of the changed data were stored under. Writes from
+// REST, gRPC, GraphQL and imports all pass through it.
+type invalidatingStore struct {
+	CourseStore
+	cache CacheStore
+	// genTTL is how long course generations are kept, see courseGenTTL
+	genTTL time.Duration
+	logger *slog.Logger
+}
+
+func newInvalidatingStore(next CourseStore, cache CacheStore, genTTL time.Duration, logger *slog.Logger) *invalidatingStore {
+	return &invalidatingStore{CourseStore: next, cache: cache, genTTL: genTTL, logger: logger}
+}
+
+// invalidate bumps gens. The write already happened, so a failure only
+// leaves cached responses to run out on their TTL, which is logged.
+func (s *invalidatingStore) invalidate(ctx context.Context, gens ...string) {
+	ctx = context.WithoutCancel(ctx)
+	for _, gen := range gens {
+		if _, err := s.cache.Incr(ctx, gen); err != nil {
+			s.logger.ErrorContext(ctx, "cache invalidation failed", "generation", gen, "error", err)
+		}
+	}
+}
+
+// invalidateCourses bumps genCourses and moves the generation of every
+// course in ids to its new value.
+func (s *invalidatingStore) invalidateCourses(ctx context.Context, ids ...string) {
+	ctx = context.WithoutCancel(ctx)
+	n, err := s.cache.Incr(ctx, genCourses)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "cache invalidation failed", "generation", genCourses, "error", err)
+		return
+	}
+	for _, id := range ids {
+		if err := s.cache.SetCounter(ctx, genCourse(id), n, s.genTTL); err != nil {
+			s.logger.ErrorContext(ctx, "cache invalidation failed", "generation", genCourse(id), "error", err)
+		}
+	}
+}
+
+func (s *invalidatingStore) Create(ctx context.Context, course Course) (Course, error) {
+	created, err := s.CourseStore.Create(ctx, course)
+	if err == nil {
+		s.invalidateCourses(ctx, created.CourseId)
+	}
+	return created, err
+}
+
+func (s *invalidatingStore) Update(ctx context.Context, course Course) (Course, error) {
+	updated, err := s.CourseStore.Update(ctx, course)
+	if err == nil {
+		s.invalidateCourses(ctx, updated.CourseId)
+	}
+	return updated, err
+}
+
+func (s *invalidatingStore) Delete(ctx context.Context, id string, version int64) error {
+	err := s.CourseStore.Delete(ctx, id, version)
+	if err == nil {
+		s.invalidateCourses(ctx, id)
+	}
+	return err
+}
+
+func (s *invalidatingStore) CreateAuthor(ctx context.Context, author Author) (Author, error) {
+	created, err := s.CourseStore.CreateAuthor(ctx, author)
+	if err == nil {
+		s.invalidate(ctx, genAuthors)
+	}
+	return created, err
+}
+
+func (s *invalidatingStore) UpdateAuthor(ctx context.Context, author Author) (Author, error) {
+	updated, err := s.CourseStore.UpdateAuthor(ctx, author)
+	if err == nil {
+		s.invalidate(ctx, genAuthors)
+	}
+	return updated, err
+}
+
+func (s *invalidatingStore) DeleteAuthor(ctx context.Context, id string, version int64, cascade bool) ([]string, error) {
+	deleted, err := s.CourseStore.DeleteAuthor(ctx, id, version, cascade)
+	if err == nil || len(deleted) > 0 {
+		s.invalidate(ctx, genAuthors)
+	}
+	if len(deleted) > 0 {
+		s.invalidateCourses(ctx, deleted...)
+	}
+	return deleted, err
+}
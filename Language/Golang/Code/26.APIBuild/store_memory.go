@@ -0,0 +1,336 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+)
+
+// record is a stored course plus the sequence number it was inserted with,
+// which keeps List in insertion order without a slice we have to scan.
+type record struct {
+	course Course
+	seq    uint64
+}
+
+// authorRecord is the same for authors.
+type authorRecord struct {
+	author Author
+	seq    uint64
+}
+
+// memoryStore keeps courses in a map indexed by CourseId and authors in
+// one indexed by AuthorId. Reads take the read lock so GETs run in
+// parallel; writes take the write lock, which also covers the outbox.
+// Everything is lost when the process stops.
+type memoryStore struct {
+	mu      sync.RWMutex
+	byId    map[string]record
+	authors map[string]authorRecord
+	nextSeq uint64
+	outbox  outbox
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{byId: make(map[string]record), authors: make(map[string]authorRecord)}
+}
+
+func (s *memoryStore) List(ctx context.Context) ([]Course, error) {
+	s.mu.RLock()
+	records := make([]record, 0, len(s.byId))
+	for _, rec := range s.byId {
+		records = append(records, rec)
+	}
+	s.mu.RUnlock()
+
+	slices.SortFunc(records, func(a, b record) int {
+		return cmp.Compare(a.seq, b.seq)
+	})
+
+	out := make([]Course, len(records))
+	for i, rec := range records {
+		out[i] = rec.course.clone()
+	}
+	return out, nil
+}
+
+func (s *memoryStore) Count(ctx context.Context) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.byId), nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, id string) (Course, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec, ok := s.byId[id]
+	if !ok {
+		return Course{}, ErrCourseNotFound
+	}
+	return rec.course.clone(), nil
+}
+
+func (s *memoryStore) Create(ctx context.Context, course Course) (Course, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.byId[course.CourseId]; ok {
+		return Course{}, ErrCourseExists
+	}
+	if !s.authorExistsLocked(course.AuthorId) {
+		return Course{}, ErrUnknownAuthor
+	}
+	course.Version = 1
+	s.insertLocked(course.clone())
+	s.outbox.add(s.outbox.next(EventCourseCreated, course))
+	return course, nil
+}
+
+func (s *memoryStore) Update(ctx context.Context, course Course) (Course, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.byId[course.CourseId]
+	if !ok {
+		return Course{}, ErrCourseNotFound
+	}
+	if course.Version != 0 && course.Version != rec.course.Version {
+		return Course{}, ErrVersionMismatch
+	}
+	if !s.authorExistsLocked(course.AuthorId) {
+		return Course{}, ErrUnknownAuthor
+	}
+	course.Version = rec.course.Version + 1
+	// keep the original seq so an update does not move the course
+	rec.course = course.clone()
+	s.byId[course.CourseId] = rec
+	s.outbox.add(s.outbox.next(EventCourseUpdated, course))
+	return course, nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, id string, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.byId[id]
+	if !ok {
+		return ErrCourseNotFound
+	}
+	if version != 0 && version != rec.course.Version {
+		return ErrVersionMismatch
+	}
+	delete(s.byId, id)
+	s.outbox.add(s.outbox.next(EventCourseDeleted, rec.course))
+	return nil
+}
+
+func (s *memoryStore) ListAuthors(ctx context.Context) ([]Author, error) {
+	s.mu.RLock()
+	records := make([]authorRecord, 0, len(s.authors))
+	for _, rec := range s.authors {
+		records = append(records, rec)
+	}
+	s.mu.RUnlock()
+
+	slices.SortFunc(records, func(a, b authorRecord) int {
+		return cmp.Compare(a.seq, b.seq)
+	})
+
+	out := make([]Author, len(records))
+	for i, rec := range records {
+		out[i] = rec.author
+	}
+	return out, nil
+}
+
+func (s *memoryStore) CountAuthors(ctx context.Context) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.authors), nil
+}
+
+func (s *memoryStore) GetAuthor(ctx context.Context, id string) (Author, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec, ok := s.authors[id]
+	if !ok {
+		return Author{}, ErrAuthorNotFound
+	}
+	return rec.author, nil
+}
+
+func (s *memoryStore) GetAuthors(ctx context.Context, ids []string) (map[string]Author, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make(map[string]Author, len(ids))
+	for _, id := range ids {
+		if rec, ok := s.authors[id]; ok {
+			out[id] = rec.author
+		}
+	}
+	return out, nil
+}
+
+func (s *memoryStore) CreateAuthor(ctx context.Context, author Author) (Author, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.authors[author.AuthorId]; ok {
+		return Author{}, ErrAuthorExists
+	}
+	author.Version = 1
+	s.insertAuthorLocked(author)
+	return author, nil
+}
+
+func (s *memoryStore) UpdateAuthor(ctx context.Context, author Author) (Author, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.authors[author.AuthorId]
+	if !ok {
+		return Author{}, ErrAuthorNotFound
+	}
+	if author.Version != 0 && author.Version != rec.author.Version {
+		return Author{}, ErrVersionMismatch
+	}
+	author.Version = rec.author.Version + 1
+	rec.author = author
+	s.authors[author.AuthorId] = rec
+	return author, nil
+}
+
+func (s *memoryStore) DeleteAuthor(ctx context.Context, id string, version int64, cascade bool) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.authors[id]
+	if !ok {
+		return nil, ErrAuthorNotFound
+	}
+	if version != 0 && version != rec.author.Version {
+		return nil, ErrVersionMismatch
+	}
+	courseIds := s.coursesOfLocked(id)
+	if len(courseIds) > 0 && !cascade {
+		return nil, ErrAuthorHasCourses
+	}
+	for _, courseId := range courseIds {
+		s.outbox.add(s.outbox.next(EventCourseDeleted, s.byId[courseId].course))
+		delete(s.byId, courseId)
+	}
+	delete(s.authors, id)
+	return courseIds, nil
+}
+
+func (s *memoryStore) Events(ctx context.Context, after int64, limit int) ([]Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.outbox.after(after, limit), nil
+}
+
+// put inserts or replaces a course without any existence or version
+// checks, keeping the version it is given. The file store uses it to
+// replay its log and to apply changes it already checked.
+func (s *memoryStore) put(course Course) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rec, ok := s.byId[course.CourseId]; ok {
+		rec.course = course.clone()
+		s.byId[course.CourseId] = rec
+		return
+	}
+	s.insertLocked(course.clone())
+}
+
+// remove deletes a course if it exists. Used by the file store.
+func (s *memoryStore) remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.byId, id)
+}
+
+// putAuthor and removeAuthor are put and remove for authors.
+func (s *memoryStore) putAuthor(author Author) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rec, ok := s.authors[author.AuthorId]; ok {
+		rec.author = author
+		s.authors[author.AuthorId] = rec
+		return
+	}
+	s.insertAuthorLocked(author)
+}
+
+func (s *memoryStore) removeAuthor(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.authors, id)
+}
+
+// nextEvent and addEvents let the file store log an event together with
+// the change before it records it here.
+func (s *memoryStore) nextEvent(typ string, course Course) Event {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.outbox.next(typ, course)
+}
+
+func (s *memoryStore) addEvents(events ...Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outbox.add(events...)
+}
+
+// coursesOf returns the ids of the courses that point at an author, in
+// insertion order.
+func (s *memoryStore) coursesOf(authorId string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.coursesOfLocked(authorId)
+}
+
+func (s *memoryStore) coursesOfLocked(authorId string) []string {
+	var records []record
+	for _, rec := range s.byId {
+		if rec.course.AuthorId == authorId {
+			records = append(records, rec)
+		}
+	}
+	slices.SortFunc(records, func(a, b record) int {
+		return cmp.Compare(a.seq, b.seq)
+	})
+	ids := make([]string, len(records))
+	for i, rec := range records {
+		ids[i] = rec.course.CourseId
+	}
+	return ids
+}
+
+// authorExistsLocked is true for an existing author and for no author.
+func (s *memoryStore) authorExistsLocked(id string) bool {
+	if id == "" {
+		return true
+	}
+	_, ok := s.authors[id]
+	return ok
+}
+
+func (s *memoryStore) insertAuthorLocked(author Author) {
+	s.nextSeq++
+	s.authors[author.AuthorId] = authorRecord{author: author, seq: s.nextSeq}
+}
+
+// insertLocked must be called with s.mu held for writing.
+func (s *memoryStore) insertLocked(course Course) {
+	s.nextSeq++
+	s.byId[course.CourseId] = record{course: course, seq: s.nextSeq}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRoutesConcurrently hammers the six course routes from many
+// goroutines at once. Run it with -race; it also checks no write got lost.
+func TestRoutesConcurrently(t *testing.T) {
+	stores := map[string]func(t *testing.T) CourseStore{
+		"memory": func(t *testing.T) CourseStore {
+			return newMemoryStore()
+		},
+		"file": func(t *testing.T) CourseStore {
+			store, err := newFileStore(t.TempDir(), 10*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() {
+				if err := store.Close(); err != nil {
+					t.Error(err)
+				}
+			})
+			return store
+		},
+	}
+
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			ts := newTestServer(t, open(t))
+
+			const workers, rounds = 16, 10
+			var wg sync.WaitGroup
+			for w := 0; w < workers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < rounds; i++ {
+						if err := courseRound(ts, fmt.Sprintf("w%d-%d", w, i)); err != nil {
+							t.Error(err)
+							return
+						}
+					}
+				}(w)
+			}
+			wg.Wait()
+
+			// every course a worker created it deleted again, the seeds are left
+			res, body := ts.do(t, "GET", "/courses?limit=100", "")
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("GET /courses: %d %s", res.StatusCode, body)
+			}
+			var page coursePageV1
+			if err := json.Unmarshal([]byte(body), &page); err != nil {
+				t.Fatal(err)
+			}
+			if page.Total != 2 {
+				t.Errorf("total = %d after the load, want the 2 seeded courses", page.Total)
+			}
+		})
+	}
+}
+
+// courseRound goes through every route once: it creates a course, reads it
+// and the list, replaces it and the shared seed course, and deletes it.
+func courseRound(ts *testServer, name string) error {
+	steps := []struct {
+		method, path, body string
+		want               int
+	}{
+		{"GET", "/", "", http.StatusOK},
+		{"POST", "/course", `{"courseName":"` + name + `","coursePrice":10}`, http.StatusCreated},
+	}
+	var id string
+	for _, step := range steps {
+		res, body, err := ts.send(step.method, step.path, step.body)
+		if err != nil {
+			return err
+		}
+		if res.StatusCode != step.want {
+			return fmt.Errorf("%s %s: %d %s", step.method, step.path, res.StatusCode, body)
+		}
+		if step.method == "POST" {
+			var created courseV1
+			if err := json.Unmarshal([]byte(body), &created); err != nil {
+				return err
+			}
+			id = created.CourseId
+		}
+	}
+
+	steps = []struct {
+		method, path, body string
+		want               int
+	}{
+		{"GET", "/course/" + id, "", http.StatusOK},
+		{"GET", "/courses", "", http.StatusOK},
+		{"PUT", "/course/" + id, `{"courseName":"` + name + ` v2","coursePrice":20}`, http.StatusOK},
+		{"PUT", "/course/2", `{"courseName":"ReactJS","coursePrice":299,"authorId":"1"}`, http.StatusOK},
+		{"GET", "/course/2", "", http.StatusOK},
+		{"DELETE", "/course/" + id, "", http.StatusNoContent},
+		{"GET", "/course/" + id, "", http.StatusNotFound},
+	}
+	for _, step := range steps {
+		res, body, err := ts.send(step.method, step.path, step.body)
+		if err != nil {
+			return err
+		}
+		if res.StatusCode != step.want {
+			return fmt.Errorf("%s %s: %d %s", step.method, step.path, res.StatusCode, body)
+		}
+	}
+	return nil
+}
+
+// TestFileStoreTornLog restarts the file store after crashes that left a
+// torn write at the end of the log, with more writes in between.
+func TestFileStoreTornLog(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, logFile)
+
+	// crash closes the log without the snapshot Close would write, and
+	// leaves half a line behind
+	crash := func(s *fileStore) {
+		t.Helper()
+		s.log.Close()
+		if err := appendFile(logPath, `{"op":"put","course":{"courseId":"torn","cour`); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var want []string
+	for round := range 3 {
+		s, err := newFileStore(dir, 0)
+		if err != nil {
+			t.Fatalf("round %d: %v", round, err)
+		}
+		for _, id := range want {
+			if _, err := s.Get(ctx, id); err != nil {
+				t.Errorf("round %d: course %s: %v", round, id, err)
+			}
+		}
+		if _, err := s.Get(ctx, "torn"); err == nil {
+			t.Errorf("round %d: the torn course was loaded", round)
+		}
+		id := fmt.Sprintf("c%d", round)
+		if _, err := s.Create(ctx, Course{CourseId: id, CourseName: id}); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, id)
+		crash(s)
+	}
+
+	// a log written before torn lines were cleaned up: acknowledged lines
+	// follow the torn one
+	line, _ := json.Marshal(logEntry{Op: "put", Course: &Course{CourseId: "after", CourseName: "after"}, Version: 1})
+	if err := appendFile(logPath, "\n"+string(line)+"\n"); err != nil {
+		t.Fatal(err)
+	}
+	s, err := newFileStore(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, id := range append(want, "after") {
+		if _, err := s.Get(ctx, id); err != nil {
+			t.Errorf("course %s: %v", id, err)
+		}
+	}
+}
+
+func appendFile(path, data string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,167 @@
+package main
+
+import "context"
+
+// tracedStore wraps another CourseStore and records a child span for every
+// call, so a trace shows how long a request spent in storage.
+type tracedStore struct {
+	next   CourseStore
+	tracer *Tracer
+	system string // "memory" or "file"
+}
+
+func newTracedStore(next CourseStore, tracer *Tracer, system string) *tracedStore {
+	return &tracedStore{next: next, tracer: tracer, system: system}
+}
+
+func (s *tracedStore) start(ctx context.Context, op string, attrs ...Attribute) (context.Context, *Span) {
+	attrs = append(attrs,
+		Attribute{"db.system.name", s.system},
+		Attribute{"db.operation.name", op},
+	)
+	return s.tracer.Start(ctx, "store."+op, SpanKindInternal, attrs...)
+}
+
+func (s *tracedStore) List(ctx context.Context) ([]Course, error) {
+	ctx, span := s.start(ctx, "List")
+	defer span.End()
+
+	courses, err := s.next.List(ctx)
+	span.SetAttributes(Attribute{"courses.count", int64(len(courses))})
+	span.RecordError(err)
+	return courses, err
+}
+
+func (s *tracedStore) Count(ctx context.Context) (int, error) {
+	ctx, span := s.start(ctx, "Count")
+	defer span.End()
+
+	n, err := s.next.Count(ctx)
+	span.RecordError(err)
+	return n, err
+}
+
+func (s *tracedStore) Get(ctx context.Context, id string) (Course, error) {
+	ctx, span := s.start(ctx, "Get", Attribute{"course.id", id})
+	defer span.End()
+
+	course, err := s.next.Get(ctx, id)
+	span.RecordError(err)
+	return course, err
+}
+
+func (s *tracedStore) Create(ctx context.Context, course Course) (Course, error) {
+	ctx, span := s.start(ctx, "Create", Attribute{"course.id", course.CourseId})
+	defer span.End()
+
+	created, err := s.next.Create(ctx, course)
+	span.RecordError(err)
+	return created, err
+}
+
+func (s *tracedStore) Update(ctx context.Context, course Course) (Course, error) {
+	ctx, span := s.start(ctx, "Update",
+		Attribute{"course.id", course.CourseId},
+		Attribute{"course.version", course.Version},
+	)
+	defer span.End()
+
+	updated, err := s.next.Update(ctx, course)
+	span.RecordError(err)
+	return updated, err
+}
+
+func (s *tracedStore) Delete(ctx context.Context, id string, version int64) error {
+	ctx, span := s.start(ctx, "Delete",
+		Attribute{"course.id", id},
+		Attribute{"course.version", version},
+	)
+	defer span.End()
+
+	err := s.next.Delete(ctx, id, version)
+	span.RecordError(err)
+	return err
+}
+
+func (s *tracedStore) ListAuthors(ctx context.Context) ([]Author, error) {
+	ctx, span := s.start(ctx, "ListAuthors")
+	defer span.End()
+
+	authors, err := s.next.ListAuthors(ctx)
+	span.SetAttributes(Attribute{"authors.count", int64(len(authors))})
+	span.RecordError(err)
+	return authors, err
+}
+
+func (s *tracedStore) CountAuthors(ctx context.Context) (int, error) {
+	ctx, span := s.start(ctx, "CountAuthors")
+	defer span.End()
+
+	n, err := s.next.CountAuthors(ctx)
+	span.RecordError(err)
+	return n, err
+}
+
+func (s *tracedStore) GetAuthor(ctx context.Context, id string) (Author, error) {
+	ctx, span := s.start(ctx, "GetAuthor", Attribute{"author.id", id})
+	defer span.End()
+
+	author, err := s.next.GetAuthor(ctx, id)
+	span.RecordError(err)
+	return author, err
+}
+
+func (s *tracedStore) GetAuthors(ctx context.Context, ids []string) (map[string]Author, error) {
+	ctx, span := s.start(ctx, "GetAuthors", Attribute{"authors.requested", int64(len(ids))})
+	defer span.End()
+
+	authors, err := s.next.GetAuthors(ctx, ids)
+	span.SetAttributes(Attribute{"authors.count", int64(len(authors))})
+	span.RecordError(err)
+	return authors, err
+}
+
+func (s *tracedStore) CreateAuthor(ctx context.Context, author Author) (Author, error) {
+	ctx, span := s.start(ctx, "CreateAuthor", Attribute{"author.id", author.AuthorId})
+	defer span.End()
+
+	created, err := s.next.CreateAuthor(ctx, author)
+	span.RecordError(err)
+	return created, err
+}
+
+func (s *tracedStore) UpdateAuthor(ctx context.Context, author Author) (Author, error) {
+	ctx, span := s.start(ctx, "UpdateAuthor",
+		Attribute{"author.id", author.AuthorId},
+		Attribute{"author.version", author.Version},
+	)
+	defer span.End()
+
+	updated, err := s.next.UpdateAuthor(ctx, author)
+	span.RecordError(err)
+	return updated, err
+}
+
+func (s *tracedStore) DeleteAuthor(ctx context.Context, id string, version int64, cascade bool) ([]string, error) {
+	ctx, span := s.start(ctx, "DeleteAuthor",
+		Attribute{"author.id", id},
+		Attribute{"author.version", version},
+		Attribute{"cascade", cascade},
+	)
+	defer span.End()
+
+	deleted, err := s.next.DeleteAuthor(ctx, id, version, cascade)
+	span.SetAttributes(Attribute{"courses.deleted", int64(len(deleted))})
+	span.RecordError(err)
+	return deleted, err
+}
+
+func (s *tracedStore) Events(ctx context.Context, after int64, limit int) ([]Event, error) {
+	ctx, span := s.start(ctx, "Events", Attribute{"events.after", after})
+	defer span.End()
+
+	events, err := s.next.Events(ctx, after, limit)
+	span.SetAttributes(Attribute{"events.count", int64(len(events))})
+	span.RecordError(err)
+	return events, err
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const serviceName = "courses-api"
+
+// SpanExporter sends finished spans somewhere. ExportSpans gets whole
+// batches from one goroutine at a time.
+type SpanExporter interface {
+	ExportSpans(ctx context.Context, spans []SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+func newSpanExporter(kind, otlpEndpoint string) (SpanExporter, error) {
+	switch kind {
+	case "none":
+		return nil, nil
+	case "stdout":
+		return newJSONSpanExporter(os.Stdout), nil
+	case "otlp":
+		return newOTLPExporter(otlpEndpoint), nil
+	}
+	return nil, fmt.Errorf("unknown trace exporter %q", kind)
+}
+
+// batchProcessor queues ended spans and exports them in batches from its
+// own goroutine, so a slow collector never holds up a request. When the
+// queue is full new spans are dropped and counted.
+type batchProcessor struct {
+	exporter  SpanExporter
+	queue     chan SpanData
+	batchSize int
+	interval  time.Duration
+	stop      chan struct{}
+	done      chan struct{}
+	stopOnce  sync.Once
+	dropped   atomic.Uint64
+}
+
+func newBatchProcessor(exporter SpanExporter, queueSize, batchSize int, interval time.Duration) *batchProcessor {
+	p := &batchProcessor{
+		exporter:  exporter,
+		queue:     make(chan SpanData, queueSize),
+		batchSize: batchSize,
+		interval:  interval,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go p.loop()
+	return p
+}
+
+func (p *batchProcessor) onEnd(span SpanData) {
+	select {
+	case p.queue <- span:
+	default:
+		p.dropped.Add(1)
+	}
+}
+
+func (p *batchProcessor) loop() {
+	defer close(p.done)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	var batch []SpanData
+	for {
+		select {
+		case span := <-p.queue:
+			batch = append(batch, span)
+			if len(batch) >= p.batchSize {
+				batch = p.export(batch)
+			}
+		case <-ticker.C:
+			batch = p.export(batch)
+		case <-p.stop:
+			for {
+				select {
+				case span := <-p.queue:
+					batch = append(batch, span)
+				default:
+					p.export(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// export sends the batch and returns it emptied for reuse.
+func (p *batchProcessor) export(batch []SpanData) []SpanData {
+	if len(batch) == 0 {
+		return batch
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := p.exporter.ExportSpans(ctx, batch); err != nil {
+		slog.Warn("trace export failed", "spans", len(batch), "error", err)
+	}
+	if dropped := p.dropped.Swap(0); dropped > 0 {
+		slog.Warn("trace queue full, spans dropped", "spans", dropped)
+	}
+	return batch[:0]
+}
+
+func (p *batchProcessor) shutdown(ctx context.Context) error {
+	p.stopOnce.Do(func() { close(p.stop) })
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return p.exporter.Shutdown(ctx)
+}
+
+// jsonSpanExporter writes one JSON object per span and line.
+type jsonSpanExporter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func newJSONSpanExporter(out io.Writer) *jsonSpanExporter {
+	return &jsonSpanExporter{out: out}
+}
+
+type jsonSpan struct {
+	Name          string         `json:"name"`
+	Kind          string         `json:"kind"`
+	TraceId       string         `json:"trace_id"`
+	SpanId        string         `json:"span_id"`
+	ParentSpanId  string         `json:"parent_span_id,omitempty"`
+	Start         time.Time      `json:"start"`
+	End           time.Time      `json:"end"`
+	DurationMs    float64        `json:"duration_ms"`
+	Attributes    map[string]any `json:"attributes,omitempty"`
+	Status        string         `json:"status"`
+	StatusMessage string         `json:"status_message,omitempty"`
+}
+
+func (e *jsonSpanExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, span := range spans {
+		out := jsonSpan{
+			Name:          span.Name,
+			Kind:          span.Kind.String(),
+			TraceId:       span.Context.TraceId.String(),
+			SpanId:        span.Context.SpanId.String(),
+			Start:         span.Start,
+			End:           span.End,
+			DurationMs:    float64(span.End.Sub(span.Start).Microseconds()) / 1000,
+			Status:        [...]string{"unset", "ok", "error"}[span.Status],
+			StatusMessage: span.StatusMessage,
+		}
+		if span.Parent.IsValid() {
+			out.ParentSpanId = span.Parent.String()
+		}
+		if len(span.Attributes) > 0 {
+			out.Attributes = make(map[string]any, len(span.Attributes))
+			for _, attr := range span.Attributes {
+				out.Attributes[attr.Key] = attr.Value
+			}
+		}
+		if err := enc.Encode(out); err != nil {
+			return err
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err := e.out.Write(buf.Bytes())
+	return err
+}
+
+func (e *jsonSpanExporter) Shutdown(ctx context.Context) error { return nil }
+
+// otlpExporter posts spans to an OpenTelemetry collector with OTLP/HTTP in
+// its JSON encoding, to <endpoint>/v1/traces. Answers the spec marks as
+// retryable (429, 502, 503, 504) are retried with backoff.
+type otlpExporter struct {
+	url    string
+	client *http.Client
+}
+
+func newOTLPExporter(endpoint string) *otlpExporter {
+	url := strings.TrimRight(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+	return &otlpExporter{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (e *otlpExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	body, err := json.Marshal(otlpRequest(spans))
+	if err != nil {
+		return err
+	}
+
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		retry, err := e.post(ctx, body)
+		if err == nil || !retry || attempt == 3 {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (e *otlpExporter) post(ctx context.Context, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusBadGateway,
+		resp.StatusCode == http.StatusServiceUnavailable, resp.StatusCode == http.StatusGatewayTimeout:
+		return true, fmt.Errorf("collector answered %s", resp.Status)
+	}
+	return false, fmt.Errorf("collector answered %s", resp.Status)
+}
+
+func (e *otlpExporter) Shutdown(ctx context.Context) error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+// otlpRequest builds an ExportTraceServiceRequest in the protobuf JSON
+// mapping: ids are hex, 64 bit integers are strings.
+func otlpRequest(spans []SpanData) map[string]any {
+	out := make([]map[string]any, 0, len(spans))
+	for _, span := range spans {
+		s := map[string]any{
+			"traceId":           span.Context.TraceId.String(),
+			"spanId":            span.Context.SpanId.String(),
+			"name":              span.Name,
+			"kind":              int(span.Kind),
+			"startTimeUnixNano": strconv.FormatInt(span.Start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(span.End.UnixNano(), 10),
+			"attributes":        otlpAttributes(span.Attributes),
+			"status":            map[string]any{"code": int(span.Status), "message": span.StatusMessage},
+		}
+		if span.Parent.IsValid() {
+			s["parentSpanId"] = span.Parent.String()
+		}
+		if span.Context.TraceState != "" {
+			s["traceState"] = span.Context.TraceState
+		}
+		out = append(out, s)
+	}
+
+	return map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": otlpAttributes([]Attribute{{"service.name", serviceName}}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "26.APIBuild"},
+				"spans": out,
+			}},
+		}},
+	}
+}
+
+func otlpAttributes(attrs []Attribute) []map[string]any {
+	out := make([]map[string]any, 0, len(attrs))
+	for _, attr := range attrs {
+		var value map[string]any
+		switch v := attr.Value.(type) {
+		case string:
+			value = map[string]any{"stringValue": v}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]any{"doubleValue": v}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		out = append(out, map[string]any{"key": attr.Key, "value": value})
+	}
+	return out
+}
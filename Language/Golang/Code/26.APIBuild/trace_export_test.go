@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// otlpSpan is the part of an OTLP/JSON span the test looks at.
+type otlpSpan struct {
+	TraceId      string `json:"traceId"`
+	SpanId       string `json:"spanId"`
+	ParentSpanId string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Kind         int    `json:"kind"`
+}
+
+type otlpPayload struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []struct {
+				Key   string         `json:"key"`
+				Value map[string]any `json:"value"`
+			} `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []struct {
+			Spans []otlpSpan `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+// TestOTLPExport sends a request with a traceparent through the API and
+// checks what a stand-in collector receives.
+func TestOTLPExport(t *testing.T) {
+	var mu sync.Mutex
+	var payloads []otlpPayload
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/traces" {
+			t.Errorf("collector got %s %s", r.Method, r.URL.Path)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		var p otlpPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("payload: %v", err)
+		}
+		mu.Lock()
+		payloads = append(payloads, p)
+		mu.Unlock()
+	}))
+	defer collector.Close()
+
+	ts := newTracedTestServer(t, newMemoryStore(), newOTLPExporter(collector.URL))
+	const traceId, parentId = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	res, _ := ts.do(t, "GET", "/course/2", "", traceparentHeader, "00-"+traceId+"-"+parentId+"-01")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("GET /course/2: %d", res.StatusCode)
+	}
+	// Close waits for the handler, so its spans have ended before the flush
+	ts.Close()
+	if err := ts.tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	spans := map[string]otlpSpan{}
+	for _, p := range payloads {
+		for _, rs := range p.ResourceSpans {
+			if len(rs.Resource.Attributes) == 0 || rs.Resource.Attributes[0].Key != "service.name" ||
+				rs.Resource.Attributes[0].Value["stringValue"] != serviceName {
+				t.Errorf("resource attributes = %+v", rs.Resource.Attributes)
+			}
+			for _, ss := range rs.ScopeSpans {
+				for _, span := range ss.Spans {
+					spans[span.Name] = span
+				}
+			}
+		}
+	}
+
+	server, ok := spans["GET /course/{id}"]
+	if !ok {
+		t.Fatalf("no server span among %v", spans)
+	}
+	if server.TraceId != traceId || server.ParentSpanId != parentId {
+		t.Errorf("server span is in trace %s under %s, want %s under %s", server.TraceId, server.ParentSpanId, traceId, parentId)
+	}
+	if server.Kind != int(SpanKindServer) {
+		t.Errorf("server span kind = %d", server.Kind)
+	}
+
+	get, ok := spans["store.Get"]
+	if !ok {
+		t.Fatalf("no store span among %v", spans)
+	}
+	if get.TraceId != traceId || get.ParentSpanId != server.SpanId {
+		t.Errorf("store span is in trace %s under %s, want %s under %s", get.TraceId, get.ParentSpanId, traceId, server.SpanId)
+	}
+	if get.Kind != int(SpanKindInternal) {
+		t.Errorf("store span kind = %d", get.Kind)
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracing follows the OpenTelemetry model (spans, W3C trace context,
+// exporters) without the SDK, which this module does not vendor. Spans are
+// exported in the OTLP JSON encoding, so a real collector accepts them.
+
+const (
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+)
+
+type TraceId [16]byte
+type SpanId [8]byte
+
+func (t TraceId) String() string { return hex.EncodeToString(t[:]) }
+func (t TraceId) IsValid() bool  { return t != TraceId{} }
+func (s SpanId) String() string  { return hex.EncodeToString(s[:]) }
+func (s SpanId) IsValid() bool   { return s != SpanId{} }
+
+// SpanContext is the part of a span that crosses process boundaries.
+type SpanContext struct {
+	TraceId    TraceId
+	SpanId     SpanId
+	Sampled    bool
+	TraceState string // passed on untouched
+	Remote     bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceId.IsValid() && sc.SpanId.IsValid()
+}
+
+// SpanKind uses the OTLP numbering.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	}
+	return "internal"
+}
+
+// StatusCode uses the OTLP numbering as well.
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// Attribute values are string, bool, int64 or float64.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// SpanData is a finished span as the exporters see it.
+type SpanData struct {
+	Name          string
+	Kind          SpanKind
+	Context       SpanContext
+	Parent        SpanId
+	Start         time.Time
+	End           time.Time
+	Attributes    []Attribute
+	Status        StatusCode
+	StatusMessage string
+}
+
+// Span is a span that is still running. Its methods are safe to call from
+// several goroutines and do nothing once the span has ended.
+type Span struct {
+	tracer *Tracer
+	mu     sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+func (s *Span) SpanContext() SpanContext {
+	return s.data.Context
+}
+
+func (s *Span) SetName(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Name = name
+	}
+}
+
+// SetAttributes adds attributes, replacing any with the same key.
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+outer:
+	for _, attr := range attrs {
+		for i := range s.data.Attributes {
+			if s.data.Attributes[i].Key == attr.Key {
+				s.data.Attributes[i].Value = attr.Value
+				continue outer
+			}
+		}
+		s.data.Attributes = append(s.data.Attributes, attr)
+	}
+}
+
+func (s *Span) SetStatus(code StatusCode, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Status = code
+		s.data.StatusMessage = message
+	}
+}
+
+// RecordError marks the span failed. A nil error is ignored so it can be
+// called with whatever the traced call returned.
+func (s *Span) RecordError(err error) {
+	if err != nil {
+		s.SetStatus(StatusError, err.Error())
+	}
+}
+
+// End finishes the span and hands it to the exporter if it was sampled.
+func (s *Span) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = s.tracer.now()
+	data := s.data
+	s.mu.Unlock()
+
+	if data.Context.Sampled && s.tracer.processor != nil {
+		s.tracer.processor.onEnd(data)
+	}
+}
+
+// Tracer starts spans. Without an exporter spans are still created, so
+// trace ids reach the logs and the traceparent of callers is honored.
+type Tracer struct {
+	processor   *batchProcessor
+	sampleRatio float64
+	now         func() time.Time
+}
+
+func newTracer(exporter SpanExporter, sampleRatio float64) *Tracer {
+	t := &Tracer{sampleRatio: sampleRatio, now: time.Now}
+	if exporter != nil {
+		t.processor = newBatchProcessor(exporter, 2048, 512, 2*time.Second)
+	}
+	return t
+}
+
+type spanKey struct{}
+type remoteSpanKey struct{}
+
+// SpanFromContext returns the span running in ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+func contextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteSpanKey{}, sc)
+}
+
+// parentFromContext prefers a local span over one that came in a header.
+func parentFromContext(ctx context.Context) SpanContext {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.SpanContext()
+	}
+	sc, _ := ctx.Value(remoteSpanKey{}).(SpanContext)
+	return sc
+}
+
+// Start begins a span as a child of whatever span ctx carries, or a new
+// trace when it carries none.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind, attrs ...Attribute) (context.Context, *Span) {
+	parent := parentFromContext(ctx)
+
+	sc := SpanContext{SpanId: newSpanId()}
+	if parent.IsValid() {
+		sc.TraceId = parent.TraceId
+		sc.Sampled = parent.Sampled
+		sc.TraceState = parent.TraceState
+	} else {
+		sc.TraceId = newTraceId()
+		sc.Sampled = t.sample(sc.TraceId)
+	}
+
+	span := &Span{tracer: t, data: SpanData{
+		Name:       name,
+		Kind:       kind,
+		Context:    sc,
+		Parent:     parent.SpanId,
+		Start:      t.now(),
+		Attributes: attrs,
+	}}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// sample keeps a fixed share of new traces. Like the OpenTelemetry
+// TraceIdRatioBased sampler it decides on the low 63 bits of the trace id,
+// so every service with the same ratio keeps the same traces.
+func (t *Tracer) sample(id TraceId) bool {
+	switch {
+	case t.sampleRatio >= 1:
+		return true
+	case t.sampleRatio <= 0:
+		return false
+	}
+	bound := uint64(t.sampleRatio * (1 << 63))
+	return binary.BigEndian.Uint64(id[8:])>>1 < bound
+}
+
+// Shutdown exports the spans still queued.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t.processor == nil {
+		return nil
+	}
+	return t.processor.shutdown(ctx)
+}
+
+func newTraceId() TraceId {
+	var id TraceId
+	for !id.IsValid() {
+		if _, err := rand.Read(id[:]); err != nil {
+			panic(err)
+		}
+	}
+	return id
+}
+
+func newSpanId() SpanId {
+	var id SpanId
+	for !id.IsValid() {
+		if _, err := rand.Read(id[:]); err != nil {
+			panic(err)
+		}
+	}
+	return id
+}
+
+// parseTraceparent reads a W3C traceparent header:
+// version-traceid-parentid-flags, e.g.
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01.
+// Versions after 00 may append fields, which are ignored.
+func parseTraceparent(header string) (SpanContext, bool) {
+	header = strings.TrimSpace(header)
+	if len(header) < 55 {
+		return SpanContext{}, false
+	}
+	version, ok := parseHexByte(header[0:2])
+	if !ok || version == 0xff {
+		return SpanContext{}, false
+	}
+	if version == 0 && len(header) != 55 || len(header) > 55 && header[55] != '-' {
+		return SpanContext{}, false
+	}
+	if header[2] != '-' || header[35] != '-' || header[52] != '-' {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+	if !decodeLowerHex(sc.TraceId[:], header[3:35]) || !decodeLowerHex(sc.SpanId[:], header[36:52]) {
+		return SpanContext{}, false
+	}
+	flags, ok := parseHexByte(header[53:55])
+	if !ok || !sc.IsValid() {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags&0x01 != 0
+	sc.Remote = true
+	return sc, true
+}
+
+func formatTraceparent(sc SpanContext) string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceId.String() + "-" + sc.SpanId.String() + "-" + flags
+}
+
+// injectTraceContext adds the trace context of ctx to an outgoing request.
+func injectTraceContext(ctx context.Context, header http.Header) {
+	sc := parentFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	header.Set(traceparentHeader, formatTraceparent(sc))
+	if sc.TraceState != "" {
+		header.Set(tracestateHeader, sc.TraceState)
+	}
+}
+
+// decodeLowerHex only accepts lowercase hex, as the spec demands.
+func decodeLowerHex(dst []byte, s string) bool {
+	if strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+func parseHexByte(s string) (byte, bool) {
+	if strings.ToLower(s) != s {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(s, 16, 8)
+	return byte(v), err == nil
+}
+
+// traceRequests starts the server span of every request, continuing the
+// trace of the caller when it sent a valid traceparent. The span is named
+// after the route template once the router has matched it.
+func (s *server) traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if remote, ok := parseTraceparent(r.Header.Get(traceparentHeader)); ok {
+			remote.TraceState = r.Header.Get(tracestateHeader)
+			ctx = contextWithRemoteSpanContext(ctx, remote)
+		}
+
+		ctx, span := s.tracer.Start(ctx, r.Method, SpanKindServer,
+			Attribute{"http.request.method", r.Method},
+			Attribute{"url.path", r.URL.Path},
+			Attribute{"request.id", requestIdFromContext(ctx)},
+		)
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		if info := infoFromContext(ctx); info != nil && info.route != "" {
+			span.SetName(r.Method + " " + info.route)
+			span.SetAttributes(Attribute{"http.route", info.route})
+		}
+		span.SetAttributes(Attribute{"http.response.status_code", int64(rec.status)})
+		if rec.status >= 500 {
+			// 4xx are the client's fault, not a failed span
+			span.SetStatus(StatusError, http.StatusText(rec.status))
+		}
+	})
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxBodyBytes caps how much of a request body we are willing to decode.
+const maxBodyBytes = 1 << 20
+
+// FieldError is one broken rule on one field. Field is the dotted JSON
+// path, e.g. "author.website".
+type FieldError struct {
+	Field   string `json:"field" xml:"field"`
+	Message string `json:"message" xml:"message"`
+}
+
+// ValidationErrors collects every FieldError of a payload, so a client
+// sees all of its mistakes in one response instead of one per request.
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	parts := make([]string, len(v))
+	for i, fe := range v {
+		parts[i] = fe.Field + ": " + fe.Message
+	}
+	return "validation failed: " + strings.Join(parts, "; ")
+}
+
+var ErrEmptyBody = errors.New("request body is empty")
+var ErrMalformedJSON = errors.New("malformed JSON")
+var ErrBodyTooLarge = fmt.Errorf("request body is larger than %d bytes", maxBodyBytes)
+
+// decodeJSON reads exactly one JSON value from the body into v. Unknown
+// fields, trailing data and bodies over maxBodyBytes are errors instead of
+// being silently dropped.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	return decodeBody(w, r, "application/json", v)
+}
+
+// decodeBody is decodeJSON for bodies sent as another JSON based media
+// type, like the PATCH formats.
+func decodeBody(w http.ResponseWriter, r *http.Request, mediaType string, v any) error {
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		got, _, err := mime.ParseMediaType(ct)
+		if err != nil || got != mediaType {
+			return fmt.Errorf("%w: send the body as %s", ErrUnsupportedMediaType, mediaType)
+		}
+	}
+	if r.Body == nil || r.Body == http.NoBody {
+		return ErrEmptyBody
+	}
+	return decodeStrict(http.MaxBytesReader(w, r.Body, maxBodyBytes), v)
+}
+
+// decodeStrict decodes a single JSON value from rd and maps decoder
+// failures onto the errors problemFor knows about.
+func decodeStrict(rd io.Reader, v any) error {
+	dec := json.NewDecoder(rd)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		var maxErr *http.MaxBytesError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.Is(err, io.EOF):
+			return ErrEmptyBody
+		case errors.As(err, &maxErr):
+			return ErrBodyTooLarge
+		case errors.As(err, &typeErr):
+			return ValidationErrors{{Field: typeErr.Field, Message: "must be of type " + typeErr.Type.String()}}
+		}
+		return fmt.Errorf("%w: %v", ErrMalformedJSON, err)
+	}
+	// More is false on a stray } or ], only EOF ends the body cleanly
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return ErrBodyTooLarge
+		}
+		return fmt.Errorf("%w: body must hold a single object", ErrMalformedJSON)
+	}
+	return nil
+}
+
+// validateStruct checks the `validate` tags of v, which must be a struct or
+// a pointer to one. The rules are:
+//
+//	required  the field is not its zero value (or not nil for pointers)
+//	min=N     numbers are >= N, strings are at least N characters
+//	max=N     numbers are <= N, strings are at most N characters
+//	url       a string that parses as a http(s) URL, the scheme may be left out
+//
+// Nested structs and pointers to structs are checked too.
+func validateStruct(v any) error {
+	var errs ValidationErrors
+	validateValue("", reflect.ValueOf(v), &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateValue(prefix string, rv reflect.Value, errs *ValidationErrors) {
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return
+	}
+
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		path := prefix + jsonName(field)
+		value := rv.Field(i)
+
+		for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+			if rule == "" {
+				continue
+			}
+			if msg := checkRule(rule, value); msg != "" {
+				*errs = append(*errs, FieldError{Field: path, Message: msg})
+				break
+			}
+		}
+
+		validateValue(path+".", value, errs)
+	}
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// checkRule returns what is wrong with value, or "" if the rule holds.
+func checkRule(rule string, value reflect.Value) string {
+	name, arg, _ := strings.Cut(rule, "=")
+
+	if name == "required" {
+		if value.IsZero() {
+			return "is required"
+		}
+		return ""
+	}
+
+	// the other rules only look at values that are there
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return ""
+		}
+		value = value.Elem()
+	}
+
+	switch name {
+	case "min", "max":
+		limit, err := strconv.Atoi(arg)
+		if err != nil {
+			panic(fmt.Sprintf("validate: bad %s rule %q", name, rule))
+		}
+		size, unit := measure(value)
+		if name == "min" && size < int64(limit) {
+			return fmt.Sprintf("must be at least %d%s", limit, unit)
+		}
+		if name == "max" && size > int64(limit) {
+			return fmt.Sprintf("must be at most %d%s", limit, unit)
+		}
+	case "url":
+		if value.Kind() == reflect.String && value.String() != "" && !isURL(value.String()) {
+			return "must be a valid URL"
+		}
+	default:
+		panic(fmt.Sprintf("validate: unknown rule %q", rule))
+	}
+	return ""
+}
+
+// measure gives the number a min/max rule compares against: the value of a
+// number or the length of a string.
+func measure(value reflect.Value) (int64, string) {
+	switch value.Kind() {
+	case reflect.String:
+		return int64(utf8.RuneCountInString(value.String())), " characters"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int(), ""
+	}
+	panic("validate: min/max on unsupported kind " + value.Kind().String())
+}
+
+// isURL accepts "https://lco.dev/path" as well as the bare "lco.dev" the
+// seed data uses.
+func isURL(s string) bool {
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	return strings.Contains(u.Hostname(), ".") && !strings.ContainsAny(u.Hostname(), " _")
+}
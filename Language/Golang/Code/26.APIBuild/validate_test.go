@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDecodeStrict(t *testing.T) {
+	tests := []struct {
+		body string
+		want error
+	}{
+		{`{"courseName":"Go"}`, nil},
+		{"{\"courseName\":\"Go\"}\n", nil},
+		{"", ErrEmptyBody},
+		{`{}}`, ErrMalformedJSON},
+		{`{}]`, ErrMalformedJSON},
+		{`{}{}`, ErrMalformedJSON},
+		{`{} 1`, ErrMalformedJSON},
+		{`{"courseName":"Go"`, ErrMalformedJSON},
+		{`{"discount":5}`, ErrMalformedJSON},
+	}
+	for _, tt := range tests {
+		var course Course
+		if err := decodeStrict(strings.NewReader(tt.body), &course); !errors.Is(err, tt.want) {
+			t.Errorf("decodeStrict(%q) = %v, want %v", tt.body, err, tt.want)
+		}
+	}
+
+	var course Course
+	var verrs ValidationErrors
+	err := decodeStrict(strings.NewReader(`{"coursePrice":"free"}`), &course)
+	if !errors.As(err, &verrs) || verrs[0].Field != "coursePrice" {
+		t.Errorf("wrong type: err = %v, want a validation error on coursePrice", err)
+	}
+}
+
+func TestValidateStruct(t *testing.T) {
+	long := strings.Repeat("x", 121)
+	tests := []struct {
+		name   string
+		course Course
+		// fields are the fields that fail, in order
+		fields []string
+	}{
+		{"valid", Course{CourseName: "Go", CoursePrice: 5, CourseSite: "lco.dev"}, nil},
+		{"missing name", Course{CoursePrice: 5}, []string{"courseName"}},
+		{"name too long", Course{CourseName: long}, []string{"courseName"}},
+		{"120 runes, more bytes", Course{CourseName: strings.Repeat("é", 120)}, nil},
+		{"negative price", Course{CourseName: "Go", CoursePrice: -1}, []string{"coursePrice"}},
+		{"price too high", Course{CourseName: "Go", CoursePrice: 1_000_001}, []string{"coursePrice"}},
+		{"every mistake at once", Course{CoursePrice: -1}, []string{"coursePrice", "courseName"}},
+		{"nested author", Course{CourseName: "Go", Author: &Author{Website: "not a url"}}, []string{"author.fullName", "author.website"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateStruct(tt.course)
+			var verrs ValidationErrors
+			if tt.fields == nil {
+				if err != nil {
+					t.Fatalf("err = %v, want none", err)
+				}
+				return
+			}
+			if !errors.As(err, &verrs) {
+				t.Fatalf("err = %v, want ValidationErrors", err)
+			}
+			var fields []string
+			for _, fe := range verrs {
+				fields = append(fields, fe.Field)
+			}
+			if !slices.Equal(fields, tt.fields) {
+				t.Errorf("fields = %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestIsURL(t *testing.T) {
+	tests := []struct {
+		in string
+		ok bool
+	}{
+		{"lco.dev", true},
+		{"https://lco.dev/path", true},
+		{"http://go.dev", true},
+		{"ftp://lco.dev", false},
+		{"localhost", false},
+		{"under_score.dev", false},
+		{"https://", false},
+	}
+	for _, tt := range tests {
+		if got := isURL(tt.in); got != tt.ok {
+			t.Errorf("isURL(%q) = %v, want %v", tt.in, got, tt.ok)
+		}
+	}
+}
+
+func TestCourseBodyErrors(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+	tests := []struct {
+		name   string
+		body   string
+		header []string
+		status int
+	}{
+		{"valid", `{"courseName":"Go","coursePrice":5}`, nil, http.StatusCreated},
+		{"invalid", `{"coursePrice":-1}`, nil, http.StatusUnprocessableEntity},
+		{"unknown field", `{"courseName":"Go","discount":5}`, nil, http.StatusBadRequest},
+		{"form body", `courseName=Go`, []string{"Content-Type", "application/x-www-form-urlencoded"}, http.StatusUnsupportedMediaType},
+		{"too large", `{"courseName":"` + strings.Repeat("x", maxBodyBytes) + `"}`, nil, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, body := ts.do(t, "POST", "/course", tt.body, tt.header...)
+			if res.StatusCode != tt.status {
+				t.Fatalf("%d, want %d: %.200s", res.StatusCode, tt.status, body)
+			}
+			if tt.status != http.StatusUnprocessableEntity {
+				return
+			}
+			var p Problem
+			if err := json.Unmarshal([]byte(body), &p); err != nil {
+				t.Fatal(err)
+			}
+			if len(p.Errors) != 2 {
+				t.Errorf("errors = %+v, want one for coursePrice and one for courseName", p.Errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiVersion is a version of the course schema. Course is the internal
+// model; each version has its own DTOs and converters, so the model can
+// change without breaking what a version promised. Query parameters are
+// the same in every version.
+type apiVersion int
+
+const (
+	apiV1 apiVersion = 1
+	// apiV2 sends the price in minor units with its currency
+	apiV2 apiVersion = 2
+)
+
+// catalogCurrency is the currency every CoursePrice is in. The store keeps
+// whole units, so v2 writes must be whole units as well.
+const catalogCurrency = "USD"
+
+var ErrUnsupportedVersion = errors.New("unsupported API version")
+
+// apiVersions are the versions a client may ask for.
+var apiVersions = []apiVersion{apiV1, apiV2}
+
+// versionPolicy is when a version stops being recommended and when it is
+// switched off. Both are sent on every response of a deprecated version,
+// as the Deprecation (RFC 9745) and Sunset (RFC 8594) headers. No version
+// is deprecated until the operator sets the dates, see -v1-deprecated.
+type versionPolicy struct {
+	deprecated time.Time
+	sunset     time.Time
+}
+
+// parseVersionPolicy reads the dates of a policy as YYYY-MM-DD. Empty
+// dates are not set; a sunset needs a deprecation before it.
+func parseVersionPolicy(deprecated, sunset string) (versionPolicy, error) {
+	var policy versionPolicy
+	var err error
+	if deprecated != "" {
+		if policy.deprecated, err = time.Parse(time.DateOnly, deprecated); err != nil {
+			return policy, fmt.Errorf("deprecation date: %w", err)
+		}
+	}
+	if sunset != "" {
+		if policy.sunset, err = time.Parse(time.DateOnly, sunset); err != nil {
+			return policy, fmt.Errorf("sunset date: %w", err)
+		}
+		if policy.deprecated.IsZero() || !policy.sunset.After(policy.deprecated) {
+			return policy, errors.New("a sunset date needs an earlier deprecation date")
+		}
+	}
+	return policy, nil
+}
+
+// routeVersion is one way the course routes are mounted: under a version
+// prefix, or without one, where Accept-Version picks the version.
+type routeVersion struct {
+	prefix string
+	// suffix makes the route names, and so the operationIds, unique
+	suffix  string
+	version apiVersion
+	// policies are the server's, set when the routes are mounted
+	policies map[apiVersion]versionPolicy
+}
+
+var routeVersions = []routeVersion{
+	{prefix: "", suffix: ""},
+	{prefix: "/v1", suffix: "V1", version: apiV1},
+	{prefix: "/v2", suffix: "V2", version: apiV2},
+}
+
+type apiVersionKey struct{}
+
+func apiVersionFromContext(ctx context.Context) apiVersion {
+	if v, ok := ctx.Value(apiVersionKey{}).(apiVersion); ok {
+		return v
+	}
+	return apiV1
+}
+
+// etagSuffix goes into the ETag of a representation in this version, the
+// way a format's does, since v1 and v2 bodies of the same course differ.
+// v1 has none, so ETags kept from before v2 still match.
+func (v apiVersion) etagSuffix() string {
+	if v == apiV1 {
+		return ""
+	}
+	return "+v" + strconv.Itoa(int(v))
+}
+
+// etagVersion tells which version an ETag sent by a client was made for
+// and returns it without the version.
+func etagVersion(tag string) (apiVersion, string) {
+	for _, v := range apiVersions {
+		if suffix := v.etagSuffix(); suffix != "" && strings.HasSuffix(tag, suffix+`"`) {
+			return v, strings.TrimSuffix(tag, suffix+`"`) + `"`
+		}
+	}
+	return apiV1, tag
+}
+
+// use puts the version of the route into the request. Unprefixed routes
+// read Accept-Version and default to v1, so existing clients keep working.
+// Like negotiate, it gives every version its own ETag and maps the ETags
+// in If-Match and If-None-Match back to the ones handlers set.
+func (rv routeVersion) use(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		version := rv.version
+		if rv.prefix == "" {
+			w.Header().Add("Vary", "Accept-Version")
+			var err error
+			if version, err = parseAcceptVersion(r.Header.Get("Accept-Version")); err != nil {
+				writeProblem(w, r, err)
+				return
+			}
+		}
+
+		if policy := rv.policies[version]; !policy.deprecated.IsZero() {
+			w.Header().Set("Deprecation", "@"+strconv.FormatInt(policy.deprecated.Unix(), 10))
+			if !policy.sunset.IsZero() {
+				w.Header().Set("Sunset", policy.sunset.Format(http.TimeFormat))
+			}
+			successor := "/v2" + strings.TrimPrefix(r.URL.Path, rv.prefix)
+			w.Header().Add("Link", "<"+successor+`>; rel="successor-version"`)
+		}
+
+		if im := r.Header.Get("If-Match"); im != "" {
+			r.Header.Set("If-Match", rewriteETags(im, func(tag string) (string, bool) {
+				_, tag = etagVersion(tag)
+				return tag, true
+			}))
+		}
+		if inm := r.Header.Get("If-None-Match"); inm != "" {
+			inm = rewriteETags(inm, func(tag string) (string, bool) {
+				tv, tag := etagVersion(tag)
+				return tag, tv == version
+			})
+			if inm == "" {
+				r.Header.Del("If-None-Match")
+			} else {
+				r.Header.Set("If-None-Match", inm)
+			}
+		}
+
+		w = &etagSuffixWriter{ResponseWriter: w, suffix: version.etagSuffix()}
+		next(w, r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, version)))
+	}
+}
+
+// parseAcceptVersion reads Accept-Version, which is "2" or "v2".
+func parseAcceptVersion(header string) (apiVersion, error) {
+	header = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(header)), "v")
+	if header == "" {
+		return apiV1, nil
+	}
+	n, err := strconv.Atoi(header)
+	if err != nil || !slices.Contains(apiVersions, apiVersion(n)) {
+		return 0, fmt.Errorf("%w: Accept-Version must be 1 or 2", ErrUnsupportedVersion)
+	}
+	return apiVersion(n), nil
+}
+
+// courseDTO is the body of a course write in some version.
+type courseDTO interface {
+	toCourse() (Course, error)
+}
+
+// courseV1 is a course as v1 reads and writes it, the shape the API had
+// before versions. The DTOs carry no validate tags: the rules are those
+// of Course, see validateCourse.
+type courseV1 struct {
+	XMLName     xml.Name `json:"-" xml:"course"`
+	CourseId    string   `json:"courseId" xml:"courseId"`
+	CoursePrice int      `json:"coursePrice" xml:"coursePrice"`
+	CourseName  string   `json:"courseName" xml:"courseName"`
+	CourseSite  string   `json:"courseSite" xml:"courseSite"`
+	AuthorId    string   `json:"authorId,omitempty" xml:"authorId,omitempty"`
+	Author      *Author  `json:"author,omitempty" xml:"author,omitempty"`
+}
+
+// courseV2 replaces coursePrice by a price in minor units.
+type courseV2 struct {
+	XMLName    xml.Name `json:"-" xml:"course"`
+	CourseId   string   `json:"courseId" xml:"courseId"`
+	CourseName string   `json:"courseName" xml:"courseName"`
+	CourseSite string   `json:"courseSite" xml:"courseSite"`
+	Price      money    `json:"price" xml:"price"`
+	AuthorId   string   `json:"authorId,omitempty" xml:"authorId,omitempty"`
+	Author     *Author  `json:"author,omitempty" xml:"author,omitempty"`
+}
+
+// money is an amount in the smallest unit of its ISO 4217 currency, e.g.
+// cents.
+type money struct {
+	Amount   int64  `json:"amount" xml:"amount"`
+	Currency string `json:"currency" xml:"currency"`
+}
+
+type coursePageV1 struct {
+	XMLName    xml.Name   `json:"-" xml:"coursePage"`
+	Courses    []courseV1 `json:"courses" xml:"courses>course"`
+	NextCursor string     `json:"next_cursor,omitempty" xml:"next_cursor,omitempty"`
+	Total      int        `json:"total" xml:"total"`
+}
+
+type coursePageV2 struct {
+	XMLName    xml.Name   `json:"-" xml:"coursePage"`
+	Courses    []courseV2 `json:"courses" xml:"courses>course"`
+	NextCursor string     `json:"next_cursor,omitempty" xml:"next_cursor,omitempty"`
+	Total      int        `json:"total" xml:"total"`
+}
+
+// searchResultsV1 is the envelope GET /courses/search answers with.
+type searchResultsV1 struct {
+	XMLName xml.Name      `json:"-" xml:"searchResults"`
+	Results []searchHitV1 `json:"results" xml:"results>result"`
+	Total   int           `json:"total" xml:"total"`
+}
+
+type searchHitV1 struct {
+	Course     courseV1        `json:"course" xml:"course"`
+	Score      float64         `json:"score" xml:"score"`
+	Highlights fieldHighlights `json:"highlights" xml:"highlights"`
+}
+
+type searchResultsV2 struct {
+	XMLName xml.Name      `json:"-" xml:"searchResults"`
+	Results []searchHitV2 `json:"results" xml:"results>result"`
+	Total   int           `json:"total" xml:"total"`
+}
+
+type searchHitV2 struct {
+	Course     courseV2        `json:"course" xml:"course"`
+	Score      float64         `json:"score" xml:"score"`
+	Highlights fieldHighlights `json:"highlights" xml:"highlights"`
+}
+
+func toCourseV1(c Course) courseV1 {
+	return courseV1{
+		CourseId:    c.CourseId,
+		CoursePrice: c.CoursePrice,
+		CourseName:  c.CourseName,
+		CourseSite:  c.CourseSite,
+		AuthorId:    c.AuthorId,
+		Author:      c.Author,
+	}
+}
+
+func (dto *courseV1) toCourse() (Course, error) {
+	return Course{
+		CourseId:    dto.CourseId,
+		CoursePrice: dto.CoursePrice,
+		CourseName:  dto.CourseName,
+		CourseSite:  dto.CourseSite,
+		AuthorId:    dto.AuthorId,
+		Author:      dto.Author,
+	}, nil
+}
+
+func toCourseV2(c Course) courseV2 {
+	return courseV2{
+		CourseId:   c.CourseId,
+		CourseName: c.CourseName,
+		CourseSite: c.CourseSite,
+		Price:      money{Amount: int64(c.CoursePrice) * 100, Currency: catalogCurrency},
+		AuthorId:   c.AuthorId,
+		Author:     c.Author,
+	}
+}
+
+func (dto *courseV2) toCourse() (Course, error) {
+	var errs ValidationErrors
+	if dto.Price.Currency != catalogCurrency {
+		errs = append(errs, FieldError{Field: "price.currency", Message: "must be " + catalogCurrency})
+	}
+	if dto.Price.Amount%100 != 0 {
+		errs = append(errs, FieldError{Field: "price.amount", Message: "must be whole " + catalogCurrency})
+	}
+	if len(errs) > 0 {
+		return Course{}, errs
+	}
+	return Course{
+		CourseId:    dto.CourseId,
+		CoursePrice: int(dto.Price.Amount / 100),
+		CourseName:  dto.CourseName,
+		CourseSite:  dto.CourseSite,
+		AuthorId:    dto.AuthorId,
+		Author:      dto.Author,
+	}, nil
+}
+
+// courseFor is c as the version of the request sends it.
+func courseFor(ctx context.Context, c Course) any {
+	if apiVersionFromContext(ctx) == apiV2 {
+		return toCourseV2(c)
+	}
+	return toCourseV1(c)
+}
+
+// coursePageFor is courseFor for a page.
+func coursePageFor(ctx context.Context, page coursePage) any {
+	if apiVersionFromContext(ctx) == apiV2 {
+		out := coursePageV2{Courses: make([]courseV2, len(page.Courses)), NextCursor: page.NextCursor, Total: page.Total}
+		for i, c := range page.Courses {
+			out.Courses[i] = toCourseV2(c)
+		}
+		return out
+	}
+	out := coursePageV1{Courses: make([]courseV1, len(page.Courses)), NextCursor: page.NextCursor, Total: page.Total}
+	for i, c := range page.Courses {
+		out.Courses[i] = toCourseV1(c)
+	}
+	return out
+}
+
+// searchResultsFor is courseFor for search hits.
+func searchResultsFor(ctx context.Context, hits []searchHit, total int) any {
+	if apiVersionFromContext(ctx) == apiV2 {
+		out := searchResultsV2{Results: make([]searchHitV2, len(hits)), Total: total}
+		for i, hit := range hits {
+			out.Results[i] = searchHitV2{Course: toCourseV2(hit.Course), Score: hit.Score, Highlights: hit.Highlights}
+		}
+		return out
+	}
+	out := searchResultsV1{Results: make([]searchHitV1, len(hits)), Total: total}
+	for i, hit := range hits {
+		out.Results[i] = searchHitV1{Course: toCourseV1(hit.Course), Score: hit.Score, Highlights: hit.Highlights}
+	}
+	return out
+}
+
+func newCourseDTO(ctx context.Context) courseDTO {
+	if apiVersionFromContext(ctx) == apiV2 {
+		return &courseV2{}
+	}
+	return &courseV1{}
+}
+
+// decodeCourse reads a course in the version of the request.
+func decodeCourse(w http.ResponseWriter, r *http.Request) (Course, error) {
+	dto := newCourseDTO(r.Context())
+	if err := decodeEntity(w, r, dto); err != nil {
+		return Course{}, err
+	}
+	return dto.toCourse()
+}
+
+// validateCourse checks c against the rules of Course and names the
+// fields in the errors the way the request's version does.
+func validateCourse(ctx context.Context, c Course) error {
+	err := validateStruct(c)
+	var errs ValidationErrors
+	if apiVersionFromContext(ctx) != apiV2 || !errors.As(err, &errs) {
+		return err
+	}
+	for i, fe := range errs {
+		if fe.Field == "coursePrice" {
+			// the rule is in whole units, the amount in minor ones
+			errs[i] = FieldError{Field: "price.amount", Message: fe.Message + " " + catalogCurrency}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestVersionContracts pins what each version promised: the course shape
+// it reads and writes, how it is picked, and the headers of a deprecated
+// version.
+func TestVersionContracts(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+	v1Keys := []string{"authorId", "courseId", "courseName", "coursePrice", "courseSite"}
+	v2Keys := []string{"authorId", "courseId", "courseName", "courseSite", "price"}
+
+	tests := []struct {
+		path, acceptVersion string
+		keys                []string
+		deprecated          bool
+	}{
+		{"/course/2", "", v1Keys, true},
+		{"/course/2", "1", v1Keys, true},
+		{"/course/2", "v2", v2Keys, false},
+		{"/v1/course/2", "", v1Keys, true},
+		{"/v2/course/2", "", v2Keys, false},
+		// a prefix wins over Accept-Version
+		{"/v1/course/2", "2", v1Keys, true},
+	}
+	for _, tt := range tests {
+		res, body := ts.do(t, "GET", tt.path, "", "Accept-Version", tt.acceptVersion)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s %q: %d %s", tt.path, tt.acceptVersion, res.StatusCode, body)
+		}
+		var course map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(body), &course); err != nil {
+			t.Fatal(err)
+		}
+		if keys := slices.Sorted(maps.Keys(course)); !slices.Equal(keys, tt.keys) {
+			t.Errorf("%s %q: fields %v, want %v", tt.path, tt.acceptVersion, keys, tt.keys)
+		}
+
+		deprecation, sunset, link := res.Header.Get("Deprecation"), res.Header.Get("Sunset"), res.Header.Get("Link")
+		if !tt.deprecated {
+			if deprecation != "" || sunset != "" || link != "" {
+				t.Errorf("%s %q: Deprecation %q, Sunset %q, Link %q on a current version", tt.path, tt.acceptVersion, deprecation, sunset, link)
+			}
+			continue
+		}
+		policy := testV1Policy
+		if want := "@" + strconv.FormatInt(policy.deprecated.Unix(), 10); deprecation != want {
+			t.Errorf("%s %q: Deprecation = %q, want %q", tt.path, tt.acceptVersion, deprecation, want)
+		}
+		if at, err := http.ParseTime(sunset); err != nil || !at.Equal(policy.sunset) {
+			t.Errorf("%s %q: Sunset = %q, want %v", tt.path, tt.acceptVersion, sunset, policy.sunset)
+		}
+		if want := `</v2/course/2>; rel="successor-version"`; link != want {
+			t.Errorf("%s %q: Link = %q, want %q", tt.path, tt.acceptVersion, link, want)
+		}
+	}
+
+	res, _ := ts.do(t, "GET", "/course/2", "")
+	if !slices.Contains(res.Header.Values("Vary"), "Accept-Version") {
+		t.Errorf("Vary = %q, want Accept-Version", res.Header.Values("Vary"))
+	}
+	if res, _ := ts.do(t, "GET", "/v2/course/2", ""); slices.Contains(res.Header.Values("Vary"), "Accept-Version") {
+		t.Error("a prefixed route varies on Accept-Version")
+	}
+	if res, body := ts.do(t, "GET", "/course/2", "", "Accept-Version", "3"); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Accept-Version 3: %d %s, want 400", res.StatusCode, body)
+	}
+}
+
+func TestVersionWrites(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+
+	tests := []struct {
+		name, path, body string
+		status           int
+		// field is the field of the validation error, if any
+		field string
+	}{
+		{"v1", "/v1/course", `{"courseName":"Go","coursePrice":5}`, http.StatusCreated, ""},
+		{"v2", "/v2/course", `{"courseName":"Go","price":{"amount":500,"currency":"USD"}}`, http.StatusCreated, ""},
+		{"v2 by header", "/course", `{"courseName":"Go","price":{"amount":500,"currency":"USD"}}`, http.StatusCreated, ""},
+		{"v2 cents", "/v2/course", `{"courseName":"Go","price":{"amount":550,"currency":"USD"}}`, http.StatusUnprocessableEntity, "price.amount"},
+		{"v2 currency", "/v2/course", `{"courseName":"Go","price":{"amount":500,"currency":"EUR"}}`, http.StatusUnprocessableEntity, "price.currency"},
+		{"v1 price", "/v1/course", `{"courseName":"Go","coursePrice":2000000}`, http.StatusUnprocessableEntity, "coursePrice"},
+		{"v2 price", "/v2/course", `{"courseName":"Go","price":{"amount":200000000,"currency":"USD"}}`, http.StatusUnprocessableEntity, "price.amount"},
+		{"v2 name", "/v2/course", `{"price":{"amount":500,"currency":"USD"}}`, http.StatusUnprocessableEntity, "courseName"},
+		{"v1 field on v2", "/v2/course", `{"courseName":"Go","coursePrice":5}`, http.StatusBadRequest, ""},
+		{"v2 field on v1", "/v1/course", `{"courseName":"Go","price":{"amount":500,"currency":"USD"}}`, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acceptVersion := ""
+			if tt.path == "/course" {
+				acceptVersion = "2"
+			}
+			res, body := ts.do(t, "POST", tt.path, tt.body, "Accept-Version", acceptVersion)
+			if res.StatusCode != tt.status {
+				t.Fatalf("%d, want %d: %s", res.StatusCode, tt.status, body)
+			}
+			if tt.field != "" {
+				var p Problem
+				if err := json.Unmarshal([]byte(body), &p); err != nil {
+					t.Fatal(err)
+				}
+				if len(p.Errors) != 1 || p.Errors[0].Field != tt.field {
+					t.Errorf("errors = %+v, want one on %s", p.Errors, tt.field)
+				}
+			}
+			if res.StatusCode != http.StatusCreated {
+				return
+			}
+
+			// the same course in v1 costs 5 whole dollars
+			id := res.Header.Get("Location")[strings.LastIndex(res.Header.Get("Location"), "/")+1:]
+			res, body = ts.do(t, "GET", "/v1/course/"+id, "")
+			var course courseV1
+			if err := json.Unmarshal([]byte(body), &course); err != nil {
+				t.Fatalf("%d %s: %v", res.StatusCode, body, err)
+			}
+			if course.CoursePrice != 5 {
+				t.Errorf("coursePrice = %d, want 5", course.CoursePrice)
+			}
+		})
+	}
+}
+
+// TestVersionPolicy checks that no version is deprecated until dates are
+// configured.
+func TestVersionPolicy(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	for _, rv := range routeVersions {
+		rec := httptest.NewRecorder()
+		rv.use(ok)(rec, httptest.NewRequest("GET", rv.prefix+"/course/2", nil))
+		for _, name := range []string{"Deprecation", "Sunset", "Link"} {
+			if got := rec.Header().Get(name); got != "" {
+				t.Errorf("%q: %s = %q without a policy", rv.prefix, name, got)
+			}
+		}
+	}
+
+	tests := []struct {
+		deprecated, sunset string
+		ok                 bool
+	}{
+		{"", "", true},
+		{"2026-10-18", "", true},
+		{"2026-10-18", "2027-04-30", true},
+		{"", "2027-04-30", false},
+		{"2027-04-30", "2026-10-18", false},
+		{"18.10.2026", "", false},
+	}
+	for _, tt := range tests {
+		if _, err := parseVersionPolicy(tt.deprecated, tt.sunset); (err == nil) != tt.ok {
+			t.Errorf("parseVersionPolicy(%q, %q) = %v", tt.deprecated, tt.sunset, err)
+		}
+	}
+}
+
+// TestVersionETags checks that every version has its own ETag, so a tag
+// of one version never answers 304 for another, while writes accept the
+// tag of any version.
+func TestVersionETags(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+
+	res, _ := ts.do(t, "GET", "/v1/course/2", "")
+	v1 := res.Header.Get("ETag")
+	res, _ = ts.do(t, "GET", "/v2/course/2", "")
+	v2 := res.Header.Get("ETag")
+	res, _ = ts.do(t, "GET", "/v2/course/2", "", "Accept", "application/xml")
+	v2XML := res.Header.Get("ETag")
+	if v1 == v2 || v2 != withETagSuffix(v1, "+v2") || v2XML != withETagSuffix(v2, "+xml") {
+		t.Fatalf("ETags v1 %s, v2 %s, v2 XML %s", v1, v2, v2XML)
+	}
+
+	tests := []struct {
+		path, accept, acceptVersion, ifNoneMatch string
+		status                                   int
+	}{
+		{"/v1/course/2", "", "", v1, http.StatusNotModified},
+		{"/v2/course/2", "", "", v2, http.StatusNotModified},
+		{"/v2/course/2", "", "", v1, http.StatusOK},
+		{"/v1/course/2", "", "", v2, http.StatusOK},
+		{"/course/2", "", "2", v2, http.StatusNotModified},
+		{"/course/2", "", "2", v1, http.StatusOK},
+		{"/v2/course/2", "application/xml", "", v2XML, http.StatusNotModified},
+		{"/v2/course/2", "application/xml", "", v2, http.StatusOK},
+		{"/v2/course/2", "", "", v1 + ", " + v2, http.StatusNotModified},
+	}
+	for _, tt := range tests {
+		res, _ := ts.do(t, "GET", tt.path, "", "Accept", tt.accept, "Accept-Version", tt.acceptVersion, "If-None-Match", tt.ifNoneMatch)
+		if res.StatusCode != tt.status {
+			t.Errorf("GET %s Accept %q Accept-Version %q If-None-Match %s: %d, want %d",
+				tt.path, tt.accept, tt.acceptVersion, tt.ifNoneMatch, res.StatusCode, tt.status)
+		}
+	}
+
+	// a write checks the course version, whichever API version it was read in
+	res, body := ts.do(t, "PUT", "/v1/course/2", `{"courseName":"ReactJS","coursePrice":300}`, "If-Match", v2XML)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("PUT with a v2 ETag: %d %s", res.StatusCode, body)
+	}
+	if res, _ := ts.do(t, "PUT", "/v2/course/2", `{"courseName":"ReactJS","price":{"amount":30000,"currency":"USD"}}`, "If-Match", v2); res.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("PUT with a stale ETag: %d, want 412", res.StatusCode)
+	}
+}
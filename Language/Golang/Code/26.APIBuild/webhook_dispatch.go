@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	webhookWorkers   = 4
+	webhookQueueSize = 1024
+	webhookTimeout   = 10 * time.Second
+	outboxBatch      = 100
+)
+
+// webhookConfig is what main reads from flags.
+type webhookConfig struct {
+	PollEvery   time.Duration
+	MaxAttempts int
+	Backoff     time.Duration // wait before the second attempt, doubled after each
+	MaxBackoff  time.Duration
+}
+
+// delivery is an event on its way to one subscription. attempt counts
+// from 1.
+type delivery struct {
+	sub     Subscription
+	event   Event
+	attempt int
+}
+
+// pendingRetry is a delivery waiting for its backoff.
+type pendingRetry struct {
+	timer *time.Timer
+	dl    delivery
+}
+
+// eventKey picks out the event one write put in the outbox.
+type eventKey struct {
+	typ      string
+	courseId string
+	version  int64
+}
+
+func keyOf(ev Event) eventKey {
+	return eventKey{ev.Type, ev.CourseId, ev.Version}
+}
+
+// dispatcher moves events from the course store's outbox to the webhook
+// subscriptions. It polls the outbox, fans each event out to the
+// subscriptions that want it and has workers send them. A failed send is
+// tried again after an exponential backoff with jitter, until MaxAttempts
+// is used up and the event goes to the dead letters.
+//
+// Delivery is at least once and not in order: a receiver should drop
+// event ids it has seen and use Seq or Version to order them. Retries
+// that are waiting live in memory; when run stops they are sent right
+// away, and the ones that still fail go to the dead letters.
+//
+// While a hold is taken the outbox is not read, so the writes of an
+// atomic import can be undone before anyone hears of them.
+type dispatcher struct {
+	events  CourseStore
+	hooks   WebhookStore
+	config  webhookConfig
+	client  *http.Client
+	tracer  *Tracer
+	logger  *slog.Logger
+	results *metricVec // deliveries by result
+	now     func() time.Time
+
+	queue chan delivery
+	wg    sync.WaitGroup
+
+	mu         sync.Mutex
+	holds      int
+	suppressed map[eventKey]bool // events poll drops instead of sending
+	retries    map[int]pendingRetry
+	nextRetry  int
+	stopping   bool
+	leftover   []delivery // retries caught by the stop, sent by drain
+	timers     sync.WaitGroup
+}
+
+func newDispatcher(events CourseStore, hooks WebhookStore, config webhookConfig, tracer *Tracer, logger *slog.Logger, results *metricVec) *dispatcher {
+	return &dispatcher{
+		events: events,
+		hooks:  hooks,
+		config: config,
+		client: &http.Client{
+			Timeout: webhookTimeout,
+			// a redirect is the receiver's mistake, it is not followed
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		tracer:  tracer,
+		logger:  logger,
+		results: results,
+		now:     time.Now,
+		queue:   make(chan delivery, webhookQueueSize),
+
+		suppressed: make(map[eventKey]bool),
+		retries:    make(map[int]pendingRetry),
+	}
+}
+
+// hold keeps poll away from the outbox until release is called. The
+// events passed to release are dropped when poll gets to them; they
+// belong to writes that were undone.
+func (d *dispatcher) hold() (release func(drop ...eventKey)) {
+	d.mu.Lock()
+	d.holds++
+	d.mu.Unlock()
+
+	var once sync.Once
+	return func(drop ...eventKey) {
+		once.Do(func() {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			for _, key := range drop {
+				d.suppressed[key] = true
+			}
+			d.holds--
+		})
+	}
+}
+
+// run polls and delivers until ctx is done, then waits for the sends in
+// progress and drains the retries that were still waiting.
+func (d *dispatcher) run(ctx context.Context) {
+	for i := 0; i < webhookWorkers; i++ {
+		d.wg.Add(1)
+		go d.worker(ctx)
+	}
+
+	ticker := time.NewTicker(d.config.PollEvery)
+	defer ticker.Stop()
+	for {
+		if err := d.poll(ctx); err != nil && ctx.Err() == nil {
+			d.logger.Error("outbox poll failed", "error", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			d.wg.Wait()
+			d.drain(context.WithoutCancel(ctx))
+			return
+		}
+	}
+}
+
+// poll queues everything new in the outbox. The cursor moves after each
+// event, so an event is queued once even if poll fails half way. It stops
+// at the first event it reads while a hold is taken; a held write comes
+// after its hold, so its event can not slip out.
+func (d *dispatcher) poll(ctx context.Context) error {
+	cursor, err := d.hooks.Cursor(ctx)
+	if err != nil {
+		return err
+	}
+	for {
+		events, err := d.events.Events(ctx, cursor, outboxBatch)
+		if err != nil || len(events) == 0 {
+			return err
+		}
+		subs, err := d.hooks.ListSubscriptions(ctx)
+		if err != nil {
+			return err
+		}
+		for _, ev := range events {
+			d.mu.Lock()
+			held, drop := d.holds > 0, d.suppressed[keyOf(ev)]
+			if !held {
+				delete(d.suppressed, keyOf(ev))
+			}
+			d.mu.Unlock()
+			if held {
+				return nil
+			}
+
+			for _, sub := range subs {
+				if !drop && sub.wants(ev.Type) {
+					if err := d.enqueue(ctx, delivery{sub: sub, event: ev, attempt: 1}); err != nil {
+						return err
+					}
+				}
+			}
+			cursor = ev.Seq
+			if err := d.hooks.SetCursor(ctx, cursor); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// replay queues the retained outbox events in (after, until] that the
+// subscription wants. until 0 means up to the newest.
+func (d *dispatcher) replay(ctx context.Context, sub Subscription, after, until int64) (int, error) {
+	queued := 0
+	for {
+		events, err := d.events.Events(ctx, after, outboxBatch)
+		if err != nil {
+			return queued, err
+		}
+		for _, ev := range events {
+			if until > 0 && ev.Seq > until {
+				return queued, nil
+			}
+			if sub.wants(ev.Type) {
+				if err := d.enqueue(ctx, delivery{sub: sub, event: ev, attempt: 1}); err != nil {
+					return queued, err
+				}
+				queued++
+			}
+			after = ev.Seq
+		}
+		if len(events) < outboxBatch {
+			return queued, nil
+		}
+	}
+}
+
+// enqueue waits for room in the queue, so a slow receiver slows the poll
+// down instead of piling up memory.
+func (d *dispatcher) enqueue(ctx context.Context, dl delivery) error {
+	select {
+	case d.queue <- dl:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *dispatcher) worker(ctx context.Context) {
+	defer d.wg.Done()
+	for {
+		select {
+		case dl := <-d.queue:
+			d.handle(ctx, dl)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handle makes one attempt and decides what comes after it.
+func (d *dispatcher) handle(ctx context.Context, dl delivery) {
+	// a subscription deleted in the meantime gets nothing more
+	sub, err := d.hooks.GetSubscription(ctx, dl.sub.Id)
+	if err != nil {
+		return
+	}
+	dl.sub = sub
+
+	status, retryAfter, err := d.send(ctx, dl)
+	if err == nil {
+		d.results.add(1, "delivered")
+		return
+	}
+	if ctx.Err() != nil {
+		// cut off by the stop, drain tries it again
+		d.mu.Lock()
+		d.leftover = append(d.leftover, dl)
+		d.mu.Unlock()
+		return
+	}
+
+	retryable := status == 0 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500
+	if retryable && dl.attempt < d.config.MaxAttempts {
+		wait := d.backoff(dl.attempt)
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		if wait > d.config.MaxBackoff {
+			wait = d.config.MaxBackoff
+		}
+		d.results.add(1, "retried")
+		d.logger.Warn("webhook delivery failed, will retry",
+			"subscription_id", sub.Id, "event_id", dl.event.Id, "attempt", dl.attempt, "retry_in", wait.String(), "error", err)
+
+		dl.attempt++
+		d.retry(ctx, dl, wait)
+		return
+	}
+
+	d.deadLetter(ctx, dl, status, err)
+}
+
+// retry queues dl again after wait. Until then it is in d.retries, where
+// drain finds it if run stops first.
+func (d *dispatcher) retry(ctx context.Context, dl delivery, wait time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	id := d.nextRetry
+	d.nextRetry++
+	d.timers.Add(1)
+	timer := time.AfterFunc(wait, func() {
+		defer d.timers.Done()
+		d.mu.Lock()
+		delete(d.retries, id)
+		stopping := d.stopping
+		d.mu.Unlock()
+
+		if stopping || d.enqueue(ctx, dl) != nil {
+			d.mu.Lock()
+			d.leftover = append(d.leftover, dl)
+			d.mu.Unlock()
+		}
+	})
+	d.retries[id] = pendingRetry{timer, dl}
+}
+
+// drain is called once the workers stopped. It makes one more attempt,
+// without waiting for the backoff, at every retry that was pending or
+// queued, and dead letters the ones that fail, so a stop loses nothing.
+func (d *dispatcher) drain(ctx context.Context) {
+	d.mu.Lock()
+	d.stopping = true
+	for id, pending := range d.retries {
+		if pending.timer.Stop() {
+			d.leftover = append(d.leftover, pending.dl)
+			d.timers.Done()
+		}
+		delete(d.retries, id)
+	}
+	d.mu.Unlock()
+	// the timers that did fire leave their delivery in the queue or in
+	// leftover
+	d.timers.Wait()
+
+	d.mu.Lock()
+	deliveries := d.leftover
+	d.leftover = nil
+	d.mu.Unlock()
+	for len(d.queue) > 0 {
+		deliveries = append(deliveries, <-d.queue)
+	}
+	if len(deliveries) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, webhookWorkers)
+	for _, dl := range deliveries {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			sub, err := d.hooks.GetSubscription(ctx, dl.sub.Id)
+			if err != nil {
+				return
+			}
+			dl.sub = sub
+			status, _, err := d.send(ctx, dl)
+			if err == nil {
+				d.results.add(1, "delivered")
+				return
+			}
+			d.deadLetter(ctx, dl, status, err)
+		}()
+	}
+	wg.Wait()
+}
+
+func (d *dispatcher) deadLetter(ctx context.Context, dl delivery, status int, err error) {
+	d.results.add(1, "dead_lettered")
+	d.logger.Error("webhook delivery gave up",
+		"subscription_id", dl.sub.Id, "event_id", dl.event.Id, "attempts", dl.attempt, "error", err)
+	dead := DeadLetter{
+		Id:             newEventId(),
+		SubscriptionId: dl.sub.Id,
+		Event:          dl.event,
+		Attempts:       dl.attempt,
+		LastStatus:     status,
+		LastError:      err.Error(),
+		FailedAt:       d.now().UTC(),
+	}
+	if err := d.hooks.AddDeadLetter(context.WithoutCancel(ctx), dead); err != nil {
+		d.logger.Error("dead letter lost", "event_id", dl.event.Id, "error", err)
+	}
+}
+
+// backoff is Backoff doubled for every attempt so far, capped at
+// MaxBackoff, with the upper half picked at random so receivers that came
+// back are not hit by every retry at once.
+func (d *dispatcher) backoff(attempt int) time.Duration {
+	wait := d.config.Backoff
+	for i := 1; i < attempt && wait < d.config.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > d.config.MaxBackoff {
+		wait = d.config.MaxBackoff
+	}
+	half := wait / 2
+	return half + rand.N(half+1)
+}
+
+// send POSTs the event, signed as the Standard Webhooks spec describes:
+// webhook-signature is "v1," and the base64 HMAC-SHA256 of
+// "<webhook-id>.<webhook-timestamp>.<body>" keyed with the secret. Any 2xx
+// is a success. On failure the status is 0 if no response came back.
+func (d *dispatcher) send(ctx context.Context, dl delivery) (status int, retryAfter time.Duration, err error) {
+	ctx, span := d.tracer.Start(ctx, "webhook "+dl.event.Type, SpanKindClient,
+		Attribute{"url.full", dl.sub.URL},
+		Attribute{"webhook.subscription.id", dl.sub.Id},
+		Attribute{"webhook.event.id", dl.event.Id},
+		Attribute{"webhook.attempt", int64(dl.attempt)},
+	)
+	defer span.End()
+
+	body, err := json.Marshal(dl.event)
+	if err != nil {
+		return 0, 0, err
+	}
+	ts := d.now().Unix()
+	signature, err := signWebhook(dl.sub.Secret, dl.event.Id, ts, body)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dl.sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "courses-api-webhooks/1")
+	req.Header.Set("webhook-id", dl.event.Id)
+	req.Header.Set("webhook-timestamp", strconv.FormatInt(ts, 10))
+	req.Header.Set("webhook-signature", signature)
+	injectTraceContext(ctx, req.Header)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	span.SetAttributes(Attribute{"http.response.status_code", int64(resp.StatusCode)})
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp.StatusCode, 0, nil
+	}
+	err = fmt.Errorf("receiver answered %s", resp.Status)
+	span.RecordError(err)
+	if secs, perr := strconv.Atoi(resp.Header.Get("Retry-After")); perr == nil && secs > 0 {
+		retryAfter = time.Duration(secs) * time.Second
+	}
+	return resp.StatusCode, retryAfter, err
+}
+
+func signWebhook(secret, id string, ts int64, body []byte) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, "whsec_"))
+	if err != nil {
+		return "", errors.New("webhook secret is not base64")
+	}
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s.%d.", id, ts)
+	mac.Write(body)
+	return "v1," + base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
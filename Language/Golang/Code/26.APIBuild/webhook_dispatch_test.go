@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The example of the Standard Webhooks spec.
+func TestSignWebhook(t *testing.T) {
+	got, err := signWebhook("whsec_MfKQ9r8GKYqrTwjUPD8ILPZIo2LaLaSw", "msg_p5jXN8AQM9LWM0D4loKWxJek", 1614265330, []byte(`{"test": 2432232314}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "v1,g0hM9SsE+OTPJTGt/tmIKtSyZlE3uFJELVlNIOLJ1OE="; got != want {
+		t.Errorf("signature = %s, want %s", got, want)
+	}
+	if _, err := signWebhook("whsec_not base64!", "id", 1, nil); err == nil {
+		t.Error("a secret that is not base64 was accepted")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := newTestDispatcher(newMemoryStore(), webhookConfig{Backoff: time.Second, MaxBackoff: 10 * time.Second})
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 500 * time.Millisecond, time.Second},
+		{2, time.Second, 2 * time.Second},
+		{3, 2 * time.Second, 4 * time.Second},
+		{4, 4 * time.Second, 8 * time.Second},
+		{5, 5 * time.Second, 10 * time.Second},
+		{50, 5 * time.Second, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		for range 100 {
+			if got := d.backoff(tt.attempt); got < tt.min || got > tt.max {
+				t.Fatalf("backoff(%d) = %v, want between %v and %v", tt.attempt, got, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+// receiver is a webhook endpoint that answers with statuses in turn, the
+// last one from then on, and checks every signature.
+type receiver struct {
+	*httptest.Server
+	secret string
+
+	mu       sync.Mutex
+	statuses []int
+	got      []Event
+	attempts chan int // the status of every attempt
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	rc := &receiver{secret: newWebhookSecret(), statuses: statuses, attempts: make(chan int, 100)}
+	rc.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		ts, _ := strconv.ParseInt(r.Header.Get("webhook-timestamp"), 10, 64)
+		want, _ := signWebhook(rc.secret, r.Header.Get("webhook-id"), ts, body)
+		if r.Header.Get("webhook-signature") != want || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("bad delivery: %v", r.Header)
+		}
+		var ev Event
+		if err := json.Unmarshal(body, &ev); err != nil || ev.Id != r.Header.Get("webhook-id") {
+			t.Errorf("body %s: %v", body, err)
+		}
+
+		rc.mu.Lock()
+		status := rc.statuses[0]
+		if len(rc.statuses) > 1 {
+			rc.statuses = rc.statuses[1:]
+		}
+		if status < 300 {
+			rc.got = append(rc.got, ev)
+		}
+		rc.mu.Unlock()
+		w.WriteHeader(status)
+		rc.attempts <- status
+	}))
+	t.Cleanup(rc.Close)
+	return rc
+}
+
+func (rc *receiver) received() []Event {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return append([]Event(nil), rc.got...)
+}
+
+func newTestDispatcher(store CourseStore, config webhookConfig) *dispatcher {
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	return newDispatcher(store, newMemoryWebhookStore(), config, newTracer(nil, 1), logger,
+		newMetricVec("counter", "deliveries", "test", "result"))
+}
+
+func subscribe(t *testing.T, d *dispatcher, rc *receiver) Subscription {
+	t.Helper()
+	sub := Subscription{Id: newEventId(), URL: rc.URL, Secret: rc.secret}
+	if err := d.hooks.CreateSubscription(context.Background(), sub); err != nil {
+		t.Fatal(err)
+	}
+	return sub
+}
+
+// startDispatcher runs d until the returned stop is called, which waits
+// for run to return.
+func startDispatcher(t *testing.T, d *dispatcher) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.run(ctx)
+		close(done)
+	}()
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			cancel()
+			<-done
+		})
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+func TestDispatcherDelivery(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	d := newTestDispatcher(store, webhookConfig{PollEvery: 5 * time.Millisecond, MaxAttempts: 3, Backoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond})
+
+	flaky := newReceiver(t, 503, 500, 200)
+	down := newReceiver(t, 502)
+	refusing := newReceiver(t, 400)
+	subs := map[*receiver]Subscription{}
+	for _, rc := range []*receiver{flaky, down, refusing} {
+		subs[rc] = subscribe(t, d, rc)
+	}
+	startDispatcher(t, d)
+
+	course, err := store.Create(ctx, Course{CourseId: "go", CourseName: "Go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, func() bool { return len(flaky.received()) == 1 })
+	got := flaky.received()[0]
+	if got.Type != EventCourseCreated || got.CourseId != "go" || got.Version != course.Version || got.Course == nil || got.Course.CourseName != "Go" {
+		t.Errorf("delivered %+v", got)
+	}
+
+	deadLetters := func() map[string]DeadLetter {
+		list, err := d.hooks.ListDeadLetters(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := map[string]DeadLetter{}
+		for _, dl := range list {
+			out[dl.SubscriptionId] = dl
+		}
+		return out
+	}
+	waitFor(t, func() bool { return len(deadLetters()) == 2 })
+	dead := deadLetters()
+	if dl := dead[subs[down].Id]; dl.Attempts != 3 || dl.LastStatus != 502 || dl.Event.Id != got.Id {
+		t.Errorf("dead letter of the receiver that is down = %+v, want 3 attempts", dl)
+	}
+	if dl := dead[subs[refusing].Id]; dl.Attempts != 1 || dl.LastStatus != 400 {
+		t.Errorf("dead letter of the refusing receiver = %+v, want 1 attempt, 4xx is not retried", dl)
+	}
+	if _, ok := dead[subs[flaky].Id]; ok {
+		t.Error("the flaky receiver got a dead letter")
+	}
+}
+
+// TestDispatcherDrain stops the dispatcher while retries wait out a long
+// backoff. They have to be sent once more on the way out, and dead
+// lettered if that fails too.
+func TestDispatcherDrain(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	d := newTestDispatcher(store, webhookConfig{PollEvery: 5 * time.Millisecond, MaxAttempts: 5, Backoff: time.Hour, MaxBackoff: time.Hour})
+
+	back := newReceiver(t, 500, 200)
+	down := newReceiver(t, 500)
+	subscribe(t, d, back)
+	subscribe(t, d, down)
+	stop := startDispatcher(t, d)
+
+	if _, err := store.Create(ctx, Course{CourseId: "go", CourseName: "Go"}); err != nil {
+		t.Fatal(err)
+	}
+	<-back.attempts
+	<-down.attempts
+	waitFor(t, func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return len(d.retries) == 2
+	})
+
+	stop()
+	if got := back.received(); len(got) != 1 {
+		t.Errorf("the retry was not sent on stop, got %v", got)
+	}
+	dead, err := d.hooks.ListDeadLetters(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].Attempts != 2 || dead[0].LastStatus != 500 {
+		t.Errorf("dead letters = %+v, want the retry that failed again", dead)
+	}
+}
+
+func TestDispatcherHold(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	d := newTestDispatcher(store, webhookConfig{})
+	subscribe(t, d, newReceiver(t, 200))
+
+	release := d.hold()
+	kept, err := store.Create(ctx, Course{CourseId: "kept", CourseName: "Kept"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	undone, err := store.Create(ctx, Course{CourseId: "undone", CourseName: "Undone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, "undone", undone.Version); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.queue) != 0 {
+		t.Fatalf("%d deliveries queued during a hold", len(d.queue))
+	}
+
+	release(eventKey{EventCourseCreated, "undone", undone.Version}, eventKey{EventCourseDeleted, "undone", undone.Version})
+	if err := d.poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.queue) != 1 {
+		t.Fatalf("%d deliveries queued, want the kept course only", len(d.queue))
+	}
+	if dl := <-d.queue; dl.event.CourseId != "kept" || dl.event.Version != kept.Version {
+		t.Errorf("queued %+v", dl.event)
+	}
+	if len(d.suppressed) != 0 {
+		t.Errorf("suppressed = %v after poll saw the events", d.suppressed)
+	}
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// maxDeadLetters is how many dead letters are kept, the oldest go first.
+const maxDeadLetters = 10000
+
+var ErrSubscriptionNotFound = errors.New("webhook subscription not found")
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// adminRoles may manage webhooks and look at the outbox.
+var adminRoles = []string{"admin"}
+
+// eventTypes are the events a subscription can ask for.
+var eventTypes = []string{EventCourseCreated, EventCourseUpdated, EventCourseDeleted}
+
+// Subscription is a URL that gets the course events. Secret signs the
+// deliveries; it is only shown in the answer to the create request.
+type Subscription struct {
+	Id  string `json:"id"`
+	URL string `json:"url" validate:"required,url,max=2000"`
+	// Events limits the subscription to some event types, empty is all.
+	Events    []string  `json:"events,omitempty"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (sub Subscription) wants(eventType string) bool {
+	return len(sub.Events) == 0 || slices.Contains(sub.Events, eventType)
+}
+
+// DeadLetter is an event that could not be delivered to a subscription,
+// kept until an admin retries or drops it.
+type DeadLetter struct {
+	Id             string    `json:"id"`
+	SubscriptionId string    `json:"subscriptionId"`
+	Event          Event     `json:"event"`
+	Attempts       int       `json:"attempts"`
+	LastStatus     int       `json:"lastStatus,omitempty"`
+	LastError      string    `json:"lastError"`
+	FailedAt       time.Time `json:"failedAt"`
+}
+
+// WebhookStore keeps subscriptions, how far the dispatcher got in the
+// outbox and the dead letters. Like AuthStore it is an interface so the
+// in-memory version can be swapped for a shared one.
+type WebhookStore interface {
+	ListSubscriptions(ctx context.Context) ([]Subscription, error)
+	GetSubscription(ctx context.Context, id string) (Subscription, error)
+	CreateSubscription(ctx context.Context, sub Subscription) error
+	DeleteSubscription(ctx context.Context, id string) error
+
+	// Cursor is the Seq of the last outbox event the dispatcher took.
+	Cursor(ctx context.Context) (int64, error)
+	SetCursor(ctx context.Context, seq int64) error
+
+	AddDeadLetter(ctx context.Context, dl DeadLetter) error
+	ListDeadLetters(ctx context.Context) ([]DeadLetter, error)
+	// TakeDeadLetter removes a dead letter and returns it.
+	TakeDeadLetter(ctx context.Context, id string) (DeadLetter, error)
+}
+
+// memoryWebhookStore is the WebhookStore of a single process. Its cursor
+// starts at 0, but with no subscriptions yet the events before startup
+// are skipped rather than delivered.
+type memoryWebhookStore struct {
+	mu          sync.Mutex
+	subs        []Subscription
+	cursor      int64
+	deadLetters []DeadLetter
+}
+
+func newMemoryWebhookStore() *memoryWebhookStore {
+	return &memoryWebhookStore{}
+}
+
+func (s *memoryWebhookStore) ListSubscriptions(ctx context.Context) ([]Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.subs), nil
+}
+
+func (s *memoryWebhookStore) GetSubscription(ctx context.Context, id string) (Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.subs, func(sub Subscription) bool { return sub.Id == id })
+	if i < 0 {
+		return Subscription{}, ErrSubscriptionNotFound
+	}
+	return s.subs[i], nil
+}
+
+func (s *memoryWebhookStore) CreateSubscription(ctx context.Context, sub Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subs = append(s.subs, sub)
+	return nil
+}
+
+func (s *memoryWebhookStore) DeleteSubscription(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.subs, func(sub Subscription) bool { return sub.Id == id })
+	if i < 0 {
+		return ErrSubscriptionNotFound
+	}
+	s.subs = slices.Delete(s.subs, i, i+1)
+	return nil
+}
+
+func (s *memoryWebhookStore) Cursor(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cursor, nil
+}
+
+func (s *memoryWebhookStore) SetCursor(ctx context.Context, seq int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cursor = seq
+	return nil
+}
+
+func (s *memoryWebhookStore) AddDeadLetter(ctx context.Context, dl DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deadLetters = append(s.deadLetters, dl)
+	if len(s.deadLetters) > maxDeadLetters {
+		s.deadLetters = s.deadLetters[len(s.deadLetters)-maxDeadLetters:]
+	}
+	return nil
+}
+
+func (s *memoryWebhookStore) ListDeadLetters(ctx context.Context) ([]DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.deadLetters), nil
+}
+
+func (s *memoryWebhookStore) TakeDeadLetter(ctx context.Context, id string) (DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.deadLetters, func(dl DeadLetter) bool { return dl.Id == id })
+	if i < 0 {
+		return DeadLetter{}, ErrDeadLetterNotFound
+	}
+	dl := s.deadLetters[i]
+	s.deadLetters = slices.Delete(s.deadLetters, i, i+1)
+	return dl, nil
+}
+
+// newWebhookSecret makes a secret in the whsec_ form of the Standard
+// Webhooks spec, so the receivers' libraries can check the signatures.
+func newWebhookSecret() string {
+	var b [24]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return "whsec_" + base64.StdEncoding.EncodeToString(b[:])
+}
+
+// subscriptionRequest is the body of POST /admin/webhooks.
+type subscriptionRequest struct {
+	URL    string   `json:"url" validate:"required,max=2000"`
+	Events []string `json:"events,omitempty"`
+}
+
+type subscriptionList struct {
+	Subscriptions []Subscription `json:"subscriptions"`
+	Total         int            `json:"total"`
+}
+
+func (s *server) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	subs, err := s.webhooks.hooks.ListSubscriptions(r.Context())
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	if subs == nil {
+		subs = []Subscription{}
+	}
+	for i := range subs {
+		subs[i].Secret = ""
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(subscriptionList{Subscriptions: subs, Total: len(subs)})
+}
+
+// createWebhook answers with the secret. It is not shown again.
+func (s *server) createWebhook(w http.ResponseWriter, r *http.Request) {
+	var req subscriptionRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	if err := validateStruct(req); err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	var errs ValidationErrors
+	if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, FieldError{Field: "url", Message: "must be an absolute http or https URL"})
+	}
+	for i, typ := range req.Events {
+		if !slices.Contains(eventTypes, typ) {
+			errs = append(errs, FieldError{Field: fmt.Sprintf("events[%d]", i), Message: "must be one of course.created, course.updated, course.deleted"})
+		}
+	}
+	if len(errs) > 0 {
+		writeProblem(w, r, errs)
+		return
+	}
+
+	sub := Subscription{
+		Id:        s.ids.NewId(),
+		URL:       req.URL,
+		Events:    req.Events,
+		Secret:    newWebhookSecret(),
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := s.webhooks.hooks.CreateSubscription(r.Context(), sub); err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/admin/webhooks/"+sub.Id)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(sub)
+}
+
+func (s *server) getWebhook(w http.ResponseWriter, r *http.Request) {
+	sub, err := s.webhooks.hooks.GetSubscription(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	sub.Secret = ""
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sub)
+}
+
+// deleteWebhook also stops the retries still pending for it.
+func (s *server) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if err := s.webhooks.hooks.DeleteSubscription(r.Context(), mux.Vars(r)["id"]); err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// replayRequest picks the outbox events to send again: those with a Seq
+// above After and, if Until is set, not above Until.
+type replayRequest struct {
+	After int64 `json:"after" validate:"min=0"`
+	Until int64 `json:"until,omitempty" validate:"min=0"`
+}
+
+type replayResult struct {
+	Queued int `json:"queued"`
+}
+
+// replayWebhook queues outbox events for one subscription again, the
+// ones it would get anyway. They keep their ids.
+func (s *server) replayWebhook(w http.ResponseWriter, r *http.Request) {
+	sub, err := s.webhooks.hooks.GetSubscription(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	var req replayRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	if err := validateStruct(req); err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	queued, err := s.webhooks.replay(r.Context(), sub, req.After, req.Until)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(replayResult{Queued: queued})
+}
+
+// eventPage is one page of the outbox. NextAfter is the after= of the
+// next page.
+type eventPage struct {
+	Events    []Event `json:"events"`
+	NextAfter int64   `json:"nextAfter,omitempty"`
+}
+
+// listEvents answers GET /admin/events?after=&limit= from the outbox.
+func (s *server) listEvents(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	var after int64
+	if v := values.Get("after"); v != "" {
+		var err error
+		if after, err = strconv.ParseInt(v, 10, 64); err != nil || after < 0 {
+			writeProblem(w, r, newProblem(http.StatusBadRequest, "after must be a number of at least 0"))
+			return
+		}
+	}
+	limit := defaultPageLimit
+	if v := values.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxPageLimit {
+			writeProblem(w, r, newProblem(http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxPageLimit)))
+			return
+		}
+	}
+
+	events, err := s.store.Events(r.Context(), after, limit)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	page := eventPage{Events: events}
+	if len(events) == limit {
+		page.NextAfter = events[len(events)-1].Seq
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+type deadLetterList struct {
+	DeadLetters []DeadLetter `json:"deadLetters"`
+	Total       int          `json:"total"`
+}
+
+func (s *server) listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	dls, err := s.webhooks.hooks.ListDeadLetters(r.Context())
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	if dls == nil {
+		dls = []DeadLetter{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deadLetterList{DeadLetters: dls, Total: len(dls)})
+}
+
+// retryDeadLetter takes the event off the dead letter list and delivers
+// it again with a fresh set of attempts.
+func (s *server) retryDeadLetter(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	dl, err := s.webhooks.hooks.TakeDeadLetter(ctx, mux.Vars(r)["id"])
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	sub, err := s.webhooks.hooks.GetSubscription(ctx, dl.SubscriptionId)
+	if err == nil {
+		err = s.webhooks.enqueue(ctx, delivery{sub: sub, event: dl.Event, attempt: 1})
+	}
+	if err != nil {
+		// put it back, nothing was sent
+		s.webhooks.hooks.AddDeadLetter(context.WithoutCancel(ctx), dl)
+		writeProblem(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(replayResult{Queued: 1})
+}
+
+func (s *server) deleteDeadLetter(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.webhooks.hooks.TakeDeadLetter(r.Context(), mux.Vars(r)["id"]); err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
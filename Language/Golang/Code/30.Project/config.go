@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// config is how the HTTP server listens. Every field is a flag and can
+// also come from the environment (the flag name in upper snake case, e.g.
+// READ_TIMEOUT) or a JSON config file; flags win over the environment,
+// which wins over the file.
+type config struct {
+	Port              int
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	TLSCertFile       string
+	TLSKeyFile        string
+	ShutdownTimeout   time.Duration
+}
+
+// loadConfig parses args into a config. The file and the environment are
+// applied through fs.Set, so every source is parsed by the flag package.
+func loadConfig(fs *flag.FlagSet, args []string) (config, error) {
+	var cfg config
+	fs.IntVar(&cfg.Port, "port", 8080, "port to listen on")
+	fs.DurationVar(&cfg.ReadTimeout, "read-timeout", 15*time.Second, "longest time to read a request, body included")
+	fs.DurationVar(&cfg.ReadHeaderTimeout, "read-header-timeout", 5*time.Second, "longest time to read the request headers")
+	fs.DurationVar(&cfg.WriteTimeout, "write-timeout", 30*time.Second, "longest time to write a response")
+	fs.DurationVar(&cfg.IdleTimeout, "idle-timeout", 2*time.Minute, "how long a keep-alive connection may sit idle")
+	fs.IntVar(&cfg.MaxHeaderBytes, "max-header-bytes", 1<<20, "largest request header block accepted")
+	fs.StringVar(&cfg.TLSCertFile, "tls-cert", "", "PEM certificate, serves HTTPS together with -tls-key")
+	fs.StringVar(&cfg.TLSKeyFile, "tls-key", "", "PEM private key of -tls-cert")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", 25*time.Second, "how long to drain requests after SIGINT or SIGTERM")
+	configPath := fs.String("config", os.Getenv("CONFIG_FILE"), "JSON file with settings keyed by flag name")
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	set := func(name, value string) error {
+		if given[name] {
+			return nil
+		}
+		return fs.Set(name, value)
+	}
+
+	if *configPath != "" {
+		values, err := readConfigFile(*configPath)
+		if err != nil {
+			return cfg, err
+		}
+		for name, value := range values {
+			if name == "config" || fs.Lookup(name) == nil {
+				return cfg, fmt.Errorf("%s: unknown setting %q", *configPath, name)
+			}
+			if err := set(name, value); err != nil {
+				return cfg, fmt.Errorf("%s: %s: %w", *configPath, name, err)
+			}
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		env := strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if value, ok := os.LookupEnv(env); ok && err == nil && f.Name != "config" {
+			if err = set(f.Name, value); err != nil {
+				err = fmt.Errorf("env %s: %w", env, err)
+			}
+		}
+	})
+	if err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.validate()
+}
+
+// readConfigFile reads a JSON object like {"port": 8080, "read-timeout": "10s"}.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var values map[string]any
+	if err := dec.Decode(&values); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	out := make(map[string]string, len(values))
+	for name, value := range values {
+		out[name] = fmt.Sprint(value)
+	}
+	return out, nil
+}
+
+func (c config) validate() error {
+	if c.Port < 1 || c.Port > 65535 {
+		return fmt.Errorf("port %d is out of range", c.Port)
+	}
+	if c.MaxHeaderBytes < 1 {
+		return errors.New("max-header-bytes must be positive")
+	}
+	for _, d := range []time.Duration{c.ReadTimeout, c.ReadHeaderTimeout, c.WriteTimeout, c.IdleTimeout, c.ShutdownTimeout} {
+		if d < 0 {
+			return errors.New("timeouts can not be negative")
+		}
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return errors.New("tls-cert and tls-key go together")
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func load(t *testing.T, file string, args ...string) (config, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	if file != "" {
+		path := filepath.Join(t.TempDir(), "config.json")
+		if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		args = append([]string{"-config", path}, args...)
+	}
+	return loadConfig(fs, args)
+}
+
+// TestConfigSources checks that the file beats the defaults, the
+// environment beats the file and a flag beats the environment.
+func TestConfigSources(t *testing.T) {
+	t.Setenv("READ_TIMEOUT", "7s")
+	t.Setenv("PORT", "5000")
+
+	cfg, err := load(t, `{"port": 9000, "read-timeout": "10s", "idle-timeout": "1m"}`, "-port", "6000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := config{
+		Port:              6000,
+		ReadTimeout:       7 * time.Second,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       time.Minute,
+		MaxHeaderBytes:    1 << 20,
+		ShutdownTimeout:   25 * time.Second,
+	}
+	if cfg != want {
+		t.Errorf("config = %+v, want %+v", cfg, want)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  []string
+		args []string
+		want string
+	}{
+		{"port range", "", nil, []string{"-port", "0"}, "port 0 is out of range"},
+		{"negative timeout", "", nil, []string{"-idle-timeout", "-1s"}, "timeouts can not be negative"},
+		{"half tls", "", nil, []string{"-tls-key", "key.pem"}, "tls-cert and tls-key go together"},
+		{"bad flag", "", nil, []string{"-port", "http"}, "invalid value"},
+		{"bad env", "", []string{"WRITE_TIMEOUT", "forever"}, nil, "env WRITE_TIMEOUT"},
+		{"unknown file key", `{"prot": 80}`, nil, nil, `unknown setting "prot"`},
+		{"config in file", `{"config": "other.json"}`, nil, nil, `unknown setting "config"`},
+		{"bad file value", `{"max-header-bytes": "lots"}`, nil, nil, "max-header-bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i+1 < len(tt.env); i += 2 {
+				t.Setenv(tt.env[i], tt.env[i+1])
+			}
+			_, err := load(t, tt.file, tt.args...)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
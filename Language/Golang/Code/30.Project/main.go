@@ -1,30 +1,48 @@
-package project
+package main
 
 import (
+	"flag"
 	"fmt"
+	"log"
 	"net/http"
+	"os"
 )
 
-func helloHandler(w http.ResponseWriter, r *http.Request){
-	fmt.Fprintf(w, "Hello World");
+func helloHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintf(w, "Hello World")
 }
 
-func aboutHandler(w http.ResponseWriter, r *http.Request){
-	fmt.Fprintf(w, "About Page");
+func aboutHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintf(w, "About Page")
 }
 
-
-func main(){
- mux :=	http.NewServeMux();
- mux.HandleFunc("/hello", helloHandler);
-
- mux.HandleFunc("/about", aboutHandler);
-
- fmt.Println("Server is running on port 8080");
- err := http.ListenAndServe(":8080", mux);
-
- if err != nil{
-	fmt.Println(err);
- }
-
-}
\ No newline at end of file
+func main() {
+	cfg, err := loadConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	h := &health{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello", helloHandler)
+	mux.HandleFunc("/about", aboutHandler)
+	mux.HandleFunc("GET /healthz", h.liveness)
+	mux.HandleFunc("GET /readyz", h.readiness)
+
+	srv := &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.Port),
+		Handler:           mux,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+
+	fmt.Println("Server is running on port", cfg.Port)
+	if err := serve(srv, cfg, h); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("Server stopped")
+}